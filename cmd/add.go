@@ -25,6 +25,28 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// addOptions holds the attributes of a new profile as passed with flags.
+// Values left empty are asked for.
+type addOptions struct {
+	name          string
+	email         string
+	origin        string
+	signingKey    string
+	signingFormat string
+	signCommits   bool
+	signTags      bool
+	sshKey        string
+	paths         []string
+	priority      int
+	// remoteName is the remote the suggested origin is taken from, the preferred one if empty.
+	remoteName string
+	// layer is the config layer the profile is added to, the user layer if empty.
+	layer string
+}
+
+// addOpts holds the flags of the add command.
+var addOpts addOptions
+
 var addCmd = &cobra.Command{
 	Use:     "add [profile-name]",
 	Args:    cobra.MaximumNArgs(1),
@@ -39,6 +61,10 @@ Use flags to provide them directly.
 The origin of your current repository will already be filled in
 and subject to confirm or change.
 
+The profile is saved in your user config file. Use --layer repo to add it to the .git-profile.toml
+of the current repository instead, to share it by committing that file, or --layer system to add it
+to the config file shared by all users of the machine.
+
 Examples:
   # Add a profile interactively
   git-profile add
@@ -49,8 +75,20 @@ Examples:
   # Add a profile with flags
   git-profile add myprofile --name "John Doe" --email "john@example.com" --origin "github.com"
 
+  # Add a profile for all repositories of an organization
+  git-profile add work --name "John Doe" --email "john@company.com" --origin "github.com/my-employer/*"
+
   # Add a profile with auto-detected origin
   git-profile add myprofile --name "John Doe" --email "john@example.com" --origin auto
+
+  # Add a profile that signs commits with a GPG key
+  git-profile add myprofile --name "John Doe" --email "john@example.com" --origin github.com --signing-key 3AA5C34371567BD2 --sign-commits
+
+  # Add a profile that pushes with a dedicated SSH key
+  git-profile add work --name "John Doe" --email "john@company.com" --origin github.com --ssh-key ~/.ssh/id_work
+
+  # Add a profile for all repositories below a directory, even if other profiles share the origin
+  git-profile add acme --name "John Doe" --email "john@acme.com" --origin github.com --path "~/work/clients/acme/**"
 `,
 	Run: runAdd,
 }
@@ -59,38 +97,63 @@ Examples:
 // It creates a new git profile with the specified name, email, and origin.
 // If values are not provided via flags, it prompts the user for input.
 func runAdd(_ *cobra.Command, args []string) {
+	var result ActionResult
+	result.Add(addProfile(args, addOpts))
+	render(&result)
+}
+
+// addProfile creates a new profile from opts, prompting for missing values, and returns the action taken.
+// It takes the profile name from args if given.
+func addProfile(args []string, opts addOptions) Action {
+	layer := opts.layer
+	if layer == "" {
+		layer = internal.LayerUser
+	}
+	if layer != internal.LayerSystem && layer != internal.LayerUser && layer != internal.LayerRepo {
+		failf(ExitUsage, "invalid layer %q, use system, user or repo", layer)
+	}
+
 	reader := bufio.NewReader(os.Stdin)
 
+	var profileName string
 	if len(args) == 0 {
-		fmt.Print("Short name of the profile: ")
+		prompt("Short name of the profile: ")
 		profileName, _ = reader.ReadString('\n')
 		profileName = strings.TrimSpace(profileName)
 	} else {
 		profileName = args[0]
 	}
 
-	if (models.ProfileConfig{}) != internal.GetProfileByName(profileName) {
-		fmt.Printf("Profile %s already exists\n", profileName)
-		return
+	if err := internal.ValidateProfileName(profileName); err != nil {
+		fail(ExitUsage, err)
+	}
+
+	if store.GetProfileByName(profileName).ProfileName != "" {
+		failf(ExitError, "profile %s already exists", profileName)
 	}
 
+	name := opts.name
 	if name == "" {
-		fmt.Print("Name: ")
+		prompt("Name: ")
 		name, _ = reader.ReadString('\n')
 		name = strings.TrimSpace(name)
 	}
 
+	email := opts.email
 	if email == "" {
-		fmt.Print("E-mail: ")
+		prompt("E-mail: ")
 		email, _ = reader.ReadString('\n')
 		email = strings.TrimSpace(email)
 	}
 
-	currentOrigin, _ := internal.GetRepoOrigin()
+	currentOrigin := ""
+	if currentRemote, err := git.GetRepoRemoteByName(opts.remoteName); err == nil {
+		currentOrigin = currentRemote.URL.Host
+	}
 	newOrigin := ""
 
-	if origin == "" {
-		fmt.Printf("Origin (enter to accept %s): ", currentOrigin)
+	if opts.origin == "" {
+		prompt("Origin (enter to accept %s): ", currentOrigin)
 
 		newOrigin, _ = reader.ReadString('\n')
 		newOrigin = strings.TrimSpace(newOrigin)
@@ -99,33 +162,63 @@ func runAdd(_ *cobra.Command, args []string) {
 			newOrigin = currentOrigin
 		}
 	} else {
-		if origin == "auto" {
+		if opts.origin == "auto" {
 			newOrigin = currentOrigin
 		} else {
-			newOrigin = origin
+			newOrigin = opts.origin
 		}
 	}
 
 	newProfile := models.ProfileConfig{
-		ProfileName: profileName,
-		Name:        name,
-		Email:       email,
-		Origin:      newOrigin,
+		ProfileName:   profileName,
+		Name:          name,
+		Email:         email,
+		Origin:        newOrigin,
+		SigningKey:    opts.signingKey,
+		SigningFormat: opts.signingFormat,
+		SignCommits:   opts.signCommits,
+		SignTags:      opts.signTags,
+		SSHKey:        opts.sshKey,
 	}
 
-	err := internal.AddProfile(newProfile)
+	for _, path := range opts.paths {
+		newProfile.Rules = append(newProfile.Rules, models.Rule{Path: path, Priority: opts.priority})
+	}
+
+	if err := internal.ValidateProfile(newProfile); err != nil {
+		fail(ExitUsage, err)
+	}
+
+	err := store.AddProfileTo(layer, newProfile)
 	if err != nil {
-		fmt.Println("Error adding profile:", err)
-		os.Exit(1)
+		failf(ExitError, "error adding profile: %v", err)
 	}
 
-	fmt.Printf("Added profile: %s for origin %s\n", profileName, newOrigin)
+	message := fmt.Sprintf("Added profile: %s for origin %s", profileName, newOrigin)
+	if layer != internal.LayerUser {
+		message += fmt.Sprintf(" to the %s layer", layer)
+	}
+	return Action{
+		Action:  ActionAdd,
+		Profile: profileName,
+		Target:  layer,
+		Message: message,
+	}
 }
 
 func init() {
 	rootCmd.AddCommand(addCmd)
-	addCmd.Flags().StringVarP(&name, "name", "n", "", "Set the name directly")
-	addCmd.Flags().StringVarP(&email, "email", "e", "", "Set the email directly")
-	addCmd.Flags().StringVarP(&origin, "origin", "o", "", "Set the origin directly."+
+	addCmd.Flags().StringVarP(&addOpts.name, "name", "n", "", "Set the name directly")
+	addCmd.Flags().StringVarP(&addOpts.email, "email", "e", "", "Set the email directly")
+	addCmd.Flags().StringVarP(&addOpts.origin, "origin", "o", "", "Set the origin directly."+
 		" Type \"auto\" to accept origin of the current repository")
+	addCmd.Flags().StringVar(&addOpts.signingKey, "signing-key", "", "Set the signing key (GPG key ID, X.509 ID or SSH public key path)")
+	addCmd.Flags().StringVar(&addOpts.signingFormat, "signing-format", "", "Set the signing format (openpgp, ssh or x509)")
+	addCmd.Flags().BoolVar(&addOpts.signCommits, "sign-commits", false, "Sign commits with the signing key")
+	addCmd.Flags().BoolVar(&addOpts.signTags, "sign-tags", false, "Sign tags with the signing key")
+	addCmd.Flags().StringVar(&addOpts.sshKey, "ssh-key", "", "Set the SSH identity file used for fetching and pushing")
+	addCmd.Flags().StringArrayVar(&addOpts.paths, "path", nil, "Add a path rule matching repositories below a directory glob (e.g. ~/work/clients/acme/**). Can be repeated")
+	addCmd.Flags().IntVar(&addOpts.priority, "priority", 0, "Set the priority of the path rules. Higher priorities win")
+	addCmd.Flags().StringVarP(&addOpts.remoteName, "remote", "r", "", "Take the suggested origin from this remote instead of the preferred one")
+	addCmd.Flags().StringVar(&addOpts.layer, "layer", "", "Add the profile to this config layer: system, user (default) or repo")
 }
@@ -0,0 +1,125 @@
+// Package cmd
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/Shieldine/git-profile/internal"
+	"github.com/Shieldine/git-profile/models"
+	"github.com/spf13/cobra"
+)
+
+// amendDryRun holds the --dry-run flag of the amend command.
+var amendDryRun bool
+
+// amendCmd represents the amend command for re-authoring the last commits
+var amendCmd = &cobra.Command{
+	Use:   "amend [count]",
+	Args:  cobra.MaximumNArgs(1),
+	Short: "Re-author the last commits with the current identity or a profile",
+	Long: `Give the last [count] commits (default: 1) of the current branch the current identity,
+or the identity of a profile with --profile. Author and committer are both replaced,
+while messages, dates and contents are kept. Commits merged in by a merge among them are left alone.
+
+If the identity has a signing key, the commits are signed again with it, in the signing format
+of the profile. Without one, existing signatures would be dropped, since they don't survive the
+rewrite, so signed commits are only rewritten with --force. --dry-run lists them.
+
+Commits that are already pushed are only rewritten with --force as well. Before the branch is moved,
+its old head is saved as refs/git-profile/backup/<timestamp>.
+
+Examples:
+  # Fix the last commit after setting the right profile
+  git-profile init
+  git-profile amend
+
+  # Re-author the last three commits with the work profile
+  git-profile amend 3 --profile work
+`,
+	Run: runAmend,
+}
+
+// runAmend handles the amend command execution.
+func runAmend(cmd *cobra.Command, args []string) {
+	selected, _ := cmd.Flags().GetString("profile")
+	force, _ := cmd.Flags().GetBool("force")
+
+	requireRepo()
+
+	count := 1
+	if len(args) == 1 {
+		var err error
+		count, err = strconv.Atoi(args[0])
+		if err != nil {
+			failf(ExitUsage, "invalid number of commits %q", args[0])
+		}
+	}
+
+	profile, target, err := getAmendProfile(selected)
+	if err != nil {
+		fail(ExitError, err)
+	}
+
+	revRange, err := internal.LastCommitsRange(git.Dir(), count)
+	if err != nil {
+		fail(ExitError, err)
+	}
+
+	// amend always signs when there is a key, not only if the profile signs commits by default
+	signing := profile
+	signing.SignCommits = profile.SigningKey != ""
+
+	result, err := internal.RewriteHistory(git.Dir(), internal.HistoryRewrite{
+		Range:       revRange,
+		FirstParent: true,
+		Rewrite:     internal.SetIdentity(profile, signing.SignCommits),
+		Signing:     signing,
+		Force:       force,
+		DryRun:      amendDryRun,
+	})
+	if err != nil {
+		fail(ExitError, err)
+	}
+
+	render(RewriteResult{HistoryRewriteResult: result, Identity: target, DryRun: amendDryRun})
+}
+
+// getAmendProfile returns the profile passed with --profile, or the identity in effect for the repository.
+// The second return value describes the identity for messages.
+func getAmendProfile(selected string) (models.ProfileConfig, string, error) {
+	if selected != "" {
+		profile := store.GetProfileByName(selected)
+		if profile.ProfileName == "" {
+			return models.ProfileConfig{}, "", &CommandError{Code: ExitNotFound, Err: fmt.Errorf("profile %s doesn't exist", selected)}
+		}
+		return profile, "profile " + profile.ProfileName, nil
+	}
+
+	profile, err := internal.GetEffectiveProfile(git.Dir())
+	if err != nil {
+		return models.ProfileConfig{}, "", err
+	}
+	return profile, fmt.Sprintf("%s <%s>", profile.Name, profile.Email), nil
+}
+
+func init() {
+	rootCmd.AddCommand(amendCmd)
+
+	amendCmd.Flags().StringP("profile", "p", "", "Use the identity of this profile instead of the current one")
+	amendCmd.Flags().Bool("force", false, "Also rewrite commits that are already pushed or would lose their signature")
+	amendCmd.Flags().BoolVar(&amendDryRun, "dry-run", false, "Only show which commits would be rewritten")
+}
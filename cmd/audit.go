@@ -0,0 +1,173 @@
+// Package cmd
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/Shieldine/git-profile/internal"
+	"github.com/spf13/cobra"
+)
+
+// auditRecursive holds the --recursive flag of the audit command.
+var auditRecursive bool
+
+// auditCmd represents the audit command for finding commits made with the wrong identity
+var auditCmd = &cobra.Command{
+	Use:   "audit [path]",
+	Args:  cobra.MaximumNArgs(1),
+	Short: "Find commits made with the wrong identity",
+	Long: `Walk the history of a repository and compare the author and committer of each commit
+with the profile expected for the repository's origin. Commits made with another identity
+are listed, grouped by identity. If several profiles match, any of them is accepted.
+
+[path] defaults to the current directory. If it isn't inside a repository, or --recursive is set,
+all repositories below it are audited. Repositories without a matching profile are skipped.
+
+By default, all commits reachable from HEAD are checked. Use --range to limit the audit,
+e.g. to the commits not pushed yet. Commits applied by others, e.g. merged through a web
+interface, have a foreign committer; use --author-only to ignore committers.
+
+The exit status is 5 if any commit with a wrong identity was found, and 1 if a repository couldn't be audited.
+
+Examples:
+  # Audit the whole history of the current repository
+  git-profile audit
+
+  # Audit the commits not on the remote yet
+  git-profile audit --range origin/main..HEAD
+
+  # Audit all repositories below ~/work as JSON
+  git-profile audit ~/work --output json
+`,
+	Run: runAudit,
+}
+
+// AuditResult lists the audit reports of all audited repositories.
+type AuditResult []internal.AuditReport
+
+// Text prints the reports through printAuditReports.
+func (r AuditResult) Text(w io.Writer) {
+	printAuditReports(w, r)
+}
+
+// runAudit handles the audit command execution.
+func runAudit(cmd *cobra.Command, args []string) {
+	revRange, _ := cmd.Flags().GetString("range")
+	authorOnly, _ := cmd.Flags().GetBool("author-only")
+
+	if err := internal.ValidateRevRange(revRange); err != nil {
+		fail(ExitUsage, err)
+	}
+
+	root := "."
+	if len(args) == 1 {
+		root = args[0]
+	}
+	root = resolvePath(root)
+
+	repoPaths, err := getAuditPaths(root)
+	if err != nil {
+		fail(ExitError, err)
+	}
+
+	reports := internal.AuditRepos(store.Config().GitSettings(), internal.RepoProfiles(store), repoPaths, revRange, !authorOnly)
+
+	code := ExitOK
+	for _, report := range reports {
+		if report.Error != "" {
+			code = ExitError
+			break
+		}
+		if len(report.Offenders) != 0 {
+			code = ExitMismatch
+		}
+	}
+
+	renderAndExit(AuditResult(reports), code)
+}
+
+// getAuditPaths returns the repository containing root, or all repositories below it
+// if root isn't inside one or --recursive is set.
+func getAuditPaths(root string) ([]string, error) {
+	if !auditRecursive {
+		output, err := exec.Command("git", "-C", root, "rev-parse", "--show-toplevel").Output()
+		if err == nil {
+			return []string{strings.TrimSpace(string(output))}, nil
+		}
+	}
+
+	repoPaths, err := internal.FindWorkTrees(root)
+	if err != nil {
+		return nil, err
+	}
+	if len(repoPaths) == 0 {
+		return nil, fmt.Errorf("no repositories found below %s", root)
+	}
+	return repoPaths, nil
+}
+
+// printAuditReports prints the audit results per repository to w, with the offending commits grouped by identity.
+func printAuditReports(w io.Writer, reports []internal.AuditReport) {
+	workingDir := git.Dir()
+	if workingDir == "" {
+		workingDir, _ = os.Getwd()
+	}
+
+	for _, report := range reports {
+		repoPath := report.Path
+		if relPath, err := filepath.Rel(workingDir, report.Path); err == nil && !strings.HasPrefix(relPath, "..") {
+			repoPath = relPath
+		}
+
+		switch {
+		case report.Error != "":
+			_, _ = fmt.Fprintf(w, "%s: error: %s\n", repoPath, report.Error)
+			continue
+		case len(report.ExpectedProfiles) == 0:
+			_, _ = fmt.Fprintf(w, "%s: no profile expected for origin %s, skipped\n", repoPath, report.Origin)
+			continue
+		}
+
+		expected := strings.Join(report.ExpectedProfiles, ", ")
+		if len(report.Offenders) == 0 {
+			_, _ = fmt.Fprintf(w, "%s: all %d commits match profile %s\n", repoPath, report.CommitsChecked, expected)
+			continue
+		}
+
+		_, _ = fmt.Fprintf(w, "%s: %d of %d commits don't match profile %s (origin %s)\n",
+			repoPath, report.OffendingCommits(), report.CommitsChecked, expected, report.Origin)
+
+		for _, offender := range report.Offenders {
+			_, _ = fmt.Fprintf(w, "  %s <%s> (%d commits)\n", offender.Name, offender.Email, len(offender.Commits))
+			for _, commit := range offender.Commits {
+				_, _ = fmt.Fprintf(w, "    %s %-16s %s\n", commit.Hash[:min(len(commit.Hash), 12)], strings.Join(commit.Roles, ","), commit.Subject)
+			}
+		}
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(auditCmd)
+
+	auditCmd.Flags().String("range", "", "Only audit commits in this revision range (default: all commits reachable from HEAD)")
+	auditCmd.Flags().Bool("author-only", false, "Only check authors, not committers")
+	auditCmd.Flags().BoolVarP(&auditRecursive, "recursive", "R", false, "Audit all repositories below the path, even if it is inside a repository")
+}
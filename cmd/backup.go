@@ -0,0 +1,123 @@
+// Package cmd
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Shieldine/git-profile/internal"
+	"github.com/spf13/cobra"
+)
+
+// backupCmd represents the backup command for inspecting the backups of the config file
+var backupCmd = &cobra.Command{
+	Use:       "backup <list|diff> [backup-id]",
+	Args:      cobra.RangeArgs(1, 2),
+	ValidArgs: []string{"list", "diff"},
+	Short:     "List and compare backups of the config file",
+	Long: `Inspect the backups of the config file.
+
+Before every change to the profiles (add, update, rm, restore and set or init creating a profile),
+the config file is copied to the backups directory next to it. The newest 20 backups are kept;
+set backup_limit in the config file to keep more or fewer, or 0 to turn backups off.
+
+"list" shows the backups, newest first.
+"diff <backup-id>" shows what changed in the config file since the backup was taken.
+
+Use restore to go back to a backup.
+
+Examples:
+  # List the backups
+  git-profile backup list
+
+  # Show what changed since a backup
+  git-profile backup diff 20240102T150405.000000Z
+`,
+	Run: runBackup,
+}
+
+// BackupsResult lists the backups of the config file.
+type BackupsResult struct {
+	Backups []internal.Backup `json:"backups" yaml:"backups"`
+}
+
+// Text prints one backup per line.
+func (r BackupsResult) Text(w io.Writer) {
+	if len(r.Backups) == 0 {
+		_, _ = fmt.Fprintln(w, "No backups yet.")
+		return
+	}
+
+	for _, backup := range r.Backups {
+		_, _ = fmt.Fprintf(w, "%s  %s  %d profiles\n",
+			backup.ID, backup.Time.Local().Format(time.DateTime), backup.Profiles)
+	}
+}
+
+// BackupDiffResult is the difference between a backup and the current config file.
+type BackupDiffResult struct {
+	Backup internal.Backup `json:"backup" yaml:"backup"`
+	Config string          `json:"config" yaml:"config"`
+	Diff   string          `json:"diff" yaml:"diff"`
+}
+
+// Text prints the diff.
+func (r BackupDiffResult) Text(w io.Writer) {
+	if r.Diff == "" {
+		_, _ = fmt.Fprintf(w, "No changes since backup %s.\n", r.Backup.ID)
+		return
+	}
+	_, _ = fmt.Fprint(w, r.Diff)
+}
+
+// runBackup handles the backup command execution.
+// It either lists the backups or shows the changes since one of them.
+func runBackup(_ *cobra.Command, args []string) {
+	switch args[0] {
+	case "list":
+		if len(args) != 1 {
+			failf(ExitUsage, "list takes no backup id")
+		}
+
+		backups, err := store.Backups()
+		if err != nil {
+			fail(ExitError, err)
+		}
+		render(BackupsResult{Backups: backups})
+	case "diff":
+		if len(args) != 2 {
+			failf(ExitUsage, "diff needs a backup id, see \"git-profile backup list\"")
+		}
+
+		backup, err := store.GetBackup(args[1])
+		if err != nil {
+			fail(ExitNotFound, err)
+		}
+
+		diff, err := internal.DiffFiles(backup.Path, store.Path())
+		if err != nil {
+			fail(ExitError, err)
+		}
+		render(BackupDiffResult{Backup: backup, Config: store.Path(), Diff: diff})
+	default:
+		failf(ExitUsage, "invalid argument %q, expected list or diff", args[0])
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(backupCmd)
+}
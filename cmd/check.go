@@ -15,20 +15,35 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"github.com/Shieldine/git-profile/custom_errors"
 	"github.com/Shieldine/git-profile/internal"
 	"github.com/spf13/cobra"
+	"io"
 	"os"
+	"strings"
 )
 
+// checkRemote holds the --remote flag of the check command.
+var checkRemote string
+
 // checkCmd represents the check command for displaying current git credentials
 var checkCmd = &cobra.Command{
 	Use:   "check",
 	Short: "Display the currently set attributes",
-	Long: `Check what attributes are currently set in the current project or globally.
+	Long: `Check what attributes are currently set in the current project or another git config scope.
+
+This command displays the name, email, signing settings and SSH key currently configured in git.
+For repositories, it also shows the remote URLs both as configured and as resolved through
+url.<base>.insteadOf rewrites (and SSH host aliases, if enabled), which is what profiles are matched against.
+Use --global, --system or --worktree to check that git config scope instead of the local repository configuration.
+The worktree scope only holds values if extensions.worktreeConfig is enabled, which set --worktree takes care of.
 
-This command displays the name and email currently configured in git.
-Use the --global flag to check the global git configuration instead of the local repository configuration.
+Use --effective to see the identity git actually uses for new commits. Like git, it takes every config
+scope into account (system, global, local, worktree and files pulled in by include and includeIf),
+as well as the GIT_AUTHOR_*, GIT_COMMITTER_* and EMAIL environment variables. For each value, the
+file or variable it comes from is shown, together with the profiles the identity belongs to.
 
 Examples:
   # Check local repository attributes
@@ -36,49 +51,300 @@ Examples:
 
   # Check global attributes
   git-profile check --global
+
+  # Check the attributes of the current worktree
+  git-profile check --worktree
+
+  # Check which identity git will use, and where it comes from
+  git-profile check --effective
 `,
 	Run: runCheck,
 }
 
+// IdentityResult is the identity configured in a git config scope.
+// Unset values are left empty.
+type IdentityResult struct {
+	Scope   string         `json:"scope" yaml:"scope"`
+	Name    string         `json:"name" yaml:"name"`
+	Email   string         `json:"email" yaml:"email"`
+	Signing *SigningResult `json:"signing,omitempty" yaml:"signing,omitempty"`
+	// SSHKey is the identity file of core.sshCommand, SSHCommand the command if it isn't a plain key.
+	SSHKey     string `json:"ssh_key,omitempty" yaml:"ssh_key,omitempty"`
+	SSHCommand string `json:"ssh_command,omitempty" yaml:"ssh_command,omitempty"`
+	// SSHSource is where the SSH settings come from: the scope, GIT_SSH_COMMAND or default.
+	SSHSource string         `json:"ssh_source" yaml:"ssh_source"`
+	Remotes   []RemoteResult `json:"remotes,omitempty" yaml:"remotes,omitempty"`
+}
+
+// SigningResult holds the commit signing settings of a scope, as configured in git.
+type SigningResult struct {
+	Key         string `json:"key" yaml:"key"`
+	Format      string `json:"format,omitempty" yaml:"format,omitempty"`
+	SignCommits string `json:"sign_commits,omitempty" yaml:"sign_commits,omitempty"`
+	SignTags    string `json:"sign_tags,omitempty" yaml:"sign_tags,omitempty"`
+}
+
+// RemoteResult is a remote URL as configured and as resolved for matching.
+type RemoteResult struct {
+	Name     string `json:"name" yaml:"name"`
+	URL      string `json:"url" yaml:"url"`
+	Resolved string `json:"resolved" yaml:"resolved"`
+	Matching string `json:"matching" yaml:"matching"`
+}
+
+// Text prints the identity the way git-profile always has.
+func (r IdentityResult) Text(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "%s Git Configuration:\n", scopeTitle(r.Scope))
+	_, _ = fmt.Fprintf(w, "Current name: %s\n", valueOrNotSet(r.Name))
+	_, _ = fmt.Fprintf(w, "Current email: %s\n", valueOrNotSet(r.Email))
+
+	if r.Signing != nil {
+		_, _ = fmt.Fprintf(w, "Current signing key: %s\n", r.Signing.Key)
+		if r.Signing.Format != "" {
+			_, _ = fmt.Fprintf(w, "Current signing format: %s\n", r.Signing.Format)
+		}
+		if r.Signing.SignCommits != "" {
+			_, _ = fmt.Fprintf(w, "Sign commits: %s\n", r.Signing.SignCommits)
+		}
+		if r.Signing.SignTags != "" {
+			_, _ = fmt.Fprintf(w, "Sign tags: %s\n", r.Signing.SignTags)
+		}
+	}
+
+	switch {
+	case r.SSHKey != "":
+		_, _ = fmt.Fprintf(w, "Current SSH key: %s\n", r.SSHKey)
+	case r.SSHSource == "GIT_SSH_COMMAND":
+		_, _ = fmt.Fprintf(w, "Current SSH command: %s (from GIT_SSH_COMMAND)\n", r.SSHCommand)
+	case r.SSHCommand != "":
+		_, _ = fmt.Fprintf(w, "Current SSH command: %s\n", r.SSHCommand)
+	default:
+		_, _ = fmt.Fprintln(w, "Current SSH key: default")
+	}
+
+	for _, remote := range r.Remotes {
+		_, _ = fmt.Fprintf(w, "Remote %s: %s\n", remote.Name, remote.URL)
+		_, _ = fmt.Fprintf(w, "  Resolved: %s (matching on %s)\n", remote.Resolved, remote.Matching)
+	}
+}
+
+// valueOrNotSet returns value, or a placeholder if it is empty.
+func valueOrNotSet(value string) string {
+	if value == "" {
+		return "not set"
+	}
+	return value
+}
+
+// EffectiveResult is the identity git uses for new commits, with where each value comes from.
+type EffectiveResult struct {
+	internal.EffectiveIdentity `yaml:",inline"`
+	// Profiles are the profiles with the name and email of the author identity.
+	Profiles []string `json:"profiles" yaml:"profiles"`
+	// ExpectedProfiles are the profiles expected for the repository, if run inside one.
+	ExpectedProfiles []string `json:"expected_profiles,omitempty" yaml:"expected_profiles,omitempty"`
+}
+
+// Text prints each value with its source, followed by the matching profiles.
+func (r EffectiveResult) Text(w io.Writer) {
+	_, _ = fmt.Fprintln(w, "Effective Git Configuration:")
+
+	values := []struct {
+		label string
+		value internal.EffectiveValue
+	}{
+		{"Author name", r.AuthorName},
+		{"Author email", r.AuthorEmail},
+		{"Committer name", r.CommitterName},
+		{"Committer email", r.CommitterEmail},
+		{"Signing key", r.SigningKey},
+	}
+	for _, entry := range values {
+		if !entry.value.IsSet() {
+			_, _ = fmt.Fprintf(w, "%s: not set\n", entry.label)
+			continue
+		}
+		_, _ = fmt.Fprintf(w, "%s: %s (%s)\n", entry.label, entry.value.Value, entry.value.Describe())
+	}
+
+	if len(r.Profiles) == 0 {
+		_, _ = fmt.Fprintln(w, "Matching profile: none")
+	} else {
+		_, _ = fmt.Fprintf(w, "Matching profile: %s\n", strings.Join(r.Profiles, ", "))
+	}
+	if len(r.ExpectedProfiles) != 0 {
+		_, _ = fmt.Fprintf(w, "Expected profile for this repository: %s\n", strings.Join(r.ExpectedProfiles, ", "))
+	}
+}
+
 // runCheck executes the check command logic.
-// It displays the current Git user configuration (name and email).
-// If --global flag is used, it shows the global Git configuration; otherwise, it shows the local repository configuration.
+// It displays the current Git user configuration (name, email, signing settings and SSH key).
+// The scope flags select the git configuration that is shown; without them, it is the local repository configuration.
 func runCheck(cmd *cobra.Command, _ []string) {
-	global, _ := cmd.Flags().GetBool("global")
+	scope := getScope(cmd)
+	effective, _ := cmd.Flags().GetBool("effective")
+
+	warnInvalidOrigins()
+
+	if effective {
+		runCheckEffective()
+		return
+	}
+
+	if scope.NeedsRepo() {
+		requireRepo()
+	}
+
+	result := IdentityResult{Scope: scope.String()}
 
-	var name, email string
 	var nameErr, emailErr error
+	result.Name, nameErr = git.GetUserName(scope)
+	result.Email, emailErr = git.GetUserEmail(scope)
 
-	if global {
-		name, nameErr = internal.GetGlobalUserName()
-		email, emailErr = internal.GetGlobalUserEmail()
-		fmt.Println("Global Git Configuration:")
-	} else {
-		if !internal.CheckGitRepo() {
-			fmt.Println("error: not a git repository")
-			os.Exit(1)
+	for _, err := range []error{nameErr, emailErr} {
+		if err != nil && !isNotSet(err) {
+			fail(ExitError, err)
 		}
+	}
 
-		name, nameErr = internal.GetUserName()
-		email, emailErr = internal.GetUserEmail()
-		fmt.Println("Local Git Configuration:")
+	if err := readSigningConfig(&result, scope); err != nil {
+		fail(ExitError, err)
+	}
+	if err := readSSHKey(&result, scope); err != nil {
+		fail(ExitError, err)
 	}
 
-	if nameErr != nil {
-		fmt.Printf("error: %v\n", nameErr)
-	} else {
-		fmt.Printf("Current name: %s\n", name)
+	if scope.NeedsRepo() {
+		remotes, err := getRepoRemotes()
+		if err != nil {
+			fail(ExitError, err)
+		}
+		for _, remote := range remotes {
+			result.Remotes = append(result.Remotes, RemoteResult{
+				Name:     remote.Name,
+				URL:      remote.RawURL,
+				Resolved: remote.ResolvedURL,
+				Matching: remote.URL.Path(),
+			})
+		}
+	}
+
+	render(result)
+}
+
+// warnInvalidOrigins warns about origin patterns of profiles that can't match any repository.
+func warnInvalidOrigins() {
+	for _, profile := range store.GetAllProfiles() {
+		for _, rule := range internal.GetProfileRules(profile) {
+			if err := internal.ValidateOrigin(rule.Origin); err != nil {
+				warn("profile %s never matches: %v", profile.ProfileName, err)
+			}
+		}
+	}
+}
+
+// runCheckEffective shows the identity git uses for new commits and the profiles it belongs to.
+// Outside of a repository, only the scopes that apply there are considered.
+func runCheckEffective() {
+	identity, err := git.GetEffectiveIdentity()
+	if err != nil {
+		fail(ExitError, err)
+	}
+
+	result := EffectiveResult{EffectiveIdentity: identity, Profiles: []string{}}
+
+	author := identity.Author()
+	for _, profile := range store.GetAllProfiles() {
+		if internal.IdentityMatchesProfile(author, profile) {
+			result.Profiles = append(result.Profiles, profile.ProfileName)
+		}
 	}
 
-	if emailErr != nil {
-		fmt.Printf("error: %v\n", emailErr)
+	if git.CheckGitRepo() {
+		remotes, err := GetRemotesToMatch(checkRemote)
+		if err != nil {
+			fail(ExitError, err)
+		}
+
+		repoRoot, err := git.GetRepoRoot()
+		if err != nil {
+			fail(ExitError, err)
+		}
+
+		expected, _ := internal.ResolveRepoProfiles(store.GetAllProfiles(), remotes, repoRoot)
+		for _, profile := range expected {
+			result.ExpectedProfiles = append(result.ExpectedProfiles, profile.ProfileName)
+		}
+	}
+
+	render(result)
+}
+
+// isNotSet reports whether err only says that a git config value isn't set.
+func isNotSet(err error) bool {
+	var notSetErr *custom_errors.NotSetError
+	return errors.As(err, &notSetErr)
+}
+
+// readSSHKey fills in which SSH key git uses for the given scope.
+// Falls back to GIT_SSH_COMMAND or the default when no core.sshCommand is configured.
+func readSSHKey(result *IdentityResult, scope internal.Scope) error {
+	sshCommand, err := git.GetSSHCommand(scope)
+	if err != nil {
+		if !isNotSet(err) {
+			return err
+		}
+
+		if envCommand := os.Getenv("GIT_SSH_COMMAND"); envCommand != "" {
+			result.SSHCommand = envCommand
+			result.SSHSource = "GIT_SSH_COMMAND"
+		} else {
+			result.SSHSource = "default"
+		}
+		return nil
+	}
+
+	result.SSHSource = result.Scope
+	if keyPath, ok := internal.ParseSSHCommand(sshCommand); ok {
+		result.SSHKey = keyPath
 	} else {
-		fmt.Printf("Current email: %s\n", email)
+		result.SSHCommand = sshCommand
 	}
+	return nil
+}
+
+// readSigningConfig fills in the commit signing settings of the given scope.
+// Signing stays nil if no signing key is set, since signing is optional.
+func readSigningConfig(result *IdentityResult, scope internal.Scope) error {
+	signingKey, err := git.GetSigningKey(scope)
+	if err != nil {
+		if isNotSet(err) {
+			return nil
+		}
+		return err
+	}
+
+	result.Signing = &SigningResult{Key: signingKey}
+
+	if format, err := git.GetSigningFormat(scope); err == nil {
+		result.Signing.Format = format
+	}
+	if commitSigning, err := git.GetCommitSigning(scope); err == nil {
+		result.Signing.SignCommits = commitSigning
+	}
+	if tagSigning, err := git.GetTagSigning(scope); err == nil {
+		result.Signing.SignTags = tagSigning
+	}
+	return nil
 }
 
 func init() {
-	checkCmd.Flags().BoolP("global", "g", false, "Check the global credentials instead of the current repository")
+	addScopeFlags(checkCmd, "Check the credentials in")
+	checkCmd.Flags().BoolP("effective", "E", false, "Show the identity git actually uses and where each value comes from")
+	checkCmd.Flags().StringVarP(&checkRemote, "remote", "r", "", "Match the expected profile on this remote only (with --effective)")
+	for _, scopeFlag := range scopeFlags {
+		checkCmd.MarkFlagsMutuallyExclusive(scopeFlag, "effective")
+	}
 
 	rootCmd.AddCommand(checkCmd)
 }
@@ -0,0 +1,149 @@
+// Package cmd
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Shieldine/git-profile/internal"
+	"github.com/Shieldine/git-profile/models"
+	"github.com/spf13/cobra"
+)
+
+// cloneCmd represents the clone command for cloning a repository with the matching profile
+var cloneCmd = &cobra.Command{
+	Use:   "clone <url> [directory]",
+	Args:  cobra.RangeArgs(1, 2),
+	Short: "Clone a repository and set the matching profile",
+	Long: `Clone a repository with the profile matching its URL, and set the profile's attributes in the clone.
+
+The profile is picked the same way init does, using the URL and the directory the repository
+is cloned into. If the profile has an SSH key, git clone already uses it, so repositories that
+are only accessible with that key can be cloned right away. Name, email, signing settings and
+SSH key are then set in the new repository.
+
+If several profiles match, you will be asked to pick one. Use --profile to choose one upfront.
+If none match, the repository is cloned without setting any attributes.
+
+Examples:
+  # Clone with the matching profile
+  git-profile clone git@github.com:acme-corp/app.git
+
+  # Clone into a specific directory with a specific profile
+  git-profile clone git@github.com:acme-corp/app.git ~/work/app --profile work
+`,
+	Run: runClone,
+}
+
+// runClone handles the clone command execution.
+// It resolves the profile for the URL, clones with the profile's SSH key and applies the profile to the clone.
+func runClone(cmd *cobra.Command, args []string) {
+	rawURL := args[0]
+
+	_, remote, err := git.ResolveRemote(rawURL)
+	if err != nil {
+		fail(ExitError, err)
+	}
+
+	dir := ""
+	if len(args) == 2 {
+		dir = args[1]
+	} else {
+		dir, err = internal.GetCloneDir(rawURL)
+		if err != nil {
+			fail(ExitError, err)
+		}
+	}
+	dir = resolvePath(dir)
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		fail(ExitError, err)
+	}
+
+	profile, err := getCloneProfile(cmd, remote, absDir)
+	if err != nil {
+		fail(ExitNotFound, err)
+	}
+
+	if profile.ProfileName != "" {
+		note("Cloning with profile %s", profile.ProfileName)
+	}
+
+	err = internal.CloneRepo(rawURL, dir, profile.SSHKey)
+	if err != nil {
+		failf(ExitError, "error cloning repository: %v", err)
+	}
+
+	var result ActionResult
+	result.Add(Action{Action: ActionClone, Profile: profile.ProfileName, Target: absDir})
+
+	if profile.ProfileName != "" {
+		err = applyProfileAt(absDir, profile)
+		if err != nil {
+			fail(ExitError, err)
+		}
+
+		result.Add(Action{
+			Action:  ActionSet,
+			Scope:   "local",
+			Profile: profile.ProfileName,
+			Target:  absDir,
+			Message: fmt.Sprintf("Credentials of profile %s set for %s.", profile.ProfileName, dir),
+		})
+	}
+
+	render(&result)
+}
+
+// getCloneProfile returns the profile passed with --profile, or the one matching the remote and directory.
+// An empty profile means that none matched.
+func getCloneProfile(cmd *cobra.Command, remote internal.RemoteURL, dir string) (models.ProfileConfig, error) {
+	selected, _ := cmd.Flags().GetString("profile")
+	// the clone doesn't exist yet, and the repository config of the current directory has no say in it
+	profiles := userStore()
+
+	if selected != "" {
+		profile := profiles.GetProfileByName(selected)
+		if profile.ProfileName == "" {
+			return models.ProfileConfig{}, fmt.Errorf("profile %s doesn't exist", selected)
+		}
+		return profile, nil
+	}
+
+	possibleProfiles := internal.ResolveProfiles(profiles.GetAllProfiles(), remote, dir)
+
+	switch len(possibleProfiles) {
+	case 0:
+		note("No profiles found for origin %s, cloning without one.", remote.Path())
+		return models.ProfileConfig{}, nil
+	case 1:
+		return possibleProfiles[0], nil
+	}
+
+	note("Multiple profiles found for origin %s", remote.Path())
+	for _, possibleProfile := range possibleProfiles {
+		PrintProfile(os.Stderr, possibleProfile, profiles.LayerOf(possibleProfile.ProfileName))
+	}
+	return PickProfile(possibleProfiles), nil
+}
+
+func init() {
+	rootCmd.AddCommand(cloneCmd)
+
+	cloneCmd.Flags().StringP("profile", "p", "", "Clone with this profile instead of the matching one")
+}
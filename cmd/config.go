@@ -16,6 +16,7 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 
@@ -29,10 +30,16 @@ var (
 
 // configCmd represents the config command for editing the profile configuration file
 var configCmd = &cobra.Command{
-	Use:     "config",
-	Aliases: []string{"c"},
-	Short:   "Edit profile configuration file",
+	Use:       "config [path]",
+	Aliases:   []string{"c"},
+	Args:      cobra.MatchAll(cobra.MaximumNArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: []string{"path"},
+	Short:     "Edit profile configuration file",
 	Long: `Open and edit the config file containing all profiles.
+"config path" prints where the config file is and why: the first of --config,
+GIT_PROFILE_CONFIG, $XDG_CONFIG_HOME/git-profile and the platform default
+(~/.config/git-profile on Linux and macOS, %AppData%\git-profile on Windows) that is set.
+
 You can manually type in new profiles by using the following scheme:
 
 [[profiles]]
@@ -41,6 +48,55 @@ You can manually type in new profiles by using the following scheme:
   email = ""
   origin = ""
 
+Profiles can also come from two more config files with the same format. Together with this one,
+they make up layers, from lowest to highest precedence:
+
+  repo    .git-profile.toml in the root of the repository, to be committed and shared
+  system  /etc/git-profile/config.toml (%ProgramData%\git-profile on Windows), shared by all users
+  user    this file
+
+A profile replaces the one of the same name in a lower layer, and each setting is taken from
+the highest layer that sets it. Anyone who can push to a repository can change its
+.git-profile.toml, so the repo layer only adds profiles with new names and its settings are
+ignored. Commands working on several repositories read the .git-profile.toml of each one,
+and profiles of the repo layer are never written to the global git configuration.
+Changes to a profile are saved in the layer it comes from; new profiles go to the user layer
+unless added with "git-profile add --layer". Set GIT_PROFILE_SYSTEM_CONFIG to use another
+system config file.
+
+Commit signing is optional and can be configured per profile:
+
+  signing_key = ""       # GPG key ID, X.509 ID or path to an SSH public key
+  signing_format = ""    # openpgp, ssh or x509
+  sign_commits = true
+  sign_tags = false
+
+To push with a dedicated SSH key, set the identity file of the profile:
+
+  ssh_key = "~/.ssh/id_work"
+
+Rules pick a profile by the repository's location and/or origin. Higher priorities win;
+at equal priority, path matches win over origin matches:
+
+  [[profiles.rules]]
+    path = "~/work/clients/acme/**"
+    priority = 10
+
+To match profiles on the real hostname behind SSH host aliases from ~/.ssh/config,
+add the following line at the top of the file:
+
+resolve_ssh_hosts = true
+
+Profiles are matched against all remotes of a repository. Remotes listed in remote_order
+are tried first, in that order; the others follow. By default, origin comes first:
+
+remote_order = ["upstream", "origin"]
+
+verify_policy decides what "git-profile verify" and the pre-commit hook do when the identity
+doesn't match the expected profile: refuse (default), fix or warn:
+
+verify_policy = "fix"
+
 Examples:
   # Edit config with default editor (vim)
   git-profile config
@@ -50,28 +106,68 @@ Examples:
 
   # Edit config with VS Code
   git-profile config --editor code
+
+  # Show which config file is used
+  git-profile config path
 `,
 	Run: runConfig,
 }
 
+// ConfigPathResult is the location of the config file and the source it was taken from,
+// together with all config layers.
+type ConfigPathResult struct {
+	internal.ConfigLocation `yaml:",inline"`
+	Layers                  []internal.Layer `json:"layers" yaml:"layers"`
+}
+
+// Text prints the path and what decided it, followed by the layers.
+func (r ConfigPathResult) Text(w io.Writer) {
+	sources := map[string]string{
+		internal.ConfigSourceFlag:    "--config",
+		internal.ConfigSourceEnv:     "GIT_PROFILE_CONFIG",
+		internal.ConfigSourceXDG:     "XDG_CONFIG_HOME",
+		internal.ConfigSourceDefault: "default location",
+	}
+	_, _ = fmt.Fprintf(w, "%s (from %s)\n", r.Path, sources[r.Source])
+
+	_, _ = fmt.Fprintln(w, "\nLayers, lowest precedence first:")
+	for _, layer := range r.Layers {
+		missing := ""
+		if !layer.Exists {
+			missing = " (not present)"
+		}
+		_, _ = fmt.Fprintf(w, "  %-7s %s%s\n", layer.Name, layer.Path, missing)
+	}
+}
+
 // runConfig handles the config command execution.
 // It opens the configuration file in the specified editor (or vim by default).
 // The function sets up the editor command and handles any errors that occur.
-func runConfig(*cobra.Command, []string) {
+func runConfig(_ *cobra.Command, args []string) {
+	if len(args) == 1 {
+		render(ConfigPathResult{ConfigLocation: configLocation, Layers: store.Layers()})
+		return
+	}
+
 	editor := editorChoice
 	if editor == "" {
 		editor = "vim"
 	}
 
-	editorCmd := exec.Command(editor, internal.GetConfigPath())
+	configPath := store.Path()
+
+	editorCmd := exec.Command(editor, configPath)
 	editorCmd.Stdin = os.Stdin
 	editorCmd.Stdout = os.Stdout
 	editorCmd.Stderr = os.Stderr
 
 	if err := editorCmd.Run(); err != nil {
-		fmt.Printf("Failed to open editor: %v\n", err)
-		return
+		failf(ExitError, "failed to open editor: %v", err)
 	}
+
+	var result ActionResult
+	result.Add(Action{Action: ActionEdit, Target: configPath})
+	render(&result)
 }
 
 func init() {
@@ -0,0 +1,152 @@
+// Package cmd
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Shieldine/git-profile/internal"
+	"github.com/spf13/cobra"
+)
+
+// fixHistoryDryRun holds the --dry-run flag of the fix-history command.
+var fixHistoryDryRun bool
+
+// fixHistoryCmd represents the fix-history command for rewriting commits made with the wrong identity
+var fixHistoryCmd = &cobra.Command{
+	Use:   "fix-history --from <identity> --to <profile-name>",
+	Args:  cobra.NoArgs,
+	Short: "Rewrite unpushed commits made with the wrong identity",
+	Long: `Rewrite the commits of the current branch whose author or committer is <identity>,
+so that they carry the name and email of <profile-name> instead.
+<identity> can be "Name <email>", an email or a name.
+
+By default, only commits that are not reachable from any remote-tracking branch are rewritten,
+i.e. the ones you haven't pushed yet. Use --range to select commits yourself, e.g. origin/main..HEAD;
+the range must end at HEAD. Commits that are already pushed are only rewritten with --force,
+as everyone who fetched them will have to deal with the rewritten history.
+
+Dates, messages and contents are kept. Signatures don't survive a rewrite; if the profile signs
+commits, the rewritten commits it committed are signed with its key. Other signed commits would lose
+their signature, including commits of others that are only rewritten because they sit on top of
+the fixed ones, so they are only rewritten with --force. --dry-run lists them.
+
+Before the branch is moved, its old head is saved as refs/git-profile/backup/<timestamp>.
+To go back, run: git reset --keep <backup-ref>
+
+Examples:
+  # Fix unpushed commits made with your private email
+  git-profile fix-history --from me@example.com --to work
+
+  # Only show which commits would be rewritten
+  git-profile fix-history --from "Me <me@example.com>" --to work --dry-run
+
+  # Rewrite everything since the last release, even if it is pushed
+  git-profile fix-history --from me@example.com --to work --range v1.2.0..HEAD --force
+`,
+	Run: runFixHistory,
+}
+
+// runFixHistory handles the fix-history command execution.
+func runFixHistory(cmd *cobra.Command, _ []string) {
+	from, _ := cmd.Flags().GetString("from")
+	to, _ := cmd.Flags().GetString("to")
+	revRange, _ := cmd.Flags().GetString("range")
+	force, _ := cmd.Flags().GetBool("force")
+
+	requireRepo()
+
+	if err := internal.ValidateRevRange(revRange); err != nil {
+		fail(ExitUsage, err)
+	}
+
+	pattern, err := internal.ParseIdentityPattern(from)
+	if err != nil {
+		fail(ExitUsage, err)
+	}
+
+	profile := store.GetProfileByName(to)
+	if profile.ProfileName == "" {
+		failf(ExitNotFound, "profile %s doesn't exist", to)
+	}
+
+	result, err := internal.RewriteHistory(git.Dir(), internal.HistoryRewrite{
+		Range:   revRange,
+		Rewrite: internal.ReplaceIdentity(pattern, profile),
+		Signing: profile,
+		Force:   force,
+		DryRun:  fixHistoryDryRun,
+	})
+	if err != nil {
+		fail(ExitError, err)
+	}
+
+	render(RewriteResult{HistoryRewriteResult: result, Identity: "profile " + profile.ProfileName, DryRun: fixHistoryDryRun})
+}
+
+// RewriteResult reports the commits a history rewrite changed and where the backup went.
+type RewriteResult struct {
+	internal.HistoryRewriteResult `yaml:",inline"`
+	// Identity describes the identity the commits got.
+	Identity string `json:"identity" yaml:"identity"`
+	DryRun   bool   `json:"dry_run" yaml:"dry_run"`
+}
+
+// Text describes the rewrite, or what it would do in a dry run.
+func (r RewriteResult) Text(w io.Writer) {
+	if len(r.Changed) == 0 {
+		_, _ = fmt.Fprintln(w, "No commits to rewrite. Nothing to do.")
+		return
+	}
+
+	if r.DryRun {
+		_, _ = fmt.Fprintf(w, "Would give %d commits the identity of %s:\n", len(r.Changed), r.Identity)
+		for _, hash := range r.Changed {
+			_, _ = fmt.Fprintf(w, "  %s\n", hash)
+		}
+		if descendants := r.Rewritten - len(r.Changed); descendants > 0 {
+			_, _ = fmt.Fprintf(w, "%d descendant commits would be rewritten as well.\n", descendants)
+		}
+		if len(r.Unsigned) != 0 {
+			_, _ = fmt.Fprintf(w, "%d signed commits would lose their signature (needs --force):\n", len(r.Unsigned))
+			for _, hash := range r.Unsigned {
+				_, _ = fmt.Fprintf(w, "  %s\n", hash)
+			}
+		}
+		return
+	}
+
+	_, _ = fmt.Fprintf(w, "Gave %d commits the identity of %s, rewrote %d commits in total.\n",
+		len(r.Changed), r.Identity, r.Rewritten)
+	if len(r.Unsigned) != 0 {
+		_, _ = fmt.Fprintf(w, "Dropped the signatures of %d commits: %s\n", len(r.Unsigned), strings.Join(r.Unsigned, ", "))
+	}
+	_, _ = fmt.Fprintf(w, "Updated %s from %s to %s\n", r.Ref, r.OldHead, r.NewHead)
+	_, _ = fmt.Fprintf(w, "Backup of the old history: %s (restore with: git reset --keep %s)\n", r.BackupRef, r.BackupRef)
+}
+
+func init() {
+	rootCmd.AddCommand(fixHistoryCmd)
+
+	fixHistoryCmd.Flags().String("from", "", "Identity to replace: \"Name <email>\", email or name")
+	fixHistoryCmd.Flags().String("to", "", "Profile whose name and email to use instead")
+	fixHistoryCmd.Flags().String("range", "", "Revision range ending at HEAD (default: all commits not on a remote)")
+	fixHistoryCmd.Flags().Bool("force", false, "Also rewrite commits that are already pushed or would lose their signature")
+	fixHistoryCmd.Flags().BoolVar(&fixHistoryDryRun, "dry-run", false, "Only show which commits would be rewritten")
+	_ = fixHistoryCmd.MarkFlagRequired("from")
+	_ = fixHistoryCmd.MarkFlagRequired("to")
+}
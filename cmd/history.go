@@ -0,0 +1,112 @@
+// Package cmd
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Shieldine/git-profile/internal"
+	"github.com/spf13/cobra"
+)
+
+var historyLimit int
+
+// historyCmd represents the history command for listing the operations in the journal
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Args:  cobra.NoArgs,
+	Short: "List past changes to profiles and git config",
+	Long: `List the operations recorded in the journal, newest first.
+
+Each operation is a command that changed profiles, settings or git config values, shown with
+the values it changed. Operations reverted with "git-profile undo" are marked as undone.
+The journal is kept in journal.jsonl next to the config file.
+
+Examples:
+  # Show the last 5 operations
+  git-profile history -n 5
+`,
+	Run: runHistory,
+}
+
+// HistoryEntry is an operation of the journal, with the undo that reverted it, if any.
+type HistoryEntry struct {
+	internal.Operation `yaml:",inline"`
+	UndoneBy           int `json:"undone_by,omitempty" yaml:"undone_by,omitempty"`
+}
+
+// HistoryResult lists the operations of the journal, newest first.
+type HistoryResult struct {
+	Operations []HistoryEntry `json:"operations" yaml:"operations"`
+}
+
+// Text prints each operation followed by its changes.
+func (r HistoryResult) Text(w io.Writer) {
+	if len(r.Operations) == 0 {
+		_, _ = fmt.Fprintln(w, "No operations recorded yet.")
+		return
+	}
+
+	for _, entry := range r.Operations {
+		line := fmt.Sprintf("#%d  %s  git-profile %s", entry.ID, entry.Time.Local().Format(time.DateTime), entry.Command)
+		if entry.Undoes != 0 {
+			line += fmt.Sprintf("  (undoes #%d)", entry.Undoes)
+		}
+		if entry.UndoneBy != 0 {
+			line += fmt.Sprintf("  (undone by #%d)", entry.UndoneBy)
+		}
+		_, _ = fmt.Fprintln(w, line)
+
+		for _, change := range entry.Changes {
+			_, _ = fmt.Fprintf(w, "    %s\n", change)
+		}
+	}
+}
+
+// runHistory handles the history command execution.
+func runHistory(_ *cobra.Command, _ []string) {
+	if historyLimit < 0 {
+		failf(ExitUsage, "--limit must not be negative")
+	}
+
+	operations, err := internal.NewJournal(internal.GetJournalPath(store.Dir())).Operations()
+	if err != nil {
+		fail(ExitError, err)
+	}
+
+	undoneBy := map[int]int{}
+	for _, operation := range operations {
+		if operation.Undoes != 0 {
+			undoneBy[operation.Undoes] = operation.ID
+		}
+	}
+
+	result := HistoryResult{Operations: []HistoryEntry{}}
+	for i := len(operations) - 1; i >= 0; i-- {
+		if historyLimit != 0 && len(result.Operations) == historyLimit {
+			break
+		}
+		result.Operations = append(result.Operations, HistoryEntry{Operation: operations[i], UndoneBy: undoneBy[operations[i].ID]})
+	}
+	render(result)
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+
+	historyCmd.Flags().IntVarP(&historyLimit, "limit", "n", 0, "Show only the last n operations (0 shows all)")
+}
@@ -0,0 +1,216 @@
+// Package cmd
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Shieldine/git-profile/internal"
+	"github.com/spf13/cobra"
+)
+
+// hookCommands maps the hooks git-profile can install to the git-profile arguments they run.
+var hookCommands = map[string]string{
+	"pre-commit":    "verify --hook",
+	"post-checkout": `init --hook --previous-head "$1"`,
+}
+
+// hookCmd represents the hook command for installing git hooks
+var hookCmd = &cobra.Command{
+	Use:       "hook <install|uninstall> [pre-commit|post-checkout]",
+	Args:      validateHookArgs,
+	ValidArgs: []string{"install", "uninstall"},
+	Short:     "Install git hooks that verify or set your identity",
+	Long: `Install or uninstall git hooks that keep your identity in line with your profiles.
+
+  pre-commit     runs "git-profile verify" before every commit (default). Commits made with an
+                 identity that doesn't match the profile expected for the repository's origin are
+                 refused, or the profile is applied, depending on verify_policy in the config file.
+  post-checkout  runs "git-profile init" without asking questions on the first checkout of a
+                 fresh clone. A single matching profile is applied silently; if several or none
+                 match, you get a message telling you to run init yourself.
+
+Without further flags, the hook is installed into the current repository.
+
+With --global, it is installed into the directory set as core.hooksPath in your global git config.
+If none is set, git-profile creates its own directory next to the config file and points
+core.hooksPath at it. Since git then ignores the hooks of each repository, the global hook runs them itself.
+
+With --template, it is installed into the template directory set as init.templateDir, so that git
+copies it into every repository created or cloned from then on. If none is set, git-profile creates
+its own template directory next to the config file. Repositories created before keep their hooks.
+
+Hooks use the config file the install command found, e.g. through --config or GIT_PROFILE_CONFIG.
+Reinstall them after moving the config file.
+
+Existing hooks are never overwritten: a hook already in place is renamed to
+<hook>.git-profile-chained and run after git-profile. Uninstalling puts it back.
+
+Examples:
+  # Verify commits in the current repository
+  git-profile hook install
+
+  # Verify commits in all repositories
+  git-profile hook install --global
+
+  # Set the profile in every fresh clone
+  git-profile hook install post-checkout --template
+
+  # Remove the hook again
+  git-profile hook uninstall --global
+`,
+	Run: runHook,
+}
+
+// validateHookArgs checks the action and the optional hook name.
+func validateHookArgs(cmd *cobra.Command, args []string) error {
+	if err := cobra.RangeArgs(1, 2)(cmd, args); err != nil {
+		return err
+	}
+
+	if args[0] != "install" && args[0] != "uninstall" {
+		return fmt.Errorf("invalid argument %q for %q", args[0], cmd.CommandPath())
+	}
+
+	if len(args) == 2 {
+		if _, ok := hookCommands[args[1]]; !ok {
+			return fmt.Errorf("unsupported hook %q, expected pre-commit or post-checkout", args[1])
+		}
+	}
+	return nil
+}
+
+// runHook handles the hook command execution.
+// It installs or uninstalls a hook in the repository, the global hooks directory or the template directory.
+func runHook(cmd *cobra.Command, args []string) {
+	global, _ := cmd.Flags().GetBool("global")
+	template, _ := cmd.Flags().GetBool("template")
+
+	hookName := "pre-commit"
+	if len(args) == 2 {
+		hookName = args[1]
+	}
+
+	hooksDir, needsSetting, err := getHooksDir(global, template)
+	if err != nil {
+		fail(ExitError, err)
+	}
+
+	var result ActionResult
+
+	if args[0] == "uninstall" {
+		err = internal.UninstallHook(hooksDir, hookName)
+		if err != nil {
+			failf(ExitError, "error uninstalling hook: %v", err)
+		}
+
+		err = resetHooksSetting(hooksDir, global, template)
+		if err != nil {
+			fail(ExitError, err)
+		}
+
+		result.Add(Action{
+			Action:  ActionUninstall,
+			Target:  filepath.Join(hooksDir, hookName),
+			Message: fmt.Sprintf("Removed %s hook from %s", hookName, hooksDir),
+		})
+		render(&result)
+		return
+	}
+
+	command := internal.GetExecutableCommand(configLocation.Path) + " " + hookCommands[hookName]
+	err = internal.InstallHook(hooksDir, hookName, internal.RenderHook(hookName, command, global))
+	if err != nil {
+		failf(ExitError, "error installing hook: %v", err)
+	}
+
+	if needsSetting && global {
+		err = internal.SetGlobalHooksPath(hooksDir)
+		if err != nil {
+			failf(ExitError, "error setting core.hooksPath: %v", err)
+		}
+		result.Add(Action{
+			Action:  ActionSet,
+			Scope:   "global",
+			Target:  "core.hooksPath",
+			Message: fmt.Sprintf("Set global core.hooksPath to %s", hooksDir),
+		})
+	} else if needsSetting && template {
+		templateDir := internal.GetDefaultTemplateDir(store.Dir())
+		err = internal.SetGlobalTemplateDir(templateDir)
+		if err != nil {
+			failf(ExitError, "error setting init.templateDir: %v", err)
+		}
+		result.Add(Action{
+			Action:  ActionSet,
+			Scope:   "global",
+			Target:  "init.templateDir",
+			Message: fmt.Sprintf("Set global init.templateDir to %s", templateDir),
+		})
+	}
+
+	result.Add(Action{
+		Action:  ActionInstall,
+		Target:  filepath.Join(hooksDir, hookName),
+		Message: fmt.Sprintf("Installed %s hook in %s", hookName, hooksDir),
+	})
+	render(&result)
+}
+
+// getHooksDir returns the hooks directory to work on and whether the git setting pointing at it still has to be set.
+func getHooksDir(global bool, template bool) (string, bool, error) {
+	if global {
+		return internal.GetGlobalHooksDir(store.Dir())
+	}
+	if template {
+		return internal.GetTemplateHooksDir(store.Dir())
+	}
+
+	hooksDir, err := git.GetRepoHooksDir()
+	return hooksDir, false, err
+}
+
+// resetHooksSetting removes core.hooksPath or init.templateDir again once the directory git-profile manages holds no hooks.
+func resetHooksSetting(hooksDir string, global bool, template bool) error {
+	if !global && !template {
+		return nil
+	}
+
+	entries, err := os.ReadDir(hooksDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".sample") {
+			return nil
+		}
+	}
+
+	if global {
+		return internal.UnsetGlobalHooksPath(store.Dir())
+	}
+	return internal.UnsetGlobalTemplateDir(store.Dir())
+}
+
+func init() {
+	rootCmd.AddCommand(hookCmd)
+
+	hookCmd.Flags().BoolP("global", "g", false, "Install into the global core.hooksPath instead of the current repository")
+	hookCmd.Flags().BoolP("template", "t", false, "Install into the global init.templateDir, so new clones get the hook")
+	hookCmd.MarkFlagsMutuallyExclusive("global", "template")
+}
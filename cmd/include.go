@@ -0,0 +1,118 @@
+// Package cmd
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package cmd
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/Shieldine/git-profile/internal"
+	"github.com/spf13/cobra"
+)
+
+// includeCmd represents the include command for automatic profile switching through includeIf rules
+var includeCmd = &cobra.Command{
+	Use:       "include <sync|remove>",
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: []string{"sync", "remove"},
+	Short:     "Switch profiles automatically through git includeIf rules",
+	Long: `Let git pick the right profile on its own, without running init in every repository.
+
+"sync" generates an include file per profile and writes [includeIf] blocks into your
+global git config (~/.gitconfig or $GIT_CONFIG_GLOBAL). Repositories with a remote on a
+profile's origin or below one of its path rules will then use that profile's attributes
+right after cloning. Blocks are ordered by rule priority, so the highest priority wins.
+Only the block managed by git-profile is rewritten, the rest of your git config stays untouched.
+Run sync again after changing your profiles.
+
+"remove" deletes the managed block and the generated include files.
+
+Origins and paths shared by multiple profiles at the same priority are skipped, since git
+can't choose between them. Rules combining a path with an origin are skipped as well.
+Matching on remote URLs requires git 2.36 or newer.
+
+Examples:
+  # Write or refresh the includeIf rules
+  git-profile include sync
+
+  # Remove the includeIf rules again
+  git-profile include remove
+`,
+	Run: runInclude,
+}
+
+// IncludeResult lists the includeIf rules written to the global git config.
+type IncludeResult struct {
+	GitConfig string                 `json:"git_config" yaml:"git_config"`
+	Removed   bool                   `json:"removed" yaml:"removed"`
+	Rules     []internal.IncludeRule `json:"rules" yaml:"rules"`
+	Skipped   []string               `json:"skipped" yaml:"skipped"`
+}
+
+// Text prints the written rules.
+func (r IncludeResult) Text(w io.Writer) {
+	if r.Removed {
+		_, _ = fmt.Fprintf(w, "Removed git-profile includeIf rules from %s\n", r.GitConfig)
+		return
+	}
+
+	if len(r.Rules) == 0 {
+		_, _ = fmt.Fprintln(w, "No profiles with an origin or path rule to include.")
+		return
+	}
+
+	for _, rule := range r.Rules {
+		_, _ = fmt.Fprintf(w, "  %s -> %s\n", rule.Condition, rule.ProfileName)
+	}
+	_, _ = fmt.Fprintf(w, "Wrote %d includeIf rules to %s\n", len(r.Rules), r.GitConfig)
+}
+
+// runInclude handles the include command execution.
+// It either syncs the managed includeIf block with the current profiles or removes it.
+func runInclude(_ *cobra.Command, args []string) {
+	gitConfigPath, err := internal.GetGlobalGitConfigPath()
+	if err != nil {
+		fail(ExitError, err)
+	}
+
+	result := IncludeResult{GitConfig: gitConfigPath, Rules: []internal.IncludeRule{}, Skipped: []string{}}
+
+	if args[0] == "remove" {
+		err = internal.RemoveIncludes(gitConfigPath, store.Dir())
+		if err != nil {
+			failf(ExitError, "error removing includeIf rules: %v", err)
+		}
+		result.Removed = true
+		render(result)
+		return
+	}
+
+	rules, skipped, err := internal.SyncIncludes(gitConfigPath, store.Dir(), userStore().GetAllProfiles())
+	if err != nil {
+		failf(ExitError, "error syncing includeIf rules: %v", err)
+	}
+
+	for _, description := range skipped {
+		warn("skipping %s", description)
+	}
+
+	result.Rules = append(result.Rules, rules...)
+	result.Skipped = append(result.Skipped, skipped...)
+	render(result)
+}
+
+func init() {
+	rootCmd.AddCommand(includeCmd)
+}
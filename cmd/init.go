@@ -20,16 +20,34 @@ import (
 	"github.com/Shieldine/git-profile/internal"
 	"github.com/Shieldine/git-profile/models"
 	"github.com/spf13/cobra"
+	"io"
 	"os"
+	"path/filepath"
 	"strings"
+	"text/tabwriter"
+)
+
+// These hold the flags of the init command.
+var (
+	initRemote       string
+	initHook         bool
+	initPreviousHead string
+	initRecursive    bool
+	initDryRun       bool
+	initYes          bool
 )
 
 // initCmd represents the init command for automatically setting git attributes
 var initCmd = &cobra.Command{
-	Use:   "init",
+	Use:   "init [root]",
+	Args:  cobra.MaximumNArgs(1),
 	Short: "Automatically set attributes for current repository",
 	Long: `Automatically set attributes for the current repository.
-The attributes will be chosen by the repository's origin.
+The attributes will be chosen by the repository's origin and the rules of your profiles.
+Origins can be hostnames (github.com), globs over host/owner/repository (github.com/acme-corp/*)
+or regular expressions prefixed with "re:". The most specific matching origin wins.
+Path rules let profiles sharing an origin be told apart by the repository's location,
+and rule priorities decide which match wins.
 
 If no profile with a matching origin is present, you will be asked to 
 add one.
@@ -37,8 +55,27 @@ add one.
 If multiple profiles with a matching origin are present, 
 you will be asked to pick one.
 
+All remotes are considered, starting with the ones listed in remote_order of the
+config file (default: origin). The first remote with a matching profile wins.
+Use --remote to match on a specific remote only.
+
+To run init on its own in every fresh clone, install the post-checkout hook:
+  git-profile hook install post-checkout --template
+
+With --recursive, all repositories below [root] (default: current directory) are initialized,
+including nested repositories, submodules and linked worktrees. A plan listing each repository,
+its origin, current identity and target profile is shown before anything is changed.
+Use --dry-run to only show the plan, and --yes to apply it without asking. With --yes,
+repositories matching several profiles are skipped instead of asking you to pick one.
+
 Usage:
   git-profile init
+
+  # Match on the company repository instead of your fork
+  git-profile init --remote upstream
+
+  # Show what would be set in all repositories below ~/work
+  git-profile init --recursive ~/work --dry-run
 `,
 	Run: runInit,
 }
@@ -46,127 +83,399 @@ Usage:
 // runInit handles the init command execution.
 // It automatically sets Git credentials based on the repository's origin.
 // The function follows these steps:
-// 1. Get the current repository's origin
-// 2. Find profiles matching that origin
+// 1. Get the current repository's remotes (or the one passed with --remote)
+// 2. Find the best matching profiles by origin and path rules, trying the remotes in order
 // 3. If no matching profiles, prompt to create one
 // 4. If one matching profile, use it
 // 5. If multiple matching profiles, ask user to select one
-func runInit(cmd *cobra.Command, _ []string) {
-	currentOrigin, err := internal.GetRepoOrigin()
+func runInit(cmd *cobra.Command, args []string) {
+	if initHook {
+		runInitFromHook()
+		return
+	}
+
+	if initRecursive {
+		root := "."
+		if len(args) == 1 {
+			root = args[0]
+		}
+		runInitRecursive(resolvePath(root))
+		return
+	}
+
+	if len(args) != 0 {
+		failf(ExitUsage, "a root directory can only be given with --recursive")
+	}
+
+	requireRepo()
 
+	remotes, err := GetRemotesToMatch(initRemote)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		fail(ExitError, err)
 	}
 
-	possibleProfiles := internal.GetProfilesByOrigin(currentOrigin)
+	repoRoot, err := git.GetRepoRoot()
+	if err != nil {
+		fail(ExitError, err)
+	}
 
-	if len(possibleProfiles) == 0 {
-		fmt.Printf("No profiles found for origin %s\n", currentOrigin)
-		fmt.Print("Would you like to create a new one? (y/n): ")
+	possibleProfiles, matchedRemote := internal.ResolveRepoProfiles(store.GetAllProfiles(), remotes, repoRoot)
+
+	currentOrigin := "none"
+	if matchedRemote.Name != "" {
+		currentOrigin = matchedRemote.URL.Path()
+		note("Matching on remote %s (%s)", matchedRemote.Name, currentOrigin)
+	} else if len(remotes) != 0 {
+		currentOrigin = remotes[0].URL.Path()
+	}
 
-		answer := ReadAnswer()
+	var result ActionResult
+	var selectedProfile models.ProfileConfig
 
-		if answer == "n" {
-			fmt.Println("Nothing to do")
+	switch len(possibleProfiles) {
+	case 0:
+		note("No profiles found for origin %s", currentOrigin)
+		prompt("Would you like to create a new one? (y/n): ")
+
+		if ReadAnswer() == "n" {
+			result.Add(Action{Action: ActionNone, Target: repoRoot, Message: "Nothing to do"})
+			render(&result)
 			return
-		} else {
-			runAdd(cmd, []string{})
 		}
 
-		possibleProfiles = internal.GetProfilesByOrigin(currentOrigin)
+		result.Add(addProfile(nil, addOptions{remoteName: initRemote}))
+
+		possibleProfiles, _ = internal.ResolveRepoProfiles(store.GetAllProfiles(), remotes, repoRoot)
 
-		if CredentialsAlreadySet(possibleProfiles[0]) {
-			fmt.Println("Repository already has correct credentials. Nothing to do.")
+		if len(possibleProfiles) == 0 {
+			result.Add(Action{
+				Action:  ActionNone,
+				Target:  repoRoot,
+				Message: "New profile doesn't match the current repository. Nothing to do.",
+			})
+			render(&result)
 			return
 		}
+		selectedProfile = possibleProfiles[0]
+	case 1:
+		selectedProfile = possibleProfiles[0]
+	default:
+		note("Multiple profiles found for origin %s", currentOrigin)
+		for _, possibleProfile := range possibleProfiles {
+			PrintProfile(os.Stderr, possibleProfile, store.LayerOf(possibleProfile.ProfileName))
+		}
+		selectedProfile = PickProfile(possibleProfiles)
+	}
 
-		err = internal.SetUserName(possibleProfiles[0].Name, false)
-		if err != nil {
-			fmt.Println(err)
+	if git.ProfileApplied(selectedProfile, internal.ScopeLocal) {
+		result.Add(Action{
+			Action:  ActionNone,
+			Scope:   "local",
+			Profile: selectedProfile.ProfileName,
+			Target:  repoRoot,
+			Message: "Repository already has correct credentials. Nothing to do.",
+		})
+		render(&result)
+		return
+	}
+
+	err = git.ApplyProfile(selectedProfile, internal.ScopeLocal)
+	if err != nil {
+		fail(ExitError, err)
+	}
+
+	result.Add(Action{
+		Action:  ActionSet,
+		Scope:   "local",
+		Profile: selectedProfile.ProfileName,
+		Target:  repoRoot,
+		Message: fmt.Sprintf("Credentials of profile %s set for current project.", selectedProfile.ProfileName),
+	})
+	render(&result)
+}
+
+// PlanEntry is a repository in the plan of a recursive init.
+type PlanEntry struct {
+	Path     string            `json:"path" yaml:"path"`
+	Origin   string            `json:"origin" yaml:"origin"`
+	Current  internal.Identity `json:"current" yaml:"current"`
+	Action   string            `json:"action" yaml:"action"`
+	Profiles []string          `json:"profiles" yaml:"profiles"`
+	Error    string            `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// InitPlanResult is the outcome of a recursive init: the plan, and the actions taken if it was applied.
+type InitPlanResult struct {
+	Root         string      `json:"root" yaml:"root"`
+	Plan         []PlanEntry `json:"plan" yaml:"plan"`
+	ActionResult `yaml:",inline"`
+
+	plans     []internal.RepoPlan
+	planShown bool
+}
+
+// Text prints the plan as a table, unless it was already shown before asking, followed by the actions.
+func (r *InitPlanResult) Text(w io.Writer) {
+	if len(r.Plan) == 0 {
+		_, _ = fmt.Fprintf(w, "No repositories found below %s\n", r.Root)
+		return
+	}
+	if !r.planShown {
+		printPlan(w, r.Root, r.plans)
+	}
+	r.ActionResult.Text(w)
+}
+
+// runInitRecursive initializes all repositories below root.
+// It shows the plan first, then applies it after confirmation, unless --dry-run or --yes are set.
+func runInitRecursive(root string) {
+	if !humanOutput() && !initDryRun && !initYes {
+		failf(ExitUsage, "--recursive with --output %s needs --dry-run or --yes, as the plan can't be confirmed", outputFormat)
+	}
+
+	workTrees, err := internal.FindWorkTrees(root)
+	if err != nil {
+		fail(ExitError, err)
+	}
+
+	result := &InitPlanResult{Root: root, Plan: []PlanEntry{}, ActionResult: ActionResult{Actions: []Action{}}}
+	if len(workTrees) == 0 {
+		render(result)
+		return
+	}
+
+	plans := internal.PlanRepos(store.Config().GitSettings(), internal.RepoProfiles(store), workTrees)
+	result.plans = plans
+
+	pending := 0
+	for _, plan := range plans {
+		entry := PlanEntry{
+			Path:     plan.Path,
+			Origin:   plan.Origin(),
+			Current:  plan.Current,
+			Action:   plan.Action.String(),
+			Profiles: []string{},
+		}
+		for _, profile := range plan.Profiles {
+			entry.Profiles = append(entry.Profiles, profile.ProfileName)
 		}
+		if plan.Err != nil {
+			entry.Error = plan.Err.Error()
+		}
+		result.Plan = append(result.Plan, entry)
 
-		err = internal.SetUserEmail(possibleProfiles[0].Email, false)
-		if err != nil {
-			fmt.Println(err)
+		if plan.Action == internal.PlanApply || plan.Action == internal.PlanAmbiguous {
+			pending++
 		}
+	}
 
-		fmt.Printf("Credentials of profile %s set for current project.\n", possibleProfiles[0].ProfileName)
+	if initDryRun {
+		render(result)
+		return
+	}
+
+	if pending == 0 {
+		result.Add(Action{Action: ActionNone, Message: "Nothing to do."})
+		render(result)
+		return
+	}
+
+	if !initYes {
+		printPlan(os.Stdout, root, plans)
+		result.planShown = true
 
-	} else if len(possibleProfiles) == 1 {
-		if CredentialsAlreadySet(possibleProfiles[0]) {
-			fmt.Println("Repository already has correct credentials. Nothing to do.")
+		prompt("Apply these profiles? (y/n): ")
+		if ReadAnswer() == "n" {
+			result.Add(Action{Action: ActionNone, Message: "Nothing to do."})
+			render(result)
 			return
 		}
+	}
 
-		err = internal.SetUserName(possibleProfiles[0].Name, false)
-		if err != nil {
-			fmt.Println(err)
+	for _, plan := range plans {
+		var profile models.ProfileConfig
+
+		switch plan.Action {
+		case internal.PlanApply:
+			profile = plan.Profiles[0]
+		case internal.PlanAmbiguous:
+			if initYes {
+				result.Add(Action{
+					Action:  ActionSkip,
+					Target:  plan.Path,
+					Message: fmt.Sprintf("Skipping %s: multiple profiles match", plan.Path),
+				})
+				continue
+			}
+			note("Multiple profiles found for %s", plan.Path)
+			profile = PickProfile(plan.Profiles)
+		default:
+			continue
 		}
 
-		err = internal.SetUserEmail(possibleProfiles[0].Email, false)
-		if err != nil {
-			fmt.Println(err)
+		action := Action{Action: ActionSet, Scope: "local", Profile: profile.ProfileName, Target: plan.Path}
+		if err := applyProfileAt(plan.Path, profile); err != nil {
+			action.Message = fmt.Sprintf("Error setting profile %s for %s", profile.ProfileName, plan.Path)
+			action.Error = err.Error()
+		} else {
+			action.Message = fmt.Sprintf("Credentials of profile %s set for %s.", profile.ProfileName, plan.Path)
 		}
+		result.Add(action)
+	}
 
-		fmt.Printf("Credentials of profile %s set for current project.\n", possibleProfiles[0].ProfileName)
-	} else {
-		fmt.Printf("Multiple profiles found for origin %s\n", currentOrigin)
-		for _, possibleProfile := range possibleProfiles {
-			PrintProfile(possibleProfile)
+	if result.Failed() {
+		renderAndExit(result, ExitError)
+	}
+	render(result)
+}
+
+// printPlan lists each repository with its origin, current identity and target profile.
+func printPlan(w io.Writer, root string, plans []internal.RepoPlan) {
+	writer := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "REPOSITORY\tORIGIN\tCURRENT\tTARGET")
+
+	absRoot, _ := filepath.Abs(root)
+	for _, plan := range plans {
+		repoPath := plan.Path
+		if relPath, err := filepath.Rel(absRoot, plan.Path); err == nil {
+			repoPath = relPath
 		}
-		fmt.Println("Please pick a profile (enter the profile name):")
 
-		reader := bufio.NewReader(os.Stdin)
+		current := "none"
+		if plan.Current.Name != "" || plan.Current.Email != "" {
+			current = formatIdentity(plan.Current)
+		}
 
-		selectedProfile := models.ProfileConfig{}
+		_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", repoPath, plan.Origin(), current, describeTarget(plan))
+	}
+	_ = writer.Flush()
+}
 
-		for {
-			profileName, _ = reader.ReadString('\n')
-			profileName = strings.TrimSpace(profileName)
+// describeTarget describes what happens to a repository in the plan.
+func describeTarget(plan internal.RepoPlan) string {
+	switch plan.Action {
+	case internal.PlanApply:
+		return plan.Profiles[0].ProfileName
+	case internal.PlanUnchanged:
+		return plan.Profiles[0].ProfileName + " (already set)"
+	case internal.PlanAmbiguous:
+		var names []string
+		for _, profile := range plan.Profiles {
+			names = append(names, profile.ProfileName)
+		}
+		return "one of " + strings.Join(names, ", ")
+	case internal.PlanNoMatch:
+		return "no matching profile"
+	}
+	return fmt.Sprintf("error: %v", plan.Err)
+}
 
-			fits := false
-			for _, possibleProfile := range possibleProfiles {
-				if profileName == possibleProfile.ProfileName {
-					fits = true
-					selectedProfile = possibleProfile
-				}
-			}
-			if fits {
-				break
-			} else {
-				fmt.Println("Invalid choice. Please try again.")
+// applyProfileAt applies a profile to the repository at path.
+func applyProfileAt(path string, profile models.ProfileConfig) error {
+	repo, err := internal.OpenRepo(path, store.Config().GitSettings())
+	if err != nil {
+		return err
+	}
+	return repo.WithRecorder(recorder).ApplyProfile(profile, internal.ScopeLocal)
+}
+
+// PickProfile asks the user to pick one of the given profiles by name until a valid name is entered.
+// Without any input left, the command fails, as there is no one to ask.
+func PickProfile(possibleProfiles []models.ProfileConfig) models.ProfileConfig {
+	prompt("Please pick a profile (enter the profile name):\n")
+
+	reader := bufio.NewReader(os.Stdin)
+
+	for {
+		profileName, err := reader.ReadString('\n')
+		if err != nil && profileName == "" {
+			failf(ExitError, "no profile picked")
+		}
+		profileName = strings.TrimSpace(profileName)
+
+		for _, possibleProfile := range possibleProfiles {
+			if profileName == possibleProfile.ProfileName {
+				return possibleProfile
 			}
 		}
+		prompt("Invalid choice. Please try again.\n")
+	}
+}
+
+// runInitFromHook applies the matching profile without asking questions, as run by the post-checkout hook.
+// Only the first checkout of a fresh clone is handled, later checkouts are left alone.
+// Problems are reported on stderr, but never fail the checkout.
+func runInitFromHook() {
+	if !internal.IsNullRevision(initPreviousHead) {
+		return
+	}
+
+	remotes, err := GetRemotesToMatch(initRemote)
+	if err != nil {
+		note("git-profile: %v", err)
+		return
+	}
+
+	repoRoot, err := git.GetRepoRoot()
+	if err != nil {
+		note("git-profile: %v", err)
+		return
+	}
 
-		if CredentialsAlreadySet(selectedProfile) {
-			fmt.Println("Repository already has correct credentials. Nothing to do.")
+	possibleProfiles, _ := internal.ResolveRepoProfiles(store.GetAllProfiles(), remotes, repoRoot)
+
+	switch len(possibleProfiles) {
+	case 0:
+		note("git-profile: no profile found for this repository. Run \"git-profile init\" to add one.")
+	case 1:
+		if git.ProfileApplied(possibleProfiles[0], internal.ScopeLocal) {
 			return
 		}
-
-		err = internal.SetUserName(selectedProfile.Name, false)
-		if err != nil {
-			fmt.Println(err)
+		if err := git.ApplyProfile(possibleProfiles[0], internal.ScopeLocal); err != nil {
+			note("git-profile: %v", err)
+		}
+	default:
+		var names []string
+		for _, possibleProfile := range possibleProfiles {
+			names = append(names, possibleProfile.ProfileName)
 		}
+		note("git-profile: several profiles match (%s). Run \"git-profile init\" to pick one.", strings.Join(names, ", "))
+	}
+}
 
-		err = internal.SetUserEmail(selectedProfile.Email, false)
+// GetRemotesToMatch returns the remotes profiles are matched against.
+// That is the remote named remoteName, usually passed with --remote, or all remotes in the configured remote order.
+// Remotes whose URL can't be parsed are skipped with a warning.
+func GetRemotesToMatch(remoteName string) ([]internal.RepoRemote, error) {
+	if remoteName != "" {
+		remote, err := git.GetRepoRemoteByName(remoteName)
 		if err != nil {
-			fmt.Println(err)
+			return nil, err
 		}
-
-		fmt.Printf("Credentials of profile %s set for current project.\n", selectedProfile.ProfileName)
+		return []internal.RepoRemote{remote}, nil
 	}
-}
 
-// CredentialsAlreadySet checks if the current repository already has the same credentials as the given profile.
-// Returns true if both name and email match, false otherwise.
-func CredentialsAlreadySet(profile models.ProfileConfig) bool {
-	currentName, _ := internal.GetUserName()
-	currentEmail, _ := internal.GetUserEmail()
+	return getRepoRemotes()
+}
 
-	return profile.Name == currentName && profile.Email == currentEmail
+// getRepoRemotes returns all remotes of the repository, warning about those whose URL can't be parsed.
+func getRepoRemotes() ([]internal.RepoRemote, error) {
+	remotes, err := git.GetRepoRemotes()
+	if internal.IsInvalidRemotes(err) {
+		warn("%v", err)
+		return remotes, nil
+	}
+	return remotes, err
 }
 
 func init() {
 	rootCmd.AddCommand(initCmd)
+	initCmd.Flags().StringVarP(&initRemote, "remote", "r", "", "Match on this remote only instead of all remotes in the configured order")
+	initCmd.Flags().BoolVar(&initHook, "hook", false, "Run as post-checkout hook: never ask and only act on fresh clones")
+	initCmd.Flags().StringVar(&initPreviousHead, "previous-head", "", "Previous HEAD passed to the post-checkout hook")
+	initCmd.Flags().BoolVarP(&initRecursive, "recursive", "R", false, "Initialize all repositories below the root directory")
+	initCmd.Flags().BoolVar(&initDryRun, "dry-run", false, "Only show what --recursive would do")
+	initCmd.Flags().BoolVarP(&initYes, "yes", "y", false, "Apply the --recursive plan without asking")
+	_ = initCmd.Flags().MarkHidden("hook")
+	_ = initCmd.Flags().MarkHidden("previous-head")
 }
@@ -16,17 +16,14 @@ package cmd
 
 import (
 	"fmt"
-	"github.com/Shieldine/git-profile/internal"
 	"github.com/Shieldine/git-profile/models"
 	"github.com/spf13/cobra"
+	"io"
+	"strings"
 )
 
-var (
-	profileName string
-	name        string
-	email       string
-	origin      string
-)
+// lsFilter holds the filtering flags of the list command.
+var lsFilter profileFilter
 
 // lsCmd represents the list command for displaying git profiles
 var lsCmd = &cobra.Command{
@@ -39,6 +36,8 @@ var lsCmd = &cobra.Command{
 Provide a profile name to list the attributes of the specified profile.
 Use flags to filter for a specific origin, name or email.
 
+Each profile is shown with the config layer it comes from: system, user or repo.
+
 Examples:
   # List all profiles
   git-profile list
@@ -58,59 +57,135 @@ Examples:
 	Run: runLs,
 }
 
+// ListedProfile is a profile together with the config layer it comes from.
+type ListedProfile struct {
+	models.ProfileConfig `yaml:",inline"`
+	Layer                string `json:"layer" yaml:"layer"`
+}
+
+// ProfilesResult lists profiles.
+type ProfilesResult struct {
+	Profiles []ListedProfile `json:"profiles" yaml:"profiles"`
+}
+
+// Text prints each profile.
+func (r ProfilesResult) Text(w io.Writer) {
+	if len(r.Profiles) == 0 {
+		_, _ = fmt.Fprintln(w, "No profiles to display.")
+		return
+	}
+	for _, profile := range r.Profiles {
+		PrintProfile(w, profile.ProfileConfig, profile.Layer)
+	}
+}
+
+// listProfile returns a profile for listing, with its layer.
+func listProfile(profile models.ProfileConfig) ListedProfile {
+	return ListedProfile{ProfileConfig: profile, Layer: store.LayerOf(profile.ProfileName)}
+}
+
 // runLs handles the list command execution.
 // It supports two modes of operation:
 // 1. Display a specific profile by name (when an argument is provided)
 // 2. List all profiles, optionally filtered by name, email, or origin
 func runLs(_ *cobra.Command, args []string) {
+	result := ProfilesResult{Profiles: []ListedProfile{}}
+
 	if len(args) != 0 {
-		profileName = args[0]
+		profileName := args[0]
 
-		Profile := internal.GetProfileByName(profileName)
+		Profile := store.GetProfileByName(profileName)
 
-		if (models.ProfileConfig{}) == Profile {
-			fmt.Printf("Profile %s doesn't exist.", profileName)
-			return
+		if Profile.ProfileName == "" {
+			failf(ExitNotFound, "profile %s doesn't exist", profileName)
 		}
 
-		PrintProfile(Profile)
+		result.Profiles = append(result.Profiles, listProfile(Profile))
+		render(result)
 		return
 	}
 
-	Profiles := internal.GetAllProfiles()
-
-	if len(Profiles) == 0 {
-		fmt.Println("No profiles to display.")
-	} else {
-		for _, profile := range Profiles {
-			if name == "" && email == "" && origin == "" {
-				PrintProfile(profile)
-				continue
-			} else if name != "" && name != profile.Name {
-				continue
-			} else if email != "" && email != profile.Email {
-				continue
-			} else if origin != "" && origin != profile.Origin {
-				continue
-			}
-			PrintProfile(profile)
+	for _, profile := range store.GetAllProfiles() {
+		if lsFilter.matches(profile) {
+			result.Profiles = append(result.Profiles, listProfile(profile))
 		}
 	}
+
+	render(result)
+}
+
+// profileFilter selects profiles by the --name, --email and --origin flags of a command.
+// Empty values match every profile.
+type profileFilter struct {
+	name   string
+	email  string
+	origin string
 }
 
-// PrintProfile formats and prints the details of a Git profile.
-// It displays the profile name, origin, name, and email in a readable format.
-func PrintProfile(profile models.ProfileConfig) {
-	fmt.Printf("Profile %s:\n", profile.ProfileName)
-	fmt.Printf("  Origin: %s\n", profile.Origin)
-	fmt.Printf("  Name: %s\n", profile.Name)
-	fmt.Printf("  Email: %s\n", profile.Email)
-	fmt.Println()
+// addFlags adds the filtering flags to cmd. verb describes what the command does with the profiles, e.g. "List".
+func (f *profileFilter) addFlags(cmd *cobra.Command, verb string) {
+	cmd.Flags().StringVarP(&f.name, "name", "n", "", verb+" profiles with matching name")
+	cmd.Flags().StringVarP(&f.email, "email", "e", "", verb+" profiles with matching email")
+	cmd.Flags().StringVarP(&f.origin, "origin", "o", "", verb+" profiles with matching origin")
+}
+
+// empty reports whether no filter is set.
+func (f profileFilter) empty() bool {
+	return f.name == "" && f.email == "" && f.origin == ""
+}
+
+// matches reports whether the profile passes the filter.
+func (f profileFilter) matches(profile models.ProfileConfig) bool {
+	return (f.name == "" || f.name == profile.Name) &&
+		(f.email == "" || f.email == profile.Email) &&
+		(f.origin == "" || f.origin == profile.Origin)
+}
+
+// PrintProfile formats and prints the details of a Git profile to w.
+// It displays the profile name, origin, name, email, config layer, signing settings, SSH key and rules in a readable format.
+func PrintProfile(w io.Writer, profile models.ProfileConfig, layer string) {
+	_, _ = fmt.Fprintf(w, "Profile %s:\n", profile.ProfileName)
+	_, _ = fmt.Fprintf(w, "  Origin: %s\n", profile.Origin)
+	_, _ = fmt.Fprintf(w, "  Name: %s\n", profile.Name)
+	_, _ = fmt.Fprintf(w, "  Email: %s\n", profile.Email)
+	if layer != "" {
+		_, _ = fmt.Fprintf(w, "  Layer: %s\n", layer)
+	}
+	if profile.SigningKey != "" {
+		_, _ = fmt.Fprintf(w, "  Signing key: %s\n", profile.SigningKey)
+		if profile.SigningFormat != "" {
+			_, _ = fmt.Fprintf(w, "  Signing format: %s\n", profile.SigningFormat)
+		}
+		_, _ = fmt.Fprintf(w, "  Sign commits: %t\n", profile.SignCommits)
+		_, _ = fmt.Fprintf(w, "  Sign tags: %t\n", profile.SignTags)
+	}
+	if profile.SSHKey != "" {
+		_, _ = fmt.Fprintf(w, "  SSH key: %s\n", profile.SSHKey)
+	}
+	if len(profile.Rules) != 0 {
+		_, _ = fmt.Fprintln(w, "  Rules:")
+		for _, rule := range profile.Rules {
+			_, _ = fmt.Fprintf(w, "    %s\n", FormatRule(rule))
+		}
+	}
+	_, _ = fmt.Fprintln(w)
+}
+
+// FormatRule formats a profile rule as a short, human-readable description.
+func FormatRule(rule models.Rule) string {
+	var conditions []string
+
+	if rule.Path != "" {
+		conditions = append(conditions, "path "+rule.Path)
+	}
+	if rule.Origin != "" {
+		conditions = append(conditions, "origin "+rule.Origin)
+	}
+
+	return fmt.Sprintf("%s (priority %d)", strings.Join(conditions, " and "), rule.Priority)
 }
 
 func init() {
 	rootCmd.AddCommand(lsCmd)
-	lsCmd.Flags().StringVarP(&name, "name", "n", "", "List profiles with matching name")
-	lsCmd.Flags().StringVarP(&email, "email", "e", "", "List profiles with matching email")
-	lsCmd.Flags().StringVarP(&origin, "origin", "o", "", "List profiles with matching origin")
+	lsFilter.addFlags(lsCmd, "List")
 }
@@ -0,0 +1,273 @@
+// Package cmd
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/Shieldine/git-profile/internal"
+	"gopkg.in/yaml.v3"
+)
+
+// Output formats selectable with --output.
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+	OutputYAML  = "yaml"
+)
+
+// Exit codes of git-profile. Scripts rely on them, so existing codes must never change meaning.
+const (
+	// ExitOK means the command succeeded.
+	ExitOK = 0
+	// ExitError means the command failed.
+	ExitError = 1
+	// ExitUsage means the command was called with invalid arguments or flags.
+	ExitUsage = 2
+	// ExitNotRepo means the command has to be run inside a git repository.
+	ExitNotRepo = 3
+	// ExitNotFound means a profile given by name doesn't exist.
+	ExitNotFound = 4
+	// ExitMismatch means an identity or commits don't match the profile expected for the repository.
+	ExitMismatch = 5
+)
+
+// Actions reported in an ActionResult.
+const (
+	ActionAdd       = "add"
+	ActionUpdate    = "update"
+	ActionRemove    = "remove"
+	ActionSet       = "set"
+	ActionUnset     = "unset"
+	ActionInstall   = "install"
+	ActionUninstall = "uninstall"
+	ActionWrite     = "write"
+	ActionClone     = "clone"
+	ActionEdit      = "edit"
+	ActionRestore   = "restore"
+	ActionUndo      = "undo"
+	ActionSkip      = "skip"
+	ActionNone      = "none"
+)
+
+var outputFormat string
+
+// repoPath is the directory passed with -C, configFlag the config file passed with --config.
+// store and git are the profile store and the git client every command works with. They are set up before a command runs.
+var (
+	repoPath   string
+	configFlag string
+	// configLocation is where store keeps the config file and why.
+	configLocation internal.ConfigLocation
+	store          internal.ProfileStore
+	git            internal.GitClient
+	// recorder collects the changes the command makes through store and git, for the journal.
+	recorder *internal.Recorder
+)
+
+// Result is the typed outcome of a command.
+// With --output table, Text writes it for humans; otherwise the result itself is encoded as JSON or YAML.
+type Result interface {
+	Text(w io.Writer)
+}
+
+// Action describes a single change a command made, or why it made none.
+type Action struct {
+	Action  string `json:"action" yaml:"action"`
+	Scope   string `json:"scope,omitempty" yaml:"scope,omitempty"`
+	Profile string `json:"profile,omitempty" yaml:"profile,omitempty"`
+	Target  string `json:"target,omitempty" yaml:"target,omitempty"`
+	Message string `json:"message" yaml:"message"`
+	Error   string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// ActionResult lists the actions taken by a command that changes profiles or git configuration.
+type ActionResult struct {
+	Actions []Action `json:"actions" yaml:"actions"`
+	// Summary is printed after the actions in table output.
+	Summary string `json:"-" yaml:"-"`
+}
+
+// Add records an action.
+func (r *ActionResult) Add(action Action) {
+	r.Actions = append(r.Actions, action)
+}
+
+// Failed reports whether any action failed.
+func (r *ActionResult) Failed() bool {
+	for _, action := range r.Actions {
+		if action.Error != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Text prints the message of each action.
+func (r *ActionResult) Text(w io.Writer) {
+	for _, action := range r.Actions {
+		if action.Error != "" {
+			_, _ = fmt.Fprintf(w, "%s: %s\n", action.Message, action.Error)
+		} else if action.Message != "" {
+			_, _ = fmt.Fprintln(w, action.Message)
+		}
+	}
+	if r.Summary != "" {
+		_, _ = fmt.Fprintf(w, "\n%s\n", r.Summary)
+	}
+}
+
+// ErrorResult is how errors are reported with --output json or yaml.
+type ErrorResult struct {
+	Error    string `json:"error" yaml:"error"`
+	ExitCode int    `json:"exit_code" yaml:"exit_code"`
+}
+
+// Text prints the error the way cobra does.
+func (r ErrorResult) Text(w io.Writer) {
+	_, _ = fmt.Fprintln(w, "Error:", r.Error)
+}
+
+// CommandError is an error that ends the command with a specific exit code.
+type CommandError struct {
+	Code int
+	Err  error
+}
+
+func (e *CommandError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// errNotRepo is reported by commands that have to run inside a git repository.
+var errNotRepo = &CommandError{Code: ExitNotRepo, Err: errors.New("not a git repository")}
+
+// ValidateOutputFormat checks the value of --output.
+func ValidateOutputFormat(format string) error {
+	switch format {
+	case OutputTable, OutputJSON, OutputYAML:
+		return nil
+	}
+	return fmt.Errorf("invalid output format %q, expected table, json or yaml", format)
+}
+
+// humanOutput reports whether output is meant for humans rather than scripts.
+func humanOutput() bool {
+	return outputFormat == OutputTable || outputFormat == ""
+}
+
+// WriteResult writes a result to w in the given format.
+func WriteResult(w io.Writer, format string, result Result) error {
+	switch format {
+	case OutputJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	case OutputYAML:
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(result); err != nil {
+			return err
+		}
+		return encoder.Close()
+	}
+
+	result.Text(w)
+	return nil
+}
+
+// render writes the result of the command to stdout.
+func render(result Result) {
+	if err := WriteResult(os.Stdout, outputFormat, result); err != nil {
+		fail(ExitError, err)
+	}
+}
+
+// renderAndExit writes the result of the command to stdout and exits with code.
+func renderAndExit(result Result, code int) {
+	render(result)
+	exit(code)
+}
+
+// fail reports err on stderr and exits. A CommandError decides the exit code on its own,
+// other errors end the command with code.
+func fail(code int, err error) {
+	var commandErr *CommandError
+	if errors.As(err, &commandErr) {
+		code = commandErr.Code
+	}
+
+	_ = WriteResult(os.Stderr, outputFormat, ErrorResult{Error: err.Error(), ExitCode: code})
+	exit(code)
+}
+
+// exit journals the changes made so far and exits with code.
+func exit(code int) {
+	saveJournal()
+	os.Exit(code)
+}
+
+// failf formats an error and reports it through fail.
+func failf(code int, format string, args ...any) {
+	fail(code, fmt.Errorf(format, args...))
+}
+
+// warn prints a warning on stderr.
+func warn(format string, args ...any) {
+	_, _ = fmt.Fprintf(os.Stderr, "warning: "+format+"\n", args...)
+}
+
+// note prints progress information on stderr, where it doesn't mix with the result.
+func note(format string, args ...any) {
+	_, _ = fmt.Fprintf(os.Stderr, format+"\n", args...)
+}
+
+// prompt asks the user for input on stderr.
+func prompt(format string, args ...any) {
+	_, _ = fmt.Fprintf(os.Stderr, format, args...)
+}
+
+// requireRepo ends the command with ExitNotRepo if it doesn't run inside a git repository.
+func requireRepo() {
+	if !git.CheckGitRepo() {
+		fail(ExitNotRepo, errNotRepo)
+	}
+}
+
+// userStore returns store without the repo layer, for profiles used outside of the current repository,
+// so a .git-profile.toml committed there never reaches the global configuration or other repositories.
+func userStore() internal.ProfileStore {
+	userStore, err := store.ForRepo("")
+	if err != nil {
+		fail(ExitError, err)
+	}
+	return userStore
+}
+
+// resolvePath makes a relative path given on the command line relative to the directory passed with -C, like git does.
+func resolvePath(path string) string {
+	if git.Dir() == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(git.Dir(), path)
+}
@@ -0,0 +1,65 @@
+// Package cmd
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// restoreCmd represents the restore command for going back to a backup of the config file
+var restoreCmd = &cobra.Command{
+	Use:   "restore <backup-id>",
+	Args:  cobra.ExactArgs(1),
+	Short: "Restore the config file from a backup",
+	Long: `Replace the profiles and settings with those of a backup.
+
+List the backups with "git-profile backup list" and check what would change with
+"git-profile backup diff <backup-id>". The current config file is backed up before
+it is replaced, so a restore can be undone by restoring that backup.
+
+Examples:
+  # Undo an accidental "rm --all"
+  git-profile backup list
+  git-profile restore 20240102T150405.000000Z
+`,
+	Run: runRestore,
+}
+
+// runRestore handles the restore command execution.
+func runRestore(_ *cobra.Command, args []string) {
+	id := args[0]
+
+	if _, err := store.GetBackup(id); err != nil {
+		fail(ExitNotFound, err)
+	}
+
+	if err := store.Restore(id); err != nil {
+		failf(ExitError, "error restoring backup %s: %v", id, err)
+	}
+
+	var result ActionResult
+	result.Add(Action{
+		Action:  ActionRestore,
+		Target:  id,
+		Message: fmt.Sprintf("Restored backup %s with %d profiles.", id, len(store.GetAllProfiles())),
+	})
+	render(&result)
+}
+
+func init() {
+	rootCmd.AddCommand(restoreCmd)
+}
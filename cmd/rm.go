@@ -16,13 +16,14 @@ package cmd
 
 import (
 	"fmt"
-	"github.com/Shieldine/git-profile/internal"
-	"github.com/Shieldine/git-profile/models"
 	"github.com/spf13/cobra"
-	"os"
 )
 
-var all bool
+// rmAll and rmFilter hold the flags of the rm command.
+var (
+	rmAll    bool
+	rmFilter profileFilter
+)
 
 // rmCmd represents the remove command for deleting git profiles
 var rmCmd = &cobra.Command{
@@ -31,13 +32,13 @@ var rmCmd = &cobra.Command{
 	Args:  cobra.MaximumNArgs(1),
 	Long: `Remove one or multiple profiles from the configuration.
 
-Use --all flag to remove all profiles.
+Use --all flag to remove all profiles of your config file. Profiles of the system and repo layers are kept.
 Use other flags to remove all profiles containing a specific name, email or origin.
 
 Provide <profile-name> to remove only the profile called <profile-name>.
 <profile-name> and filtering flags cannot be provided together.
 
-This action cannot be undone.
+The config file is backed up first, see "git-profile backup list" and "git-profile restore".
 
 Examples:
   # Remove a specific profile
@@ -64,75 +65,70 @@ Examples:
 // 2. Remove a specific profile by name (argument)
 // 3. Remove profiles matching filter criteria (--name, --email, --origin flags)
 func runRm(_ *cobra.Command, args []string) {
-	if all {
-		err := internal.ClearConfig()
+	var result ActionResult
+
+	if rmAll {
+		err := store.Clear()
 		if err != nil {
-			fmt.Println("Error removing all profiles:", err)
-			os.Exit(1)
+			failf(ExitError, "error removing all profiles: %v", err)
 		}
-		fmt.Println("All profiles removed from configuration.")
+		result.Add(Action{Action: ActionRemove, Message: "All profiles removed from configuration."})
+		render(&result)
 		return
 	}
 
 	if len(args) != 0 {
-		if name != "" || email != "" || origin != "" {
-			fmt.Println("Error: profile-name and flags cannot be provided together.")
-			fmt.Println("Either provide a name or filtering options.")
-			os.Exit(1)
+		if !rmFilter.empty() {
+			failf(ExitUsage, "profile-name and flags cannot be provided together, either provide a name or filtering options")
 		}
 
 		profile := args[0]
-		err := internal.DeleteProfile(profile)
+		if store.GetProfileByName(profile).ProfileName == "" {
+			failf(ExitNotFound, "profile %s doesn't exist", profile)
+		}
+
+		err := store.DeleteProfile(profile)
 		if err != nil {
-			fmt.Printf("Error removing profile %s: %v\n", profile, err)
-			os.Exit(1)
+			failf(ExitError, "error removing profile %s: %v", profile, err)
 		}
-		fmt.Printf("Profile %s removed.\n", profile)
+		result.Add(Action{Action: ActionRemove, Profile: profile, Message: fmt.Sprintf("Profile %s removed.", profile)})
+		render(&result)
 		return
 	}
 
-	Profiles := internal.GetAllProfiles()
-	var profiles []models.ProfileConfig
-
-	profiles = append(profiles, Profiles...)
-
-	if len(profiles) == 0 {
-		fmt.Println("No profiles to remove.")
-	} else {
-		count := 0
-
-		for _, profile := range profiles {
-			if name != "" && name != profile.Name {
-				continue
-			} else if email != "" && email != profile.Email {
-				continue
-			} else if origin != "" && origin != profile.Origin {
-				continue
-			}
-
-			err := internal.DeleteProfile(profile.ProfileName)
-			if err != nil {
-				fmt.Printf("Error removing profile %s: %v\n", profile.ProfileName, err)
-				os.Exit(1)
-			}
-
-			fmt.Printf("Profile %s removed.\n", profile.ProfileName)
-			count += 1
+	for _, profile := range store.GetAllProfiles() {
+		if !rmFilter.matches(profile) {
+			continue
 		}
 
-		if count > 0 {
-			fmt.Println()
-			fmt.Printf("Successfuly removed %d profiles.\n", count)
-		} else {
-			fmt.Println("No profiles to remove.")
+		err := store.DeleteProfile(profile.ProfileName)
+		if err != nil {
+			result.Add(Action{
+				Action:  ActionRemove,
+				Profile: profile.ProfileName,
+				Message: fmt.Sprintf("Error removing profile %s", profile.ProfileName),
+				Error:   err.Error(),
+			})
+			renderAndExit(&result, ExitError)
 		}
+
+		result.Add(Action{
+			Action:  ActionRemove,
+			Profile: profile.ProfileName,
+			Message: fmt.Sprintf("Profile %s removed.", profile.ProfileName),
+		})
+	}
+
+	if len(result.Actions) == 0 {
+		result.Add(Action{Action: ActionNone, Message: "No profiles to remove."})
+	} else {
+		result.Summary = fmt.Sprintf("Successfully removed %d profiles.", len(result.Actions))
 	}
+	render(&result)
 }
 
 func init() {
 	rootCmd.AddCommand(rmCmd)
-	rmCmd.Flags().BoolVarP(&all, "all", "a", false, "Remove all profiles")
-	rmCmd.Flags().StringVarP(&name, "name", "n", "", "Remove profiles with name")
-	rmCmd.Flags().StringVarP(&email, "email", "e", "", "Remove profiles with email")
-	rmCmd.Flags().StringVarP(&origin, "origin", "o", "", "Remove profiles with origin")
+	rmCmd.Flags().BoolVarP(&rmAll, "all", "a", false, "Remove all profiles")
+	rmFilter.addFlags(rmCmd, "Remove")
 }
@@ -15,8 +15,15 @@
 package cmd
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/Shieldine/git-profile/internal"
 	"github.com/spf13/cobra"
 )
 
@@ -33,12 +40,130 @@ var rootCmd = &cobra.Command{
 Save a profile together with its origin and let git-profile set the attributes next time you clone a new repository.
 To make managing names and emails more convenient in general, git-profile offers further commands that will let you
 check, unset and set credentials without creating a profile. You also get the option to do these things globally.
+
+Like git, every command can be run on another repository with -C <path> (or --repo <path>)
+instead of the current working directory.
+
+The config file is taken from --config <path>, then the GIT_PROFILE_CONFIG environment variable,
+then $XDG_CONFIG_HOME/git-profile/config.toml, and otherwise from the platform default location.
+Run "git-profile config path" to see which one is used.
+Profiles are also read from the system config file (/etc/git-profile/config.toml) and from a
+.git-profile.toml committed in the repository, see "git-profile config --help".
+
+Every command writes its result to stdout. Use --output json or --output yaml to get it in a form
+scripts can read; errors, warnings and prompts always go to stderr.
+
+Exit codes:
+  0  success
+  1  the command failed
+  2  invalid arguments or flags
+  3  not a git repository
+  4  the profile doesn't exist
+  5  the identity or commits don't match the expected profile (verify, audit)
 `,
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	PersistentPreRunE: func(*cobra.Command, []string) error {
+		if err := ValidateOutputFormat(outputFormat); err != nil {
+			return err
+		}
+
+		location, err := internal.ResolveConfigPath(configFlag)
+		if err != nil {
+			return &CommandError{Code: ExitError, Err: err}
+		}
+		configLocation = location
+
+		if location.Source == internal.ConfigSourceDefault {
+			legacyPath := internal.LegacyConfigPath()
+			migrated, err := internal.MigrateConfig(legacyPath, location.Path)
+			if err != nil {
+				warn("%v", err)
+			} else if migrated {
+				note("Moved the config file from %s to %s.", legacyPath, location.Path)
+			}
+		}
+
+		repo, err := internal.OpenRepo(repoPath, internal.GitSettings{})
+		if err != nil {
+			return err
+		}
+		repoConfigPath := ""
+		if root, err := repo.GetRepoRoot(); err == nil {
+			repoConfigPath = filepath.Join(root, internal.RepoConfigFile)
+		}
+
+		layeredStore, err := internal.NewLayeredStore(internal.SystemConfigPath(), location.Path, repoConfigPath)
+		if err != nil {
+			return &CommandError{Code: ExitError, Err: err}
+		}
+		recorder = &internal.Recorder{}
+		layeredStore.SetRecorder(recorder)
+		store = layeredStore
+
+		// the settings are only known once the config is loaded
+		repo, err = internal.OpenRepo(repoPath, store.Config().GitSettings())
+		if err != nil {
+			return err
+		}
+		git = repo.WithRecorder(recorder)
+		return nil
+	},
+	PersistentPostRun: func(*cobra.Command, []string) {
+		saveJournal()
+	},
+}
+
+// undoneOperation is the ID of the operation reverted by the command, if it is a successful undo.
+var undoneOperation int
+
+// saveJournal appends the changes recorded while the command ran to the journal, as a single operation.
+// Commands that changed nothing aren't journaled. Failing to write the journal doesn't fail the command,
+// since the changes themselves are already made.
+func saveJournal() {
+	changes := recorder.Changes()
+	if len(changes) == 0 {
+		return
+	}
+	// the same changes must not be journaled twice if exiting after the command finished
+	recorder = nil
+
+	args := make([]string, 0, len(os.Args))
+	for _, arg := range os.Args[1:] {
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'") {
+			arg = strconv.Quote(arg)
+		}
+		args = append(args, arg)
+	}
+
+	journal := internal.NewJournal(internal.GetJournalPath(store.Dir()))
+	_, err := journal.Append(internal.Operation{
+		Time:    time.Now(),
+		Command: strings.Join(args, " "),
+		Undoes:  undoneOperation,
+		Changes: changes,
+	})
+	if err != nil {
+		warn("the changes couldn't be added to the journal, so they can't be undone: %v", err)
+	}
 }
 
 func Execute() {
 	err := rootCmd.Execute()
 	if err != nil {
-		os.Exit(1)
+		var commandErr *CommandError
+		if errors.As(err, &commandErr) {
+			fail(commandErr.Code, err)
+		}
+		if humanOutput() {
+			err = fmt.Errorf("%v\nRun 'git-profile --help' for usage.", err)
+		}
+		fail(ExitUsage, err)
 	}
 }
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&outputFormat, "output", OutputTable, "Output format: table, json or yaml")
+	rootCmd.PersistentFlags().StringVarP(&repoPath, "repo", "C", "", "Run as if git-profile was started in this directory instead of the current one")
+	rootCmd.PersistentFlags().StringVar(&configFlag, "config", "", "Use this config file instead of the one from GIT_PROFILE_CONFIG, XDG_CONFIG_HOME or the default location")
+}
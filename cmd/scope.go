@@ -0,0 +1,67 @@
+// Package cmd
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package cmd
+
+import (
+	"strings"
+
+	"github.com/Shieldine/git-profile/internal"
+	"github.com/spf13/cobra"
+)
+
+// scopeFlags are the flags selecting the git config scope a command works on, in the order of internal.Scope.
+var scopeFlags = []string{"local", "global", "system", "worktree"}
+
+// addScopeFlags adds --local, --global, --system and --worktree to cmd. verb describes what the command
+// does with the scope, e.g. "Set the profile in".
+func addScopeFlags(cmd *cobra.Command, verb string) {
+	cmd.Flags().Bool("local", false, verb+" the repository config (default)")
+	cmd.Flags().BoolP("global", "g", false, verb+" the global config of the user")
+	cmd.Flags().Bool("system", false, verb+" the system config shared by all users")
+	cmd.Flags().Bool("worktree", false, verb+" the config of the current worktree only")
+	cmd.MarkFlagsMutuallyExclusive(scopeFlags...)
+}
+
+// getScope returns the scope selected with the scope flags of cmd, ScopeLocal if none is given.
+func getScope(cmd *cobra.Command) internal.Scope {
+	for _, name := range scopeFlags {
+		if set, _ := cmd.Flags().GetBool(name); set {
+			scope, _ := internal.ParseScope(name)
+			return scope
+		}
+	}
+	return internal.ScopeLocal
+}
+
+// scopeTitle returns the name of a scope with a capital first letter, e.g. "Global".
+func scopeTitle(name string) string {
+	if name == "" {
+		return name
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// scopeTarget says where a change in the scope applies, e.g. "for current repository" or "globally".
+func scopeTarget(scope internal.Scope) string {
+	switch scope {
+	case internal.ScopeGlobal:
+		return "globally"
+	case internal.ScopeSystem:
+		return "system-wide"
+	case internal.ScopeWorktree:
+		return "for current worktree"
+	}
+	return "for current repository"
+}
@@ -16,26 +16,27 @@ package cmd
 
 import (
 	"bufio"
-	"errors"
 	"fmt"
-	"github.com/Shieldine/git-profile/custom_errors"
 	"os"
 	"strings"
 
 	"github.com/Shieldine/git-profile/internal"
-	"github.com/Shieldine/git-profile/models"
 	"github.com/spf13/cobra"
 )
 
+// setRemote holds the --remote flag of the set command.
+var setRemote string
+
 // setCmd represents the set command for changing git profiles
 var setCmd = &cobra.Command{
 	Use:     "set <profile-name>",
 	Aliases: []string{"s"},
 	Args:    cobra.ExactArgs(1),
 	Short:   "Set profile for current repository or globally",
-	Long: `Change the current repository's profile to <profile-name>, or set it globally with --global flag.
+	Long: `Change the current repository's profile to <profile-name>, or set it in another git config scope
+with --global, --system or --worktree.
 
-This command will apply the name and email from the specified profile to your git configuration.
+This command will apply the name, email, signing settings and SSH key from the specified profile to your git configuration.
 If the profile doesn't exist, you'll be prompted to create it.
 
 Examples:
@@ -44,111 +45,110 @@ Examples:
 
   # Set a profile globally
   git-profile set personal --global
+
+  # Set a profile for this worktree only, so other worktrees of the repository can use another one
+  git-profile set client --worktree
 `,
 	Run: runSet,
 }
 
 // runSet executes the set command logic.
 // It sets the git user configuration (name and email) based on the specified profile.
-// If --global flag is used, it sets the global git configuration; otherwise, it sets the local repository configuration.
+// The scope flags select the git configuration that is changed; without them, it is the local repository configuration.
 func runSet(cmd *cobra.Command, args []string) {
-	global, _ := cmd.Flags().GetBool("global")
+	scope := getScope(cmd)
 
-	if !global && !internal.CheckGitRepo() {
-		fmt.Println("error: not a git repository")
-		os.Exit(1)
+	if scope.NeedsRepo() {
+		requireRepo()
 	}
 
 	profileName := args[0]
 
-	profile := internal.GetProfileByName(profileName)
+	var result ActionResult
 
-	if (models.ProfileConfig{}) == profile {
-		fmt.Printf("Profile %s doesn't exist.\n", profileName)
-		fmt.Print("Would you like to create it? (y/n): ")
+	profile := store.GetProfileByName(profileName)
 
-		answer := ReadAnswer()
+	if !scope.NeedsRepo() && store.LayerOf(profileName) == internal.LayerRepo {
+		failf(ExitError, "profile %s comes from the %s of this repository and can't be set %s", profileName, internal.RepoConfigFile, scopeTarget(scope))
+	}
 
-		if answer == "n" {
-			fmt.Println("Nothing to do.")
-			return
-		}
+	if profile.ProfileName == "" {
+		note("Profile %s doesn't exist.", profileName)
+		prompt("Would you like to create it? (y/n): ")
 
-		if answer == "y" {
-			runAdd(cmd, []string{profileName})
+		if ReadAnswer() == "n" {
+			failf(ExitNotFound, "profile %s doesn't exist", profileName)
 		}
+
+		result.Add(addProfile([]string{profileName}, addOptions{remoteName: setRemote}))
 	}
 
-	profile = internal.GetProfileByName(profileName)
+	profile = store.GetProfileByName(profileName)
 
-	if !global {
-		currentOrigin, err := internal.GetRepoOrigin()
+	if scope.NeedsRepo() {
+		remotes, err := GetRemotesToMatch(setRemote)
 		if err != nil {
-			fmt.Printf("Error getting repository origin: %s\n", err)
-			os.Exit(1)
+			failf(ExitError, "error getting repository origin: %v", err)
 		}
 
-		if profile.Origin != currentOrigin {
-			fmt.Println("warning: profile origin and repo origin don't match.")
-			fmt.Printf("	Repo origin: %s\n", currentOrigin)
-			fmt.Printf("	Profile origin: %s\n", profile.Origin)
-			fmt.Println()
-		}
-	}
+		repoRoot, _ := git.GetRepoRoot()
 
-	var currentName, currentEmail string
-	var nameErr, emailErr error
+		matched := false
+		// the trailing empty remote lets path-only rules match
+		for _, remote := range append(remotes, internal.RepoRemote{}) {
+			if _, ok := internal.MatchProfile(profile, remote.URL, repoRoot); ok {
+				matched = true
+				break
+			}
+		}
 
-	if global {
-		currentName, nameErr = internal.GetGlobalUserName()
-		currentEmail, emailErr = internal.GetGlobalUserEmail()
-	} else {
-		currentName, nameErr = internal.GetUserName()
-		currentEmail, emailErr = internal.GetUserEmail()
-	}
+		if !matched {
+			repoOrigin := "none"
+			if len(remotes) != 0 {
+				repoOrigin = remotes[0].URL.Path()
+			}
 
-	if nameErr != nil {
-		var notSetErr *custom_errors.NotSetError
-		if !errors.As(nameErr, &notSetErr) {
-			fmt.Println("error: ", nameErr)
-			os.Exit(1)
+			warn("profile origin and repo origin don't match.\n\tRepo origin: %s\n\tProfile origin: %s", repoOrigin, profile.Origin)
 		}
 	}
 
-	if emailErr != nil {
-		var notSetErr *custom_errors.NotSetError
-		if !errors.As(emailErr, &notSetErr) {
-			fmt.Println("error: ", emailErr)
-			os.Exit(1)
-		}
+	if _, _, err := getIdentity(scope); err != nil {
+		fail(ExitError, err)
 	}
 
-	if profile.Name == currentName && profile.Email == currentEmail {
-		if global {
-			fmt.Println("Global configuration already has correct credentials. Nothing to do.")
-		} else {
-			fmt.Println("Repository already has correct credentials. Nothing to do.")
+	if git.ProfileApplied(profile, scope) {
+		message := "Repository already has correct credentials. Nothing to do."
+		if scope != internal.ScopeLocal {
+			message = fmt.Sprintf("%s configuration already has correct credentials. Nothing to do.", scopeTitle(scope.String()))
 		}
+		result.Add(Action{Action: ActionNone, Scope: scope.String(), Profile: profileName, Message: message})
+		render(&result)
 		return
 	}
 
-	err := internal.SetUserName(profile.Name, global)
+	err := git.ApplyProfile(profile, scope)
 	if err != nil {
-		fmt.Printf("Error setting user name: %s\n", err)
-		os.Exit(1)
+		fail(ExitError, err)
 	}
 
-	err = internal.SetUserEmail(profile.Email, global)
-	if err != nil {
-		fmt.Printf("Error setting user email: %s\n", err)
-		os.Exit(1)
-	}
+	message := fmt.Sprintf("Profile %s set %s.", profileName, scopeTarget(scope))
+	result.Add(Action{Action: ActionSet, Scope: scope.String(), Profile: profileName, Message: message})
+	render(&result)
+}
+
+// getIdentity returns the user name and email configured in the given scope.
+// Values that aren't set are returned empty.
+func getIdentity(scope internal.Scope) (string, string, error) {
+	currentName, nameErr := git.GetUserName(scope)
+	currentEmail, emailErr := git.GetUserEmail(scope)
 
-	if global {
-		fmt.Printf("Profile %s set globally.\n", profileName)
-	} else {
-		fmt.Printf("Profile %s set for current repository.\n", profileName)
+	if nameErr != nil && !isNotSet(nameErr) {
+		return "", "", nameErr
 	}
+	if emailErr != nil && !isNotSet(emailErr) {
+		return "", "", emailErr
+	}
+	return currentName, currentEmail, nil
 }
 
 // ReadAnswer prompts the user for a yes/no answer and validates the input.
@@ -159,7 +159,12 @@ func ReadAnswer() string {
 	answer := ""
 
 	for {
-		answer, _ = reader.ReadString('\n')
+		var err error
+		answer, err = reader.ReadString('\n')
+		if err != nil && answer == "" {
+			// without any input left, there is no one to ask
+			return "n"
+		}
 		answer = strings.TrimSpace(answer)
 		answer = strings.ToLower(answer)
 
@@ -168,14 +173,15 @@ func ReadAnswer() string {
 		} else if answer == "y" {
 			break
 		} else {
-			fmt.Println("Invalid choice. Choices are (y/n):")
+			prompt("Invalid choice. Choices are (y/n):\n")
 		}
 	}
 	return answer
 }
 
 func init() {
-	setCmd.Flags().BoolP("global", "g", false, "Set the profile globally instead of for the current repository")
+	addScopeFlags(setCmd, "Set the profile in")
+	setCmd.Flags().StringVarP(&setRemote, "remote", "r", "", "Check the profile against this remote only instead of all remotes")
 
 	rootCmd.AddCommand(setCmd)
 }
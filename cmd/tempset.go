@@ -16,22 +16,31 @@ package cmd
 
 import (
 	"bufio"
-	"errors"
-	"fmt"
 	"os"
 	"strings"
 
-	"github.com/Shieldine/git-profile/custom_errors"
 	"github.com/Shieldine/git-profile/internal"
+	"github.com/Shieldine/git-profile/models"
 	"github.com/spf13/cobra"
 )
 
+// These hold the flags of the tempset command.
+var (
+	tempSetName          string
+	tempSetEmail         string
+	tempSetSigningKey    string
+	tempSetSigningFormat string
+	tempSetSignCommits   bool
+	tempSetSignTags      bool
+	tempSetSSHKey        string
+)
+
 // tempSetCmd represents the tempset command for setting temporary git attributes
 var tempSetCmd = &cobra.Command{
 	Use:   "tempset",
 	Short: "Set attributes without defining a profile",
 	Long: `
-Set git attributes for the current repository or globally without saving them in a profile.
+Set git attributes for the current repository or another git config scope without saving them in a profile.
 The attributes can be passed as flags right away.
 If you don't pass them, you will be asked to provide a name and an email.
 
@@ -43,120 +52,105 @@ Examples:
   git-profile tempset --name "John Doe" --email "john@example.com"
 
   # Set temporary global attributes
-  git-profile tempset --global --name "John Doe" --email "john@example.com"`,
+  git-profile tempset --global --name "John Doe" --email "john@example.com"
+
+  # Set temporary attributes and sign commits with an SSH key
+  git-profile tempset --name "John Doe" --email "john@example.com" --signing-key ~/.ssh/id_ed25519.pub --signing-format ssh --sign-commits`,
 	Run: runTempSet,
 }
 
 // runTempSet executes the tempset command logic.
-// It sets Git user configuration (name and email) without creating a profile.
-// If --global flag is used, it sets the global Git configuration; otherwise, it sets the local repository configuration.
+// It sets Git user configuration (name, email and optionally signing settings) without creating a profile.
+// The scope flags select the git configuration that is changed; without them, it is the local repository configuration.
 // Attributes can be provided via flags or will be prompted interactively.
 func runTempSet(cmd *cobra.Command, _ []string) {
-	global, _ := cmd.Flags().GetBool("global")
+	scope := getScope(cmd)
 
-	if !global && !internal.CheckGitRepo() {
-		fmt.Println("error: not a git repository")
-		os.Exit(1)
+	if scope.NeedsRepo() {
+		requireRepo()
 	}
 
 	reader := bufio.NewReader(os.Stdin)
 
-	if name == "" {
-		var currentName string
-		var err error
-
-		if global {
-			currentName, err = internal.GetGlobalUserName()
-		} else {
-			currentName, err = internal.GetUserName()
-		}
-
-		if err != nil {
-			var notSetErr *custom_errors.NotSetError
-
-			if !errors.As(err, &notSetErr) {
-				fmt.Printf("error: %v\n", err)
-				os.Exit(1)
-			}
-		}
+	currentName, currentEmail, err := getIdentity(scope)
+	if err != nil {
+		fail(ExitError, err)
+	}
 
+	name := tempSetName
+	if name == "" {
 		if currentName != "" {
-			fmt.Printf("Name (enter to keep %s): ", currentName)
+			prompt("Name (enter to keep %s): ", currentName)
 		} else {
-			fmt.Print("Name: ")
+			prompt("Name: ")
 		}
 
 		name, _ = reader.ReadString('\n')
 		name = strings.TrimSpace(name)
+	}
 
-		if name != "" {
-			err = internal.SetUserName(name, global)
-			if err != nil {
-				fmt.Printf("Error while setting user name: %s\n", err)
-				os.Exit(1)
-			}
-		}
-	} else {
-		err := internal.SetUserName(name, global)
-
+	if name != "" {
+		err = git.SetUserName(name, scope)
 		if err != nil {
-			fmt.Printf("Error while setting user name: %s\n", err)
-			os.Exit(1)
+			failf(ExitError, "error while setting user name: %v", err)
 		}
 	}
 
+	email := tempSetEmail
 	if email == "" {
-		var currentEmail string
-		var err error
-
-		if global {
-			currentEmail, err = internal.GetGlobalUserEmail()
+		if currentEmail != "" {
+			prompt("Email (enter to keep %s): ", currentEmail)
 		} else {
-			currentEmail, err = internal.GetUserEmail()
+			prompt("E-Mail: ")
 		}
+		email, _ = reader.ReadString('\n')
+		email = strings.TrimSpace(email)
+	}
 
+	if email != "" {
+		err = git.SetUserEmail(email, scope)
 		if err != nil {
-			var notSetErr *custom_errors.NotSetError
-
-			if !errors.As(err, &notSetErr) {
-				fmt.Printf("error: %v\n", err)
-				os.Exit(1)
-			}
+			failf(ExitError, "error while setting user email: %v", err)
 		}
+	}
 
-		if currentEmail != "" {
-			fmt.Printf("Email (enter to keep %s): ", currentEmail)
-		} else {
-			fmt.Print("E-Mail: ")
+	if tempSetSigningKey != "" {
+		err := git.SetSigningConfig(models.ProfileConfig{
+			SigningKey:    tempSetSigningKey,
+			SigningFormat: tempSetSigningFormat,
+			SignCommits:   tempSetSignCommits,
+			SignTags:      tempSetSignTags,
+		}, scope)
+		if err != nil {
+			failf(ExitError, "error while setting signing configuration: %v", err)
 		}
-		email, _ = reader.ReadString('\n')
-		email = strings.TrimSpace(email)
+	}
 
-		if email != "" {
-			err = internal.SetUserEmail(email, global)
-			if err != nil {
-				fmt.Printf("Error while setting user email: %s\n", err)
-				os.Exit(1)
-			}
-		}
-	} else {
-		err := internal.SetUserEmail(email, global)
+	if tempSetSSHKey != "" {
+		err := git.SetSSHKey(tempSetSSHKey, scope)
 		if err != nil {
-			fmt.Printf("Error while setting user email: %s\n", err)
-			os.Exit(1)
+			failf(ExitError, "error while setting ssh key: %v", err)
 		}
 	}
 
-	if global {
-		fmt.Println("Global credentials set successfully")
-	} else {
-		fmt.Println("Credentials set successfully")
+	message := "Credentials set successfully"
+	if scope != internal.ScopeLocal {
+		message = scopeTitle(scope.String()) + " credentials set successfully"
 	}
+
+	var result ActionResult
+	result.Add(Action{Action: ActionSet, Scope: scope.String(), Message: message})
+	render(&result)
 }
 
 func init() {
 	rootCmd.AddCommand(tempSetCmd)
-	tempSetCmd.Flags().StringVarP(&name, "name", "n", "", "Pass the name directly")
-	tempSetCmd.Flags().StringVarP(&email, "email", "e", "", "Pass the email directly")
-	tempSetCmd.Flags().BoolP("global", "g", false, "Set the credentials globally instead of for the current repository")
+	tempSetCmd.Flags().StringVarP(&tempSetName, "name", "n", "", "Pass the name directly")
+	tempSetCmd.Flags().StringVarP(&tempSetEmail, "email", "e", "", "Pass the email directly")
+	tempSetCmd.Flags().StringVar(&tempSetSigningKey, "signing-key", "", "Set the signing key (GPG key ID, X.509 ID or SSH public key path)")
+	tempSetCmd.Flags().StringVar(&tempSetSigningFormat, "signing-format", "", "Set the signing format (openpgp, ssh or x509)")
+	tempSetCmd.Flags().BoolVar(&tempSetSignCommits, "sign-commits", false, "Sign commits with the signing key")
+	tempSetCmd.Flags().BoolVar(&tempSetSignTags, "sign-tags", false, "Sign tags with the signing key")
+	tempSetCmd.Flags().StringVar(&tempSetSSHKey, "ssh-key", "", "Set the SSH identity file used for fetching and pushing")
+	addScopeFlags(tempSetCmd, "Set the credentials in")
 }
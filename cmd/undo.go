@@ -0,0 +1,81 @@
+// Package cmd
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/Shieldine/git-profile/internal"
+	"github.com/spf13/cobra"
+)
+
+var undoForce bool
+
+// undoCmd represents the undo command for reverting the last operation in the journal
+var undoCmd = &cobra.Command{
+	Use:   "undo",
+	Args:  cobra.NoArgs,
+	Short: "Revert the last change to profiles or git config",
+	Long: `Revert the last operation recorded in the journal.
+
+Every command that changes profiles, settings or git config values (set, tempset, unset, init,
+add, update, rm, restore, ...) is recorded in the journal with the old and new value of each key.
+undo puts back the old values: profiles and settings in the config file, and git config values
+in the scope and repository they were written to. Running undo again reverts the operation before.
+See "git-profile history" for the operations recorded so far.
+
+If a value was changed again since, e.g. by running git config by hand, nothing is reverted,
+so that change isn't lost. Use --force to revert anyway.
+
+Examples:
+  # Put back the identity that was there before "git-profile set work --global"
+  git-profile undo
+`,
+	Run: runUndo,
+}
+
+// runUndo handles the undo command execution.
+func runUndo(_ *cobra.Command, _ []string) {
+	journal := internal.NewJournal(internal.GetJournalPath(store.Dir()))
+	operation, err := journal.LastUndoable()
+	if err != nil {
+		fail(ExitError, err)
+	}
+
+	err = internal.Undo(operation, store.Path(), store.Config().GitSettings(), recorder, undoForce)
+	if err != nil {
+		failf(ExitError, "error undoing operation %d (%s): %v", operation.ID, operation.Command, err)
+	}
+	undoneOperation = operation.ID
+
+	var result ActionResult
+	for i := len(operation.Changes) - 1; i >= 0; i-- {
+		change := operation.Changes[i]
+		result.Add(Action{
+			Action:  ActionUndo,
+			Scope:   change.Scope,
+			Target:  change.Key,
+			Message: "Reverted " + change.String(),
+		})
+	}
+	result.Summary = fmt.Sprintf("Undid operation %d: %s", operation.ID, operation.Command)
+	render(&result)
+}
+
+func init() {
+	rootCmd.AddCommand(undoCmd)
+
+	undoCmd.Flags().BoolVar(&undoForce, "force", false, "Revert even values that were changed again since")
+}
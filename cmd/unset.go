@@ -18,16 +18,16 @@ import (
 	"fmt"
 	"github.com/Shieldine/git-profile/internal"
 	"github.com/spf13/cobra"
-	"os"
 )
 
 // unsetCmd represents the unset command for removing Git attributes
 var unsetCmd = &cobra.Command{
 	Use:   "unset",
 	Short: "Reset attribute config to none",
-	Long: `Resets git attributes for current repository or globally.
+	Long: `Resets git attributes for current repository or another git config scope.
 If you unset local config, git will default to your global config.
-If you unset global config, git will have no default credentials.
+If you unset worktree config, git will default to the config of the repository.
+If you unset global config, git will only have the system config to default to.
 
 Examples:
   # Unset local repository attributes
@@ -35,40 +35,65 @@ Examples:
 
   # Unset global attributes
   git-profile unset --global
+
+  # Unset the attributes of the current worktree
+  git-profile unset --worktree
 `,
 	Run: runUnset,
 }
 
 // runUnset executes the unset command logic.
-// It removes Git user configuration (name and email) from either local repository or global scope.
-// If --global flag is used, it unsets the global Git configuration; otherwise, it unsets the local repository configuration.
+// It removes Git user configuration (name, email, signing settings and SSH key) from a git config scope.
+// The scope flags select the git configuration that is changed; without them, it is the local repository configuration.
 func runUnset(cmd *cobra.Command, _ []string) {
-	global, _ := cmd.Flags().GetBool("global")
-
-	if global {
-		fmt.Println("warning: removing global git credentials")
-	} else {
-		if !internal.CheckGitRepo() {
-			fmt.Println("error: not a git repository")
-			os.Exit(1)
-		}
+	scope := getScope(cmd)
 
-		fmt.Println("warning: git will default to global credentials without local configuration")
+	switch scope {
+	case internal.ScopeGlobal, internal.ScopeSystem:
+		warn("removing %s git credentials", scope)
+	case internal.ScopeWorktree:
+		requireRepo()
+		warn("git will default to repository credentials without worktree configuration")
+	default:
+		requireRepo()
+		warn("git will default to global credentials without local configuration")
 	}
 
-	err := internal.UnsetUserName(global)
+	currentName, currentEmail, err := getIdentity(scope)
 	if err != nil {
-		fmt.Printf("error: %v\n", err)
+		fail(ExitError, err)
 	}
 
-	err = internal.UnsetUserEmail(global)
-	if err != nil {
-		fmt.Printf("error: %v\n\n", err)
+	var result ActionResult
+
+	unsetValue := func(what string, isSet bool, unset func(internal.Scope) error) {
+		action := Action{Action: ActionUnset, Scope: scope.String(), Target: what}
+
+		if !isSet {
+			action.Action = ActionSkip
+			action.Message = fmt.Sprintf("No %s %s to unset", scope, what)
+		} else if err := unset(scope); err != nil {
+			action.Message = fmt.Sprintf("Error unsetting %s", what)
+			action.Error = err.Error()
+		} else {
+			action.Message = fmt.Sprintf("Unset %s %s", scope, what)
+		}
+		result.Add(action)
+	}
+
+	unsetValue("name", currentName != "", git.UnsetUserName)
+	unsetValue("email", currentEmail != "", git.UnsetUserEmail)
+	unsetValue("signing settings", true, git.UnsetSigningConfig)
+	unsetValue("SSH key", true, git.UnsetSSHKey)
+
+	if result.Failed() {
+		renderAndExit(&result, ExitError)
 	}
+	render(&result)
 }
 
 func init() {
-	unsetCmd.Flags().BoolP("global", "g", false, "Unset the credentials globally instead of for the current repository")
+	addScopeFlags(unsetCmd, "Unset the credentials in")
 
 	rootCmd.AddCommand(unsetCmd)
 }
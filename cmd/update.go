@@ -25,12 +25,20 @@ import (
 )
 
 var (
-	newName   string
-	newEmail  string
-	newOrigin string
-	oldName   string
-	oldEmail  string
-	oldOrigin string
+	newName          string
+	newEmail         string
+	newOrigin        string
+	newSigningKey    string
+	newSigningFormat string
+	newSignCommits   bool
+	newSignTags      bool
+	newSSHKey        string
+	newPaths         []string
+	newPriority      int
+	oldName          string
+	oldEmail         string
+	oldOrigin        string
+	editRemote       string
 )
 
 // editCmd represents the update command
@@ -59,6 +67,12 @@ Examples:
 
   # Update all profiles with a specific origin
   git-profile update --old-origin github.com --origin gitlab.com
+
+  # Use a profile for all repositories below a directory
+  git-profile update acme --path "~/work/clients/acme/**" --priority 10
+
+  # Let a profile sign commits with an SSH key
+  git-profile update myprofile --signing-key ~/.ssh/id_ed25519.pub --signing-format ssh --sign-commits
 `,
 	Run: runUpdate,
 }
@@ -70,21 +84,21 @@ Examples:
 //
 // In single profile mode, the user can update a profile interactively or using flags.
 // In batch mode, the command updates all profiles matching the filter criteria.
-func runUpdate(_ *cobra.Command, args []string) {
+func runUpdate(cmd *cobra.Command, args []string) {
 	reader := bufio.NewReader(os.Stdin)
+	var result ActionResult
 
 	// Single profile update
 	if len(args) == 1 {
 		profileName := args[0]
-		oldProfile := internal.GetProfileByName(profileName)
+		oldProfile := store.GetProfileByName(profileName)
 
-		if (models.ProfileConfig{}) == oldProfile {
-			fmt.Printf("Profile %s doesn't exist.\n", profileName)
-			return
+		if oldProfile.ProfileName == "" {
+			failf(ExitNotFound, "profile %s doesn't exist", profileName)
 		}
 
 		if newName == "" {
-			fmt.Printf("Name (enter to keep %s): ", oldProfile.Name)
+			prompt("Name (enter to keep %s): ", oldProfile.Name)
 			newName, _ = reader.ReadString('\n')
 			newName = strings.TrimSpace(newName)
 
@@ -94,7 +108,7 @@ func runUpdate(_ *cobra.Command, args []string) {
 		}
 
 		if newEmail == "" {
-			fmt.Printf("E-mail (enter to keep %s): ", oldProfile.Email)
+			prompt("E-mail (enter to keep %s): ", oldProfile.Email)
 			newEmail, _ = reader.ReadString('\n')
 			newEmail = strings.TrimSpace(newEmail)
 
@@ -104,7 +118,7 @@ func runUpdate(_ *cobra.Command, args []string) {
 		}
 
 		if newOrigin == "" {
-			fmt.Printf("Origin (enter to keep %s): ", oldProfile.Origin)
+			prompt("Origin (enter to keep %s): ", oldProfile.Origin)
 
 			newOrigin, _ = reader.ReadString('\n')
 			newOrigin = strings.TrimSpace(newOrigin)
@@ -113,49 +127,49 @@ func runUpdate(_ *cobra.Command, args []string) {
 				newOrigin = oldProfile.Origin
 			}
 		} else if newOrigin == "auto" {
-			currentOrigin, err := internal.GetRepoOrigin()
+			currentRemote, err := git.GetRepoRemoteByName(editRemote)
 
 			if err != nil {
-				fmt.Printf("Error: %s", err)
-				os.Exit(1)
+				fail(ExitError, err)
 			}
 
-			newOrigin = currentOrigin
+			newOrigin = currentRemote.URL.Host
+		}
+
+		if err := internal.ValidateOrigin(newOrigin); err != nil {
+			fail(ExitUsage, err)
 		}
 
-		err := internal.EditProfile(profileName, models.ProfileConfig{
-			ProfileName: profileName,
-			Name:        newName,
-			Email:       newEmail,
-			Origin:      newOrigin,
-		})
+		updatedProfile := oldProfile
+		updatedProfile.Name = newName
+		updatedProfile.Email = newEmail
+		updatedProfile.Origin = newOrigin
+		applySigningFlags(cmd, &updatedProfile)
+
+		err := store.EditProfile(profileName, updatedProfile)
 		if err != nil {
-			fmt.Printf("Error updating profile: %v\n", err)
-			return
+			failf(ExitError, "error updating profile: %v", err)
 		}
 
-		fmt.Printf("Profile %s updated\n", profileName)
+		result.Add(Action{Action: ActionUpdate, Profile: profileName, Message: fmt.Sprintf("Profile %s updated", profileName)})
+		render(&result)
 		return
 	}
 
 	// Batch update
 	if oldName == "" && oldEmail == "" && oldOrigin == "" {
-		fmt.Println("Error: When updating multiple profiles, you must specify at least one filter criteria (--old-name, --old-email, or --old-origin).")
-		return
+		failf(ExitUsage, "when updating multiple profiles, you must specify at least one filter criteria (--old-name, --old-email, or --old-origin)")
 	}
 
-	if newName == "" && newEmail == "" && newOrigin == "" {
-		fmt.Println("Error: When updating multiple profiles, you must specify at least one new value (--name, --email, or --origin).")
-		return
+	if newName == "" && newEmail == "" && newOrigin == "" && !signingFlagsChanged(cmd) {
+		failf(ExitUsage, "when updating multiple profiles, you must specify at least one new value (--name, --email, --origin, --ssh-key, --path or a signing flag)")
 	}
-
-	// Get all profiles
-	profiles := internal.GetAllProfiles()
-	if len(profiles) == 0 {
-		fmt.Println("No profiles to update.")
-		return
+	if err := internal.ValidateOrigin(newOrigin); err != nil {
+		fail(ExitUsage, err)
 	}
 
+	profiles := store.GetAllProfiles()
+
 	// Filter and update profiles
 	updatedCount := 0
 	for _, profile := range profiles {
@@ -166,12 +180,7 @@ func runUpdate(_ *cobra.Command, args []string) {
 			continue
 		}
 
-		updatedProfile := models.ProfileConfig{
-			ProfileName: profile.ProfileName,
-			Name:        profile.Name,
-			Email:       profile.Email,
-			Origin:      profile.Origin,
-		}
+		updatedProfile := profile
 
 		if newName != "" {
 			updatedProfile.Name = newName
@@ -181,33 +190,89 @@ func runUpdate(_ *cobra.Command, args []string) {
 		}
 		if newOrigin != "" {
 			if newOrigin == "auto" {
-				currentOrigin, err := internal.GetRepoOrigin()
+				currentRemote, err := git.GetRepoRemoteByName(editRemote)
 
 				if err != nil {
-					fmt.Printf("Error: %v", err)
-					os.Exit(1)
+					fail(ExitError, err)
 				}
 
-				updatedProfile.Origin = currentOrigin
+				updatedProfile.Origin = currentRemote.URL.Host
 			} else {
 				updatedProfile.Origin = newOrigin
 			}
 		}
 
-		err := internal.EditProfile(profile.ProfileName, updatedProfile)
+		applySigningFlags(cmd, &updatedProfile)
+
+		err := store.EditProfile(profile.ProfileName, updatedProfile)
 		if err != nil {
-			fmt.Printf("Error updating profile %s: %v\n", profile.ProfileName, err)
+			result.Add(Action{
+				Action:  ActionUpdate,
+				Profile: profile.ProfileName,
+				Message: fmt.Sprintf("Error updating profile %s", profile.ProfileName),
+				Error:   err.Error(),
+			})
 			continue
 		}
 
-		fmt.Printf("Profile %s updated\n", profile.ProfileName)
+		result.Add(Action{Action: ActionUpdate, Profile: profile.ProfileName, Message: fmt.Sprintf("Profile %s updated", profile.ProfileName)})
 		updatedCount++
 	}
 
 	if updatedCount > 0 {
-		fmt.Printf("\nSuccessfully updated %d profile(s).\n", updatedCount)
-	} else {
-		fmt.Println("No profiles matched the filter criteria.")
+		result.Summary = fmt.Sprintf("Successfully updated %d profile(s).", updatedCount)
+	} else if len(result.Actions) == 0 {
+		result.Add(Action{Action: ActionNone, Message: "No profiles matched the filter criteria."})
+	}
+
+	if result.Failed() {
+		renderAndExit(&result, ExitError)
+	}
+	render(&result)
+}
+
+// signingFlagsChanged reports whether any of the signing, SSH key or path flags were passed to the update command.
+func signingFlagsChanged(cmd *cobra.Command) bool {
+	for _, flag := range []string{"signing-key", "signing-format", "sign-commits", "sign-tags", "ssh-key", "path"} {
+		if cmd.Flags().Changed(flag) {
+			return true
+		}
+	}
+	return false
+}
+
+// applySigningFlags copies the signing, SSH key and path flags that were passed to the update command into the profile.
+// Passing --path replaces all path-only rules of the profile.
+// Flags that weren't passed leave the corresponding profile values untouched.
+func applySigningFlags(cmd *cobra.Command, profile *models.ProfileConfig) {
+	if cmd.Flags().Changed("signing-key") {
+		profile.SigningKey = newSigningKey
+	}
+	if cmd.Flags().Changed("signing-format") {
+		profile.SigningFormat = newSigningFormat
+	}
+	if cmd.Flags().Changed("sign-commits") {
+		profile.SignCommits = newSignCommits
+	}
+	if cmd.Flags().Changed("sign-tags") {
+		profile.SignTags = newSignTags
+	}
+	if cmd.Flags().Changed("ssh-key") {
+		profile.SSHKey = newSSHKey
+	}
+	if cmd.Flags().Changed("path") {
+		var rules []models.Rule
+		for _, rule := range profile.Rules {
+			if rule.Origin != "" {
+				rules = append(rules, rule)
+			}
+		}
+		for _, path := range newPaths {
+			if path != "" {
+				rules = append(rules, models.Rule{Path: path, Priority: newPriority})
+			}
+		}
+		profile.Rules = rules
 	}
 }
 
@@ -218,6 +283,16 @@ func init() {
 	editCmd.Flags().StringVarP(&newEmail, "email", "e", "", "Set the new email value")
 	editCmd.Flags().StringVarP(&newOrigin, "origin", "o", "", "Set the new origin value. Type \"auto\" to use current repository's origin")
 
+	editCmd.Flags().StringVar(&newSigningKey, "signing-key", "", "Set the new signing key. Pass an empty value to remove it")
+	editCmd.Flags().StringVar(&newSigningFormat, "signing-format", "", "Set the new signing format (openpgp, ssh or x509)")
+	editCmd.Flags().BoolVar(&newSignCommits, "sign-commits", false, "Sign commits with the signing key")
+	editCmd.Flags().BoolVar(&newSignTags, "sign-tags", false, "Sign tags with the signing key")
+	editCmd.Flags().StringVar(&newSSHKey, "ssh-key", "", "Set the new SSH identity file. Pass an empty value to remove it")
+	editCmd.Flags().StringArrayVar(&newPaths, "path", nil, "Replace the path rules with the given directory globs. Can be repeated, pass an empty value to remove them")
+	editCmd.Flags().IntVar(&newPriority, "priority", 0, "Set the priority of the path rules passed with --path")
+
+	editCmd.Flags().StringVarP(&editRemote, "remote", "r", "", "Take the origin for \"auto\" from this remote instead of the preferred one")
+
 	editCmd.Flags().StringVar(&oldName, "old-name", "", "Filter profiles by name")
 	editCmd.Flags().StringVar(&oldEmail, "old-email", "", "Filter profiles by email")
 	editCmd.Flags().StringVar(&oldOrigin, "old-origin", "", "Filter profiles by origin")
@@ -0,0 +1,251 @@
+// Package cmd
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Shieldine/git-profile/internal"
+	"github.com/spf13/cobra"
+)
+
+// These hold the flags of the verify command.
+var (
+	verifyPolicy string
+	verifyRemote string
+	verifyHook   bool
+)
+
+// verifyCmd represents the verify command for checking the identity against the expected profile
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verify that the current identity matches the profile expected for the repository",
+	Long: `Compare the effective user.name and user.email with the profile expected for the repository's origin.
+The effective identity is what git would put on a commit right now, taking every config scope
+and the GIT_AUTHOR_NAME and GIT_AUTHOR_EMAIL environment variables into account.
+
+Repositories without a matching profile always pass. If several profiles match,
+the identity of any of them is accepted.
+
+What happens on a mismatch depends on the verify policy, set through verify_policy in the
+config file or the --policy flag:
+  refuse  exit with status 5 (default)
+  fix     apply the expected profile to the repository
+  warn    print a warning, but exit successfully
+
+This is what the pre-commit hook installed by "git-profile hook install" runs.
+As git has read the identity before the hook runs, a commit fixed by the hook is still
+aborted once, so that it can be made again with the right identity.
+
+Examples:
+  # Check the current repository
+  git-profile verify
+
+  # Fix a mismatch right away
+  git-profile verify --policy fix
+`,
+	Run: runVerify,
+}
+
+// VerifyReport is the outcome of comparing the effective identity with the expected profiles.
+type VerifyReport struct {
+	Identity         internal.Identity `json:"identity" yaml:"identity"`
+	Remote           string            `json:"remote,omitempty" yaml:"remote,omitempty"`
+	ExpectedProfiles []string          `json:"expected_profiles" yaml:"expected_profiles"`
+	MatchedProfile   string            `json:"matched_profile,omitempty" yaml:"matched_profile,omitempty"`
+	OK               bool              `json:"ok" yaml:"ok"`
+	Policy           string            `json:"policy" yaml:"policy"`
+	Actions          []Action          `json:"actions,omitempty" yaml:"actions,omitempty"`
+
+	result internal.VerifyResult
+}
+
+// Text describes the mismatch and what was done about it.
+func (r VerifyReport) Text(w io.Writer) {
+	if !r.result.HasExpectation() {
+		_, _ = fmt.Fprintln(w, "No profile expected for this repository. Nothing to verify.")
+		return
+	}
+
+	if r.OK {
+		_, _ = fmt.Fprintf(w, "Identity matches profile %s.\n", r.MatchedProfile)
+		return
+	}
+
+	_, _ = fmt.Fprintf(w, "git-profile: identity %s does not match the profile expected for %s\n",
+		formatIdentity(r.Identity), describeRemote(r.result.Remote))
+	for _, profile := range r.result.Expected {
+		_, _ = fmt.Fprintf(w, "  expected profile %s: %s <%s>\n", profile.ProfileName, profile.Name, profile.Email)
+	}
+
+	for _, action := range r.Actions {
+		_, _ = fmt.Fprintln(w, action.Message)
+	}
+
+	if r.Policy == internal.VerifyPolicyRefuse {
+		_, _ = fmt.Fprintln(w, "Run \"git-profile init\" to apply the expected profile.")
+	}
+}
+
+// runVerify handles the verify command execution.
+// It exits with ExitMismatch if the identity doesn't match and the policy doesn't let it pass.
+// In hook mode, success is silent and everything else is reported on stderr, where git shows it.
+func runVerify(cmd *cobra.Command, _ []string) {
+	requireRepo()
+
+	policy, err := getVerifyPolicy(cmd)
+	if err != nil {
+		if cmd.Flags().Changed("policy") {
+			fail(ExitUsage, err)
+		}
+		fail(ExitError, err)
+	}
+
+	remotes, err := GetRemotesToMatch(verifyRemote)
+	if err != nil {
+		fail(ExitError, err)
+	}
+
+	repoRoot, err := git.GetRepoRoot()
+	if err != nil {
+		fail(ExitError, err)
+	}
+
+	result, err := git.VerifyIdentity(store.GetAllProfiles(), remotes, repoRoot)
+	if err != nil {
+		fail(ExitError, err)
+	}
+
+	report := VerifyReport{
+		Identity:         result.Identity,
+		ExpectedProfiles: []string{},
+		OK:               result.OK(),
+		Policy:           policy,
+		result:           result,
+	}
+	if result.Remote.Name != "" {
+		report.Remote = result.Remote.Name
+	}
+	for _, profile := range result.Expected {
+		report.ExpectedProfiles = append(report.ExpectedProfiles, profile.ProfileName)
+	}
+	if result.OK() && result.HasExpectation() {
+		report.MatchedProfile = result.Matched.ProfileName
+	}
+
+	code := ExitOK
+	if !report.OK {
+		switch policy {
+		case internal.VerifyPolicyWarn:
+		case internal.VerifyPolicyFix:
+			code = fixIdentity(&report)
+		default:
+			code = ExitMismatch
+		}
+	}
+
+	if verifyHook {
+		if !report.OK {
+			_ = WriteResult(os.Stderr, OutputTable, report)
+		}
+		exit(code)
+	}
+	renderAndExit(report, code)
+}
+
+// fixIdentity applies the expected profile to the repository, if there is exactly one, and returns the exit code.
+// In hook mode, the commit is aborted afterwards, since git has already read the old identity.
+func fixIdentity(report *VerifyReport) int {
+	expected := report.result.Expected
+
+	if len(expected) > 1 {
+		report.Actions = append(report.Actions, Action{
+			Action:  ActionSkip,
+			Message: "Several profiles match, not fixing automatically. Run \"git-profile init\" to pick one.",
+		})
+		return ExitMismatch
+	}
+
+	// git exports the identity it is about to use to hooks, so the variables only mean an override outside of them
+	if !verifyHook && (os.Getenv("GIT_AUTHOR_NAME") != "" || os.Getenv("GIT_AUTHOR_EMAIL") != "") {
+		report.Actions = append(report.Actions, Action{
+			Action:  ActionSkip,
+			Message: "The identity is set through GIT_AUTHOR_NAME or GIT_AUTHOR_EMAIL, not fixing automatically.",
+		})
+		return ExitMismatch
+	}
+
+	profile := expected[0]
+	if err := git.ApplyProfile(profile, internal.ScopeLocal); err != nil {
+		fail(ExitError, err)
+	}
+
+	report.Actions = append(report.Actions, Action{
+		Action:  ActionSet,
+		Scope:   "local",
+		Profile: profile.ProfileName,
+		Message: fmt.Sprintf("Credentials of profile %s set for current project.", profile.ProfileName),
+	})
+
+	if verifyHook {
+		report.Actions = append(report.Actions, Action{
+			Action:  ActionNone,
+			Message: "Commit aborted, run it again to use the new identity.",
+		})
+		return ExitMismatch
+	}
+	return ExitOK
+}
+
+// getVerifyPolicy returns the policy passed with --policy, or the one from the config file.
+func getVerifyPolicy(cmd *cobra.Command) (string, error) {
+	if cmd.Flags().Changed("policy") {
+		return internal.ParseVerifyPolicy(verifyPolicy)
+	}
+	return store.Config().GetVerifyPolicy()
+}
+
+// formatIdentity renders an identity the way git shows it, marking unset parts.
+func formatIdentity(identity internal.Identity) string {
+	name := identity.Name
+	if name == "" {
+		name = "(no name)"
+	}
+
+	email := identity.Email
+	if email == "" {
+		email = "no email"
+	}
+	return fmt.Sprintf("%s <%s>", name, email)
+}
+
+// describeRemote names the remote a profile was matched on, or the repository location for path rules.
+func describeRemote(remote internal.RepoRemote) string {
+	if remote.Name == "" {
+		return "this repository"
+	}
+	return fmt.Sprintf("remote %s (%s)", remote.Name, remote.URL.Path())
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+
+	verifyCmd.Flags().StringVar(&verifyPolicy, "policy", "", "What to do on a mismatch: refuse, fix or warn (default from config, refuse)")
+	verifyCmd.Flags().StringVarP(&verifyRemote, "remote", "r", "", "Match on this remote only instead of all remotes in the configured order")
+	verifyCmd.Flags().BoolVar(&verifyHook, "hook", false, "Run as git hook: stay quiet on success and abort after fixing")
+	_ = verifyCmd.Flags().MarkHidden("hook")
+}
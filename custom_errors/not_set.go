@@ -4,15 +4,14 @@ import "fmt"
 
 type NotSetError struct {
 	ConfigName string
-	Global     bool
+	// Scope is the name of the git config scope that was read, e.g. local or global.
+	Scope string
 }
 
 func (e *NotSetError) Error() string {
-	var configType string
+	configType := e.Scope
 
-	if e.Global {
-		configType = "global"
-	} else {
+	if configType == "" {
 		configType = "local"
 	}
 
@@ -0,0 +1,125 @@
+// Package gitprofile
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+
+// Package gitprofile is the API the git-profile CLI is built on, for embedding it in other tools.
+//
+// A ProfileStore keeps the profiles, a GitClient reads and writes the git configuration of a repository.
+// Both are opened with explicit paths and report errors instead of exiting:
+//
+//	store, err := gitprofile.NewFileStore(path)
+//	repo, err := gitprofile.OpenRepo(dir, store.Config().GitSettings())
+//	profiles, _ := gitprofile.ResolveRepoProfiles(store.GetAllProfiles(), remotes, root)
+//	err = repo.ApplyProfile(profiles[0], gitprofile.ScopeLocal)
+package gitprofile
+
+import (
+	"github.com/Shieldine/git-profile/internal"
+)
+
+type (
+	// ProfileStore keeps the profiles and settings of git-profile.
+	ProfileStore = internal.ProfileStore
+	// FileStore is a ProfileStore backed by a TOML file.
+	FileStore = internal.FileStore
+	// LayeredStore is a ProfileStore merging the system, user and repo config files.
+	LayeredStore = internal.LayeredStore
+	// Layer is one of the config files a LayeredStore is made of.
+	Layer = internal.Layer
+	// Config is the content of the config file: the settings and the profiles.
+	Config = internal.Config
+	// Backup is a snapshot of the config file, taken before it was changed.
+	Backup = internal.Backup
+	// ConfigLocation is where the config file is kept and which source decided it.
+	ConfigLocation = internal.ConfigLocation
+
+	// Journal is the append-only log of the operations that changed profiles or git config values.
+	Journal = internal.Journal
+	// Operation is an entry of the journal: the changes made by one command.
+	Operation = internal.Operation
+	// Change is a value changed by an operation, with its old and new value.
+	Change = internal.Change
+	// Recorder collects changes made through a FileStore or Repo, to be appended to the journal as an operation.
+	Recorder = internal.Recorder
+
+	// GitClient reads and writes the git configuration of a repository, or of the global and system scopes.
+	GitClient = internal.GitClient
+	// Repo is the GitClient that runs git in a directory.
+	Repo = internal.Repo
+	// GitSettings are the settings of the config file that change how repositories are read.
+	GitSettings = internal.GitSettings
+	// Scope is a git config scope.
+	Scope = internal.Scope
+
+	// RemoteURL is a parsed git remote URL.
+	RemoteURL = internal.RemoteURL
+	// RepoRemote is a named remote of a repository.
+	RepoRemote = internal.RepoRemote
+	// Identity is a user name and email.
+	Identity = internal.Identity
+	// EffectiveIdentity is the identity git uses for new commits, with where each value comes from.
+	EffectiveIdentity = internal.EffectiveIdentity
+	// VerifyResult is the outcome of comparing the effective identity with the expected profiles.
+	VerifyResult = internal.VerifyResult
+)
+
+// The git config scopes.
+const (
+	ScopeLocal    = internal.ScopeLocal
+	ScopeGlobal   = internal.ScopeGlobal
+	ScopeSystem   = internal.ScopeSystem
+	ScopeWorktree = internal.ScopeWorktree
+)
+
+// The config layers, from lowest to highest precedence.
+const (
+	LayerRepo   = internal.LayerRepo
+	LayerSystem = internal.LayerSystem
+	LayerUser   = internal.LayerUser
+)
+
+// RepoConfigFile is the name of the config file of the repo layer, in the root of the work tree.
+const RepoConfigFile = internal.RepoConfigFile
+
+// ErrConfigChanged is returned when saving would overwrite changes another process made to the config file.
+var ErrConfigChanged = internal.ErrConfigChanged
+
+var (
+	// DefaultConfigPath returns the platform default location of the config file.
+	DefaultConfigPath = internal.DefaultConfigPath
+	// ResolveConfigPath returns where the CLI keeps its config file, given the value of its --config flag.
+	ResolveConfigPath = internal.ResolveConfigPath
+	// NewFileStore opens the config file at path, creating it if needed, and loads it.
+	NewFileStore = internal.NewFileStore
+	// NewLayeredStore opens the system, user and repo config files at the given paths and merges them.
+	NewLayeredStore = internal.NewLayeredStore
+	// SystemConfigPath returns the location of the system config file.
+	SystemConfigPath = internal.SystemConfigPath
+	// GetJournalPath returns where the journal is kept in the config directory.
+	GetJournalPath = internal.GetJournalPath
+	// NewJournal returns the journal at path.
+	NewJournal = internal.NewJournal
+	// Undo reverts the changes of an operation.
+	Undo = internal.Undo
+	// OpenRepo returns a Repo for the directory at path, empty for the current working directory.
+	OpenRepo = internal.OpenRepo
+	// ParseScope returns the scope with the given name.
+	ParseScope = internal.ParseScope
+	// ParseRemoteURL parses a remote URL in any of the forms git understands.
+	ParseRemoteURL = internal.ParseRemoteURL
+	// ResolveProfiles returns the profiles that apply to a remote and repository path.
+	ResolveProfiles = internal.ResolveProfiles
+	// ResolveRepoProfiles returns the profiles of the first remote that has a match, and that remote.
+	ResolveRepoProfiles = internal.ResolveRepoProfiles
+)
@@ -0,0 +1,235 @@
+// Package internal
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Shieldine/git-profile/models"
+)
+
+// CommitIdentities is a commit together with the identities recorded on it.
+type CommitIdentities struct {
+	Hash      string
+	Subject   string
+	Author    Identity
+	Committer Identity
+}
+
+// commitFormat separates the fields of a commit with NUL and commits with the record separator.
+const commitFormat = "--format=%H%x00%an%x00%ae%x00%cn%x00%ce%x00%s%x1e"
+
+// ValidateRevRange checks that a revision range given by the user only holds revisions.
+// Anything starting with a dash would be taken as an option by git.
+func ValidateRevRange(revRange string) error {
+	for _, field := range strings.Fields(revRange) {
+		if strings.HasPrefix(field, "-") {
+			return fmt.Errorf("invalid range %q, revisions can't start with -", revRange)
+		}
+	}
+	return nil
+}
+
+// GetCommitIdentities lists the commits in revRange of the repository at dir with their author and committer.
+// An empty revRange stands for all commits reachable from HEAD. Repositories without commits yield none.
+func GetCommitIdentities(dir string, revRange string) ([]CommitIdentities, error) {
+	if err := ValidateRevRange(revRange); err != nil {
+		return nil, err
+	}
+	if revRange == "" {
+		if err := gitCommand(dir, "rev-parse", "--verify", "--quiet", "HEAD").Run(); err != nil {
+			return nil, nil
+		}
+		revRange = "HEAD"
+	}
+
+	output, err := gitCommand(dir, "log", commitFormat, revRange, "--").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history %s: %v", revRange, err)
+	}
+
+	return ParseCommitIdentities(string(output)), nil
+}
+
+// ParseCommitIdentities parses the output of git log with commitFormat.
+func ParseCommitIdentities(output string) []CommitIdentities {
+	var commits []CommitIdentities
+
+	for _, record := range strings.Split(output, "\x1e") {
+		fields := strings.Split(strings.TrimLeft(record, "\n"), "\x00")
+		if len(fields) != 6 {
+			continue
+		}
+
+		commits = append(commits, CommitIdentities{
+			Hash:      fields[0],
+			Author:    Identity{Name: fields[1], Email: fields[2]},
+			Committer: Identity{Name: fields[3], Email: fields[4]},
+			Subject:   fields[5],
+		})
+	}
+	return commits
+}
+
+// AuditCommit is a commit recorded with a wrong identity.
+type AuditCommit struct {
+	Hash    string   `json:"hash" yaml:"hash"`
+	Subject string   `json:"subject" yaml:"subject"`
+	Roles   []string `json:"roles" yaml:"roles"`
+}
+
+// AuditOffender groups the commits recorded with the same wrong identity.
+type AuditOffender struct {
+	Name    string        `json:"name" yaml:"name"`
+	Email   string        `json:"email" yaml:"email"`
+	Commits []AuditCommit `json:"commits" yaml:"commits"`
+}
+
+// AuditReport is the result of auditing the history of a repository.
+type AuditReport struct {
+	Path             string          `json:"path" yaml:"path"`
+	Origin           string          `json:"origin" yaml:"origin"`
+	ExpectedProfiles []string        `json:"expected_profiles" yaml:"expected_profiles"`
+	CommitsChecked   int             `json:"commits_checked" yaml:"commits_checked"`
+	Offenders        []AuditOffender `json:"offenders" yaml:"offenders"`
+	Error            string          `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// OffendingCommits returns how many commits carry a wrong identity.
+func (r AuditReport) OffendingCommits() int {
+	seen := map[string]bool{}
+	for _, offender := range r.Offenders {
+		for _, commit := range offender.Commits {
+			seen[commit.Hash] = true
+		}
+	}
+	return len(seen)
+}
+
+// AuditRepo compares the authors and committers in revRange of the repository at path with
+// those of the given profiles that are expected for it. If committers is false, only authors are checked.
+// Repositories without an expected profile are reported without checking any commit.
+// The repository is read with the given git settings.
+func AuditRepo(settings GitSettings, profiles []models.ProfileConfig, path string, revRange string, committers bool) AuditReport {
+	report := AuditReport{Path: path, Origin: "none", ExpectedProfiles: []string{}, Offenders: []AuditOffender{}}
+
+	remotes, err := Repo{dir: path, settings: settings}.GetRepoRemotes()
+	// remotes that can't be parsed are left out, the others still decide the profile
+	if err != nil && !IsInvalidRemotes(err) {
+		report.Error = err.Error()
+		return report
+	}
+
+	expected, remote := ResolveRepoProfiles(profiles, remotes, path)
+	if remote.Name != "" {
+		report.Origin = remote.URL.Path()
+	} else if len(remotes) != 0 {
+		report.Origin = remotes[0].URL.Path()
+	}
+
+	for _, profile := range expected {
+		report.ExpectedProfiles = append(report.ExpectedProfiles, profile.ProfileName)
+	}
+	if len(expected) == 0 {
+		return report
+	}
+
+	commits, err := GetCommitIdentities(path, revRange)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+
+	report.CommitsChecked = len(commits)
+	report.Offenders = groupOffenders(commits, expected, committers)
+	return report
+}
+
+// groupOffenders collects the commits not made with one of the expected profiles, grouped by identity.
+// Identities are ordered by their first appearance in the history.
+func groupOffenders(commits []CommitIdentities, expected []models.ProfileConfig, committers bool) []AuditOffender {
+	offenders := []AuditOffender{}
+	index := map[Identity]int{}
+
+	matchesAny := func(identity Identity) bool {
+		for _, profile := range expected {
+			if IdentityMatchesProfile(identity, profile) {
+				return true
+			}
+		}
+		return false
+	}
+
+	add := func(identity Identity, commit CommitIdentities, roles []string) {
+		key := identity.normalized()
+		i, ok := index[key]
+		if !ok {
+			i = len(offenders)
+			index[key] = i
+			offenders = append(offenders, AuditOffender{Name: identity.Name, Email: identity.Email})
+		}
+		offenders[i].Commits = append(offenders[i].Commits, AuditCommit{Hash: commit.Hash, Subject: commit.Subject, Roles: roles})
+	}
+
+	for _, commit := range commits {
+		authorOK := matchesAny(commit.Author)
+		committerOK := !committers || matchesAny(commit.Committer)
+
+		// a commit made and committed by the same wrong identity is listed once
+		if !authorOK && !committerOK && commit.Author.Equal(commit.Committer) {
+			add(commit.Author, commit, []string{"author", "committer"})
+			continue
+		}
+
+		if !authorOK {
+			add(commit.Author, commit, []string{"author"})
+		}
+		if !committerOK {
+			add(commit.Committer, commit, []string{"committer"})
+		}
+	}
+
+	return offenders
+}
+
+// AuditRepos audits several repositories concurrently. The reports are returned in the order of paths.
+// Each repository is audited with the profiles profiles returns for it.
+func AuditRepos(settings GitSettings, profiles ProfilesFunc, paths []string, revRange string, committers bool) []AuditReport {
+	reports := make([]AuditReport, len(paths))
+	semaphore := make(chan struct{}, concurrency)
+
+	var group sync.WaitGroup
+	for i, path := range paths {
+		group.Add(1)
+		go func(i int, path string) {
+			defer group.Done()
+
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			repoProfiles, err := profiles(path)
+			if err != nil {
+				reports[i] = AuditReport{Path: path, Origin: "none", ExpectedProfiles: []string{}, Offenders: []AuditOffender{}, Error: err.Error()}
+				return
+			}
+			reports[i] = AuditRepo(settings, repoProfiles, path, revRange, committers)
+		}(i, path)
+	}
+	group.Wait()
+
+	return reports
+}
@@ -0,0 +1,191 @@
+// Package internal
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/Shieldine/git-profile/models"
+)
+
+// defaultBackupLimit is the number of backups kept if the config doesn't set backup_limit.
+const defaultBackupLimit = 20
+
+// backupTimeFormat is the layout of backup IDs. IDs sort in the order the backups were taken.
+const backupTimeFormat = "20060102T150405.000000Z"
+
+// Backup is a snapshot of the config file, taken before it was changed.
+type Backup struct {
+	// ID identifies the backup. It is the time the backup was taken in UTC.
+	ID       string    `json:"id" yaml:"id"`
+	Time     time.Time `json:"time" yaml:"time"`
+	Path     string    `json:"path" yaml:"path"`
+	Profiles int       `json:"profiles" yaml:"profiles"`
+}
+
+// GetBackupLimit returns how many backups are kept. Zero or less disables backups.
+func (c Config) GetBackupLimit() int {
+	if c.BackupLimit == nil {
+		return defaultBackupLimit
+	}
+	return *c.BackupLimit
+}
+
+// BackupDir returns the directory the backups of the config file are kept in.
+func (s *FileStore) BackupDir() string {
+	return filepath.Join(s.Dir(), "backups")
+}
+
+// backupPath returns the location of the backup with the given ID.
+func (s *FileStore) backupPath(id string) string {
+	return filepath.Join(s.BackupDir(), "config-"+id+".toml")
+}
+
+// Backups returns the backups of the config file, newest first.
+func (s *FileStore) Backups() ([]Backup, error) {
+	entries, err := os.ReadDir(s.BackupDir())
+	if errors.Is(err, os.ErrNotExist) {
+		return []Backup{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup directory: %v", err)
+	}
+
+	backups := []Backup{}
+	for _, entry := range entries {
+		id, ok := strings.CutPrefix(entry.Name(), "config-")
+		id, ok2 := strings.CutSuffix(id, ".toml")
+		if !ok || !ok2 {
+			continue
+		}
+		taken, err := time.Parse(backupTimeFormat, id)
+		if err != nil {
+			continue
+		}
+
+		backup := Backup{ID: id, Time: taken, Path: s.backupPath(id)}
+		var conf Config
+		if _, err := toml.DecodeFile(backup.Path, &conf); err == nil {
+			backup.Profiles = len(conf.Profiles)
+		}
+		backups = append(backups, backup)
+	}
+
+	sort.Slice(backups, func(i, j int) bool { return backups[i].ID > backups[j].ID })
+	return backups, nil
+}
+
+// GetBackup returns the backup with the given ID.
+func (s *FileStore) GetBackup(id string) (Backup, error) {
+	backups, err := s.Backups()
+	if err != nil {
+		return Backup{}, err
+	}
+	for _, backup := range backups {
+		if backup.ID == id {
+			return backup, nil
+		}
+	}
+	return Backup{}, fmt.Errorf("backup %s doesn't exist", id)
+}
+
+// Restore replaces the config file with the backup with the given ID.
+// The current config is backed up first, so a restore can be undone with another one.
+func (s *FileStore) Restore(id string) error {
+	backup, err := s.GetBackup(id)
+	if err != nil {
+		return err
+	}
+
+	content, err := os.ReadFile(backup.Path)
+	if err != nil {
+		return fmt.Errorf("failed to read backup %s: %v", id, err)
+	}
+
+	conf := Config{Profiles: []models.ProfileConfig{}}
+	if _, err := toml.Decode(string(content), &conf); err != nil {
+		return fmt.Errorf("failed to decode backup %s: %v", id, err)
+	}
+
+	return s.write(func([]byte) ([]byte, Config, error) {
+		return content, conf, nil
+	})
+}
+
+// backup snapshots content, the config file as it is before a change, and deletes the oldest
+// backups beyond the limit. Nothing is written if the newest backup already has the same content.
+// Only the user layer is backed up, the system and repo layers are managed by administrators or git.
+func (s *FileStore) backup(content []byte) error {
+	limit := s.conf.GetBackupLimit()
+	if limit <= 0 || s.layer != LayerUser {
+		return nil
+	}
+
+	backups, err := s.Backups()
+	if err != nil {
+		return err
+	}
+	if len(backups) != 0 {
+		if newest, err := os.ReadFile(backups[0].Path); err == nil && bytes.Equal(newest, content) {
+			return nil
+		}
+	}
+
+	if err := os.MkdirAll(s.BackupDir(), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create backup directory: %v", err)
+	}
+
+	// two changes within the same microsecond still get their own backup
+	taken := time.Now().UTC()
+	id := taken.Format(backupTimeFormat)
+	for len(backups) != 0 && id <= backups[0].ID {
+		taken = taken.Add(time.Microsecond)
+		id = taken.Format(backupTimeFormat)
+	}
+
+	if err := writeFileAtomic(s.backupPath(id), content, 0644); err != nil {
+		return err
+	}
+
+	backups = append([]Backup{{ID: id}}, backups...)
+	for _, old := range backups[min(limit, len(backups)):] {
+		if err := os.Remove(s.backupPath(old.ID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// DiffFiles returns a unified diff from the file at oldPath to the one at newPath, empty if they are the same.
+func DiffFiles(oldPath string, newPath string) (string, error) {
+	output, err := gitCommand("", "diff", "--no-index", "--no-color", "--", oldPath, newPath).Output()
+	if err != nil {
+		// git diff exits with 1 if the files differ
+		var exitError *exec.ExitError
+		if !errors.As(err, &exitError) || exitError.ExitCode() != 1 {
+			return "", fmt.Errorf("failed to compare %s with %s: %v", oldPath, newPath, err)
+		}
+	}
+	return string(output), nil
+}
@@ -0,0 +1,58 @@
+// Package internal
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package internal
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// GetCloneDir guesses the directory git clone creates for a URL when none is given.
+// Like git, it takes the last path component and drops a trailing .git.
+func GetCloneDir(rawURL string) (string, error) {
+	path := strings.TrimSpace(rawURL)
+	path = strings.TrimRight(path, `/\`)
+	path = strings.TrimSuffix(path, "/.git")
+	path = strings.TrimRight(path, `/\`)
+
+	if idx := strings.LastIndexAny(path, `/\:`); idx != -1 {
+		path = path[idx+1:]
+	}
+	path = strings.TrimSuffix(path, ".git")
+
+	if path == "" {
+		return "", fmt.Errorf("no directory name could be guessed from %s, please specify one", redactURL(rawURL))
+	}
+	return path, nil
+}
+
+// CloneRepo runs git clone for a URL into dir.
+// If sshKey is set, git uses it for the clone itself and keeps it as core.sshCommand of the new repository.
+// git's output and prompts are passed through to the terminal on stderr, keeping stdout for git-profile's own output.
+func CloneRepo(rawURL string, dir string, sshKey string) error {
+	args := []string{"clone"}
+	if sshKey != "" {
+		args = append(args, "--config", "core.sshCommand="+BuildSSHCommand(sshKey))
+	}
+	args = append(args, "--", rawURL, dir)
+
+	cmd := exec.Command("git", args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
@@ -15,126 +15,387 @@
 package internal
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"unicode"
 
 	"github.com/BurntSushi/toml"
 	"github.com/Shieldine/git-profile/models"
 )
 
+// Config is the content of the config file: the settings and the profiles.
 type Config struct {
-	Profiles []models.ProfileConfig `toml:"profiles"`
+	ResolveSSHHosts bool                   `toml:"resolve_ssh_hosts,omitempty" json:"resolve_ssh_hosts,omitempty"`
+	RemoteOrder     []string               `toml:"remote_order,omitempty" json:"remote_order,omitempty"`
+	VerifyPolicy    string                 `toml:"verify_policy,omitempty" json:"verify_policy,omitempty"`
+	BackupLimit     *int                   `toml:"backup_limit,omitempty" json:"backup_limit,omitempty"`
+	Profiles        []models.ProfileConfig `toml:"profiles" json:"profiles,omitempty"`
 }
 
-var (
-	Conf       Config
-	configPath string
-)
+// GitSettings returns the settings that change how repositories are read.
+func (c Config) GitSettings() GitSettings {
+	return GitSettings{RemoteOrder: c.RemoteOrder, ResolveSSHHosts: c.ResolveSSHHosts}
+}
 
-func init() {
-	exePath, err := os.Executable()
-	if err != nil {
-		fmt.Println("Error determining executable path: ", err)
-		os.Exit(1)
-	}
+// ProfileStore keeps the profiles and settings of git-profile.
+// FileStore is the implementation backed by a single config file, LayeredStore the one merging the system,
+// user and repo config files.
+type ProfileStore interface {
+	// Path returns the location of the config file.
+	Path() string
+	// Dir returns the directory git-profile keeps its other files in, like include files and hooks.
+	Dir() string
+	// Config returns the settings and profiles.
+	Config() Config
 
-	if runtime.GOOS == "windows" {
-		configPath = filepath.Join(filepath.Dir(exePath), "config.toml")
-	} else {
-		homeDir, err := os.UserHomeDir()
+	GetAllProfiles() []models.ProfileConfig
+	GetProfileByName(profileName string) models.ProfileConfig
+	AddProfile(profile models.ProfileConfig) error
+	EditProfile(profileName string, updatedProfile models.ProfileConfig) error
+	DeleteProfile(profileName string) error
+	// Clear removes all profiles and settings.
+	Clear() error
 
-		if err != nil {
-			fmt.Println("Error determining home directory: ", err)
-			os.Exit(1)
-		}
+	// Backups returns the snapshots taken before the profiles were changed, newest first.
+	Backups() ([]Backup, error)
+	// GetBackup returns the backup with the given ID.
+	GetBackup(id string) (Backup, error)
+	// Restore replaces the profiles and settings with those of a backup.
+	Restore(id string) error
 
-		configPath = filepath.Join(homeDir, ".config", "git-profile", "config.toml")
+	// Layers returns the config files the store is made of, from lowest to highest precedence.
+	Layers() []Layer
+	// LayerOf returns the layer the profile with the given name comes from, empty if there is no such profile.
+	LayerOf(profileName string) string
+	// AddProfileTo adds a profile to the given layer instead of the user layer.
+	AddProfileTo(layer string, profile models.ProfileConfig) error
+	// ForRepo returns the store as seen from the repository whose work tree is at workTree,
+	// with that repository's repo layer. An empty workTree leaves out the repo layer.
+	ForRepo(workTree string) (ProfileStore, error)
+}
+
+// ProfilesFunc returns the profiles that apply to the repository whose work tree is at workTree.
+type ProfilesFunc func(workTree string) ([]models.ProfileConfig, error)
+
+// ErrConfigChanged is returned when the config file was changed by another process after it was loaded.
+// Saving would overwrite those changes, so nothing is written.
+var ErrConfigChanged = errors.New("the config file was changed by another process since it was loaded, run the command again")
 
-		err = os.MkdirAll(filepath.Dir(configPath), os.ModePerm)
+// FileStore is a ProfileStore backed by a TOML file.
+// Changes are saved under an advisory lock on a lock file next to the config file, and are refused with
+// ErrConfigChanged if the file changed on disk since it was loaded.
+type FileStore struct {
+	path string
+	conf Config
+	// sum is the checksum of the file content as last loaded or saved.
+	sum [sha256.Size]byte
+	// recorder collects the profiles and settings changed through the store, nil to not record them.
+	recorder *Recorder
+	// layer is the layer the file makes up, see LayeredStore.
+	layer string
+	// meta tells which settings the file sets, as opposed to leaving them at their default.
+	meta toml.MetaData
+}
+
+// DefaultConfigPath returns the platform default location of the config file:
+// %AppData%\git-profile\config.toml on Windows, ~/.config/git-profile/config.toml elsewhere.
+// ResolveConfigPath decides whether it is used.
+func DefaultConfigPath() (string, error) {
+	if runtime.GOOS == "windows" {
+		configDir, err := os.UserConfigDir()
 		if err != nil {
-			fmt.Println("Error creating config directory: ", err)
-			os.Exit(1)
+			return "", fmt.Errorf("failed to determine config directory: %v", err)
 		}
+		return filepath.Join(configDir, "git-profile", "config.toml"), nil
 	}
 
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		file, err := os.Create(configPath)
-		if err != nil {
-			fmt.Printf("Failed to create config file: %v\n", err)
-			os.Exit(1)
-		}
-		_ = file.Close()
-		Conf = Config{Profiles: []models.ProfileConfig{}}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine home directory: %v", err)
+	}
+	return filepath.Join(homeDir, ".config", "git-profile", "config.toml"), nil
+}
+
+// NewFileStore opens the config file at path as the user layer and loads it.
+// The file and its directory are created if they don't exist yet.
+func NewFileStore(path string) (*FileStore, error) {
+	return newFileStore(path, LayerUser)
+}
+
+// newFileStore opens the config file at path as the given layer, creating it if needed, and loads it.
+func newFileStore(path string, layer string) (*FileStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create config directory: %v", err)
 	}
 
-	err = LoadConfig()
+	// opening without truncating leaves a file another process just wrote alone
+	file, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0644)
 	if err != nil {
-		fmt.Println("Error loading config file: ", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("failed to create config file: %v", err)
+	}
+	_ = file.Close()
+
+	store := &FileStore{path: path, layer: layer}
+	if err := store.Load(); err != nil {
+		return nil, err
 	}
+	return store, nil
+}
+
+// Path returns the location of the config file.
+func (s *FileStore) Path() string {
+	return s.path
+}
+
+// Dir returns the directory of the config file.
+func (s *FileStore) Dir() string {
+	return filepath.Dir(s.path)
 }
 
-func SetConfigPath(path string) {
-	configPath = path
+// Config returns the settings and profiles as last loaded or saved.
+func (s *FileStore) Config() Config {
+	return s.conf
 }
 
-func LoadConfig() error {
-	Conf.Profiles = []models.ProfileConfig{}
-	if _, err := toml.DecodeFile(configPath, &Conf); err != nil {
-		return fmt.Errorf("failed to decode internal file: %v", err)
+// lockPath returns the location of the lock file guarding the config file.
+// The config file itself can't be locked, as saving replaces it.
+// Only the user layer is locked next to the file: the directory of the system layer usually isn't writable,
+// and the repo layer is in a work tree, where the lock file would show up as untracked. Their lock files are
+// kept in the cache directory of the user instead, named after the file they guard, so users never share one.
+func (s *FileStore) lockPath() (string, error) {
+	if s.layer == LayerUser {
+		return s.path + ".lock", nil
+	}
+
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine cache directory: %v", err)
+	}
+	lockDir := filepath.Join(cacheDir, "git-profile", "locks")
+	if err := os.MkdirAll(lockDir, os.ModePerm); err != nil {
+		return "", fmt.Errorf("failed to create lock directory: %v", err)
 	}
+
+	sum := sha256.Sum256([]byte(filepath.Clean(s.path)))
+	return filepath.Join(lockDir, fmt.Sprintf("%x.lock", sum[:8])), nil
+}
+
+// Load reads the config file again, dropping any changes that weren't saved.
+// Only the user layer is read under the lock. The other layers are usually read by users who can't write them,
+// and as saving replaces the file in one step, reading them never sees a half-written file anyway.
+func (s *FileStore) Load() error {
+	if s.layer == LayerUser {
+		lockPath, err := s.lockPath()
+		if err != nil {
+			return err
+		}
+		lock, err := lockFile(lockPath, false)
+		if err != nil {
+			return err
+		}
+		defer lock.Unlock()
+	}
+
+	content, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	conf := Config{Profiles: []models.ProfileConfig{}}
+	meta, err := toml.Decode(string(content), &conf)
+	if err != nil {
+		return fmt.Errorf("failed to decode config file %s: %v", s.path, err)
+	}
+	s.conf = conf
+	s.meta = meta
+	s.sum = sha256.Sum256(content)
 	return nil
 }
 
-func SaveConfig() error {
-	file, err := os.Create(configPath)
+// Save writes the settings and profiles to the config file.
+// Returns ErrConfigChanged if the file was changed by another process since it was loaded.
+func (s *FileStore) Save() error {
+	return s.update(func(*Config) error { return nil })
+}
+
+// update applies change to a copy of the config and saves the result through write.
+func (s *FileStore) update(change func(conf *Config) error) error {
+	return s.write(func([]byte) ([]byte, Config, error) {
+		conf := s.conf
+		conf.Profiles = append([]models.ProfileConfig(nil), s.conf.Profiles...)
+		if err := change(&conf); err != nil {
+			return nil, Config{}, err
+		}
+
+		var buffer bytes.Buffer
+		if err := toml.NewEncoder(&buffer).Encode(conf); err != nil {
+			return nil, Config{}, fmt.Errorf("failed to encode config: %v", err)
+		}
+		return buffer.Bytes(), conf, nil
+	})
+}
+
+// write replaces the config file with the content and config returned by replace, which is given the current
+// content. The lock is held from checking the file for changes until the new content is in place, and the
+// current content is backed up before it is replaced. The loaded config is only replaced on success.
+func (s *FileStore) write(replace func(current []byte) ([]byte, Config, error)) error {
+	lockPath, err := s.lockPath()
 	if err != nil {
-		return fmt.Errorf("failed to save config file: %v", err)
+		return err
 	}
+	lock, err := lockFile(lockPath, true)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
 
-	if err := toml.NewEncoder(file).Encode(Conf); err != nil {
-		return fmt.Errorf("failed to encode config to file: %v", err)
+	current, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	if sha256.Sum256(current) != s.sum {
+		return ErrConfigChanged
 	}
 
-	_ = file.Close()
+	content, conf, err := replace(current)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(content, current) {
+		if err := s.backup(current); err != nil {
+			return fmt.Errorf("failed to back up config file: %v", err)
+		}
+		if err := writeFileAtomic(s.path, content, 0644); err != nil {
+			return fmt.Errorf("failed to save config file: %v", err)
+		}
+	}
+
+	recordConfigChanges(s.recorder, s, s.conf, conf)
+	if meta, err := toml.Decode(string(content), &Config{}); err == nil {
+		s.meta = meta
+	}
+	s.conf = conf
+	s.sum = sha256.Sum256(content)
+	return nil
+}
+
+// SetRecorder makes the store record the profiles and settings changed from now on with recorder.
+func (s *FileStore) SetRecorder(recorder *Recorder) {
+	s.recorder = recorder
+}
+
+// Revert sets the profiles and settings of the changes back to their old values, in the given order.
+func (s *FileStore) Revert(changes []Change) error {
+	return s.update(func(conf *Config) error {
+		return applyChanges(conf, changes)
+	})
+}
+
+// ValidateProfileName checks that a profile name can be used as a single file name,
+// since include sync writes one file per profile named after it.
+func ValidateProfileName(name string) error {
+	switch {
+	case strings.TrimSpace(name) == "":
+		return errors.New("profile name must not be empty")
+	case name == "." || name == "..":
+		return fmt.Errorf("invalid profile name %q", name)
+	case strings.ContainsAny(name, `/\:`):
+		return fmt.Errorf("invalid profile name %q, it must not contain / \\ or :", name)
+	case strings.IndexFunc(name, unicode.IsControl) >= 0:
+		return fmt.Errorf("invalid profile name %q, it must not contain control characters", name)
+	}
 	return nil
 }
 
-func AddProfile(profile models.ProfileConfig) error {
-	for _, existingProfile := range Conf.Profiles {
-		if existingProfile.ProfileName == profile.ProfileName {
-			return fmt.Errorf("profile with name %s already exists", profile.ProfileName)
+// ValidateProfile checks the name of a profile and the origin patterns of all its rules.
+func ValidateProfile(profile models.ProfileConfig) error {
+	if err := ValidateProfileName(profile.ProfileName); err != nil {
+		return err
+	}
+	for _, rule := range GetProfileRules(profile) {
+		if err := ValidateOrigin(rule.Origin); err != nil {
+			return err
 		}
 	}
+	return nil
+}
+
+func (s *FileStore) AddProfile(profile models.ProfileConfig) error {
+	if err := ValidateProfile(profile); err != nil {
+		return err
+	}
 
-	Conf.Profiles = append(Conf.Profiles, profile)
-	return SaveConfig()
+	return s.update(func(conf *Config) error {
+		for _, existingProfile := range conf.Profiles {
+			if existingProfile.ProfileName == profile.ProfileName {
+				return fmt.Errorf("profile with name %s already exists", profile.ProfileName)
+			}
+		}
+
+		conf.Profiles = append(conf.Profiles, profile)
+		return nil
+	})
 }
 
-func EditProfile(profileName string, updatedProfile models.ProfileConfig) error {
-	for i, existingProfile := range Conf.Profiles {
-		if existingProfile.ProfileName == profileName {
-			Conf.Profiles[i] = updatedProfile
-			return SaveConfig()
+func (s *FileStore) EditProfile(profileName string, updatedProfile models.ProfileConfig) error {
+	return s.update(func(conf *Config) error {
+		for i, existingProfile := range conf.Profiles {
+			if existingProfile.ProfileName == profileName {
+				conf.Profiles[i] = updatedProfile
+				return nil
+			}
 		}
-	}
-	return fmt.Errorf("profile with name %s not found", profileName)
+		return fmt.Errorf("profile with name %s not found", profileName)
+	})
 }
 
-func DeleteProfile(profileName string) error {
-	for i, existingProfile := range Conf.Profiles {
-		if existingProfile.ProfileName == profileName {
-			Conf.Profiles = append(Conf.Profiles[:i], Conf.Profiles[i+1:]...)
-			return SaveConfig()
+func (s *FileStore) DeleteProfile(profileName string) error {
+	return s.update(func(conf *Config) error {
+		for i, existingProfile := range conf.Profiles {
+			if existingProfile.ProfileName == profileName {
+				conf.Profiles = append(conf.Profiles[:i], conf.Profiles[i+1:]...)
+				return nil
+			}
 		}
+		return fmt.Errorf("profile with name %s not found", profileName)
+	})
+}
+
+// Layers returns the file as the only layer.
+func (s *FileStore) Layers() []Layer {
+	return []Layer{{Name: s.layer, Path: s.path, Exists: true}}
+}
+
+// LayerOf returns the layer of the file if it has a profile with the given name.
+func (s *FileStore) LayerOf(profileName string) string {
+	if s.GetProfileByName(profileName).ProfileName == "" {
+		return ""
 	}
-	return fmt.Errorf("profile with name %s not found", profileName)
+	return s.layer
 }
 
-func GetProfileByName(profileName string) models.ProfileConfig {
-	for _, existingProfile := range Conf.Profiles {
+// AddProfileTo adds a profile to the file, which only makes up the layer it was opened as.
+func (s *FileStore) AddProfileTo(layer string, profile models.ProfileConfig) error {
+	if layer != s.layer {
+		return fmt.Errorf("the %s layer isn't available, only the %s layer is", layer, s.layer)
+	}
+	return s.AddProfile(profile)
+}
+
+// ForRepo returns the store itself, a single file has no repo layer to swap.
+func (s *FileStore) ForRepo(string) (ProfileStore, error) {
+	return s, nil
+}
+
+func (s *FileStore) GetProfileByName(profileName string) models.ProfileConfig {
+	for _, existingProfile := range s.conf.Profiles {
 		if existingProfile.ProfileName == profileName {
 			return existingProfile
 		}
@@ -142,31 +403,44 @@ func GetProfileByName(profileName string) models.ProfileConfig {
 	return models.ProfileConfig{}
 }
 
-func GetAllProfiles() []models.ProfileConfig {
-	return Conf.Profiles
+// GetAllProfiles returns a copy of the profiles, so callers may change the store while iterating over them.
+func (s *FileStore) GetAllProfiles() []models.ProfileConfig {
+	return append([]models.ProfileConfig(nil), s.conf.Profiles...)
 }
 
-func GetConfigPath() string {
-	return configPath
+// Clear removes all profiles and settings from the config file.
+func (s *FileStore) Clear() error {
+	return s.update(func(conf *Config) error {
+		*conf = Config{Profiles: []models.ProfileConfig{}}
+		return nil
+	})
 }
 
-func ClearConfig() error {
-	file, err := os.Create(configPath)
-	if err != nil {
-		return fmt.Errorf("failed to reset config file: %v", err)
-	}
-	_ = file.Close()
-	return nil
+// GetProfilesByOrigin returns those of the given profiles whose origin pattern matches the given hostname.
+func GetProfilesByOrigin(profiles []models.ProfileConfig, origin string) []models.ProfileConfig {
+	return GetProfilesByRemote(profiles, RemoteURL{Host: origin})
 }
 
-func GetProfilesByOrigin(origin string) []models.ProfileConfig {
-	var profiles []models.ProfileConfig
+// GetProfilesByRemote returns those of the given profiles whose origin pattern matches the remote.
+// If several patterns match, only the profiles with the most specific one are returned.
+func GetProfilesByRemote(profiles []models.ProfileConfig, remote RemoteURL) []models.ProfileConfig {
+	var matching []models.ProfileConfig
+	bestSpecificity := 0
 
-	for _, profile := range Conf.Profiles {
-		if profile.Origin == origin {
-			profiles = append(profiles, profile)
+	for _, profile := range profiles {
+		specificity, ok := MatchOrigin(profile.Origin, remote)
+		if !ok {
+			continue
+		}
+
+		if specificity > bestSpecificity {
+			matching = nil
+			bestSpecificity = specificity
+		}
+		if specificity == bestSpecificity {
+			matching = append(matching, profile)
 		}
 	}
 
-	return profiles
+	return matching
 }
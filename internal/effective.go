@@ -0,0 +1,189 @@
+// Package internal
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// EnvScope is the scope of values that come from environment variables instead of a git config file.
+const EnvScope = "env"
+
+// The sources git reads the identity from, in order of precedence.
+// Entries starting with $ are environment variables, the others config keys.
+var (
+	authorNameSources     = []string{"$GIT_AUTHOR_NAME", "author.name", "user.name"}
+	authorEmailSources    = []string{"$GIT_AUTHOR_EMAIL", "author.email", "user.email", "$EMAIL"}
+	committerNameSources  = []string{"$GIT_COMMITTER_NAME", "committer.name", "user.name"}
+	committerEmailSources = []string{"$GIT_COMMITTER_EMAIL", "committer.email", "user.email", "$EMAIL"}
+	signingKeySources     = []string{"user.signingkey"}
+)
+
+// EffectiveValue is a setting as git resolves it, together with where it comes from.
+type EffectiveValue struct {
+	Value string `json:"value" yaml:"value"`
+	// Key is the config key or environment variable the value was taken from.
+	Key string `json:"key,omitempty" yaml:"key,omitempty"`
+	// Scope is the config scope (system, global, local, worktree or command) or env. It is empty if the value isn't set.
+	Scope string `json:"scope,omitempty" yaml:"scope,omitempty"`
+	// Origin is the file the value was read from, or "command line" for values passed with -c.
+	Origin string `json:"origin,omitempty" yaml:"origin,omitempty"`
+}
+
+// IsSet reports whether the value is set anywhere.
+func (v EffectiveValue) IsSet() bool {
+	return v.Scope != ""
+}
+
+// Describe says where the value comes from, e.g. "user.name in global config /home/me/.gitconfig".
+func (v EffectiveValue) Describe() string {
+	switch v.Scope {
+	case "":
+		return "not set"
+	case EnvScope:
+		return "environment variable " + v.Key
+	case "command":
+		return v.Key + " from the command line"
+	}
+
+	if v.Origin == "" {
+		return fmt.Sprintf("%s in %s config", v.Key, v.Scope)
+	}
+	return fmt.Sprintf("%s in %s config %s", v.Key, v.Scope, v.Origin)
+}
+
+// EffectiveIdentity is the identity git uses for new commits, resolved over all config scopes,
+// included files and environment variables.
+type EffectiveIdentity struct {
+	AuthorName     EffectiveValue `json:"author_name" yaml:"author_name"`
+	AuthorEmail    EffectiveValue `json:"author_email" yaml:"author_email"`
+	CommitterName  EffectiveValue `json:"committer_name" yaml:"committer_name"`
+	CommitterEmail EffectiveValue `json:"committer_email" yaml:"committer_email"`
+	SigningKey     EffectiveValue `json:"signing_key" yaml:"signing_key"`
+}
+
+// Author returns the name and email new commits are authored with.
+func (e EffectiveIdentity) Author() Identity {
+	return Identity{Name: e.AuthorName.Value, Email: e.AuthorEmail.Value}
+}
+
+// Committer returns the name and email new commits are committed with.
+func (e EffectiveIdentity) Committer() Identity {
+	return Identity{Name: e.CommitterName.Value, Email: e.CommitterEmail.Value}
+}
+
+// GetEffectiveIdentityAt resolves the identity git would use for a commit in dir (the current directory if empty).
+// Like git, the GIT_AUTHOR_* and GIT_COMMITTER_* environment variables win over author.*, committer.*
+// and user.* from whichever config scope has the last say, and EMAIL is the last resort for emails.
+func GetEffectiveIdentityAt(dir string) (EffectiveIdentity, error) {
+	var identity EffectiveIdentity
+	var err error
+
+	targets := []struct {
+		value   *EffectiveValue
+		sources []string
+	}{
+		{&identity.AuthorName, authorNameSources},
+		{&identity.AuthorEmail, authorEmailSources},
+		{&identity.CommitterName, committerNameSources},
+		{&identity.CommitterEmail, committerEmailSources},
+		{&identity.SigningKey, signingKeySources},
+	}
+
+	for _, target := range targets {
+		*target.value, err = resolveEffectiveValue(dir, target.sources)
+		if err != nil {
+			return EffectiveIdentity{}, err
+		}
+	}
+
+	return identity, nil
+}
+
+// GetEffectiveIdentity resolves the identity git would use for a commit in the directory of the repository.
+// See GetEffectiveIdentityAt for how it is resolved.
+func (r Repo) GetEffectiveIdentity() (EffectiveIdentity, error) {
+	return GetEffectiveIdentityAt(r.dir)
+}
+
+// resolveEffectiveValue returns the first source that is set.
+func resolveEffectiveValue(dir string, sources []string) (EffectiveValue, error) {
+	for _, source := range sources {
+		if envName, ok := strings.CutPrefix(source, "$"); ok {
+			if value, ok := os.LookupEnv(envName); ok {
+				return EffectiveValue{Value: value, Key: envName, Scope: EnvScope}, nil
+			}
+			continue
+		}
+
+		value, err := GetEffectiveConfigValue(dir, source)
+		if err != nil {
+			return EffectiveValue{}, err
+		}
+		if value.IsSet() {
+			return value, nil
+		}
+	}
+
+	return EffectiveValue{}, nil
+}
+
+// GetEffectiveConfigValue reads a config key the way git does for dir, and reports which scope and file it came from.
+// An unset key is returned as an empty EffectiveValue.
+func GetEffectiveConfigValue(dir string, key string) (EffectiveValue, error) {
+	output, err := gitCommand(dir, "config", "--show-origin", "--show-scope", "-z", "--get", key).Output()
+	if err != nil {
+		var exitError *exec.ExitError
+
+		if errors.As(err, &exitError) && exitError.ExitCode() == 1 {
+			return EffectiveValue{}, nil
+		}
+		return EffectiveValue{}, fmt.Errorf("failed to read %s: %v", key, err)
+	}
+
+	fields := strings.SplitN(string(output), "\x00", 4)
+	if len(fields) < 3 {
+		return EffectiveValue{}, fmt.Errorf("unexpected output of git config for %s: %q", key, output)
+	}
+
+	return EffectiveValue{
+		Value:  fields[2],
+		Key:    key,
+		Scope:  fields[0],
+		Origin: parseConfigOrigin(dir, fields[1]),
+	}, nil
+}
+
+// parseConfigOrigin turns an origin as shown by git config --show-origin into a file path,
+// made absolute against dir, or a plain description like "command line".
+func parseConfigOrigin(dir string, origin string) string {
+	kind, location, _ := strings.Cut(origin, ":")
+	if kind != "file" {
+		return kind
+	}
+
+	if !filepath.IsAbs(location) {
+		location = filepath.Join(dir, location)
+		if absLocation, err := filepath.Abs(location); err == nil {
+			location = absLocation
+		}
+	}
+	return location
+}
@@ -16,178 +16,324 @@ package internal
 
 import (
 	"errors"
+	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/Shieldine/git-profile/custom_errors"
+	"github.com/Shieldine/git-profile/models"
 )
 
-// CheckGitRepo checks if the current directory is inside a Git repository.
+// CheckGitRepo checks if the directory of repo is inside a Git repository.
 // Returns true if inside a Git repository, false otherwise.
-func CheckGitRepo() bool {
-	cmd := exec.Command("git", "rev-parse", "--is-inside-work-tree")
+func (r Repo) CheckGitRepo() bool {
+	cmd := r.command("rev-parse", "--is-inside-work-tree")
 	if err := cmd.Run(); err != nil {
 		return false
 	}
 	return true
 }
 
-// GetRepoOrigin retrieves the origin URL of the Git repository and extracts the hostname.
-// Returns the hostname (e.g., "github.com") from the remote origin URL.
-// Returns an error if not in a Git repository or if the origin URL cannot be retrieved.
-func GetRepoOrigin() (string, error) {
-	if !CheckGitRepo() {
-		return "", errors.New("not a git repository")
-	}
-	cmd := exec.Command("git", "config", "--get", "remote.origin.url")
-	output, err := cmd.Output()
+// GetRepoOrigin retrieves the preferred remote URL of the Git repository and extracts the hostname.
+// Returns the hostname (e.g., "github.com") from the remote URL.
+// Returns an error if not in a Git repository or if the remote URL cannot be retrieved.
+func (r Repo) GetRepoOrigin() (string, error) {
+	remote, err := r.GetRepoRemote()
 	if err != nil {
 		return "", err
 	}
+	return remote.Host, nil
+}
 
-	originURL := strings.TrimSpace(string(output))
+// GetRepoRemote retrieves the preferred remote URL of the Git repository, resolves it and parses it.
+// Returns host, port, owner and repository of the URL git effectively pushes to,
+// after insteadOf rewrites and, if enabled, SSH host alias lookup.
+// Returns an error if not in a Git repository or if the remote URL cannot be retrieved or parsed.
+func (r Repo) GetRepoRemote() (RemoteURL, error) {
+	remote, err := r.GetRepoRemoteByName("")
+	if err != nil {
+		return RemoteURL{}, err
+	}
+	return remote.URL, nil
+}
 
-	if strings.HasPrefix(originURL, "https://") {
-		originURL = strings.TrimPrefix(originURL, "https://")
-	} else if strings.HasPrefix(originURL, "http://") {
-		originURL = strings.TrimPrefix(originURL, "http://")
-	} else if strings.HasPrefix(originURL, "git@") {
-		originURL = strings.TrimPrefix(originURL, "git@")
-		if idx := strings.Index(originURL, ":"); idx != -1 {
-			originURL = originURL[:idx]
+// GetRepoRemoteByName retrieves and resolves the remote with the given name.
+// If name is empty, the preferred remote according to the configured remote order is used.
+// Returns an error if not in a Git repository or if the remote doesn't exist.
+func (r Repo) GetRepoRemoteByName(name string) (RepoRemote, error) {
+	if name == "" {
+		remotes, err := r.GetRepoRemotes()
+		if err != nil && !IsInvalidRemotes(err) {
+			return RepoRemote{}, err
 		}
+		if len(remotes) == 0 {
+			return RepoRemote{}, errors.New("repository has no remotes")
+		}
+		return remotes[0], nil
 	}
 
-	if idx := strings.Index(originURL, "/"); idx != -1 {
-		originURL = originURL[:idx]
+	rawURL, err := r.GetRemoteURL(name)
+	if err != nil {
+		return RepoRemote{}, err
 	}
-
-	return originURL, nil
+	return r.newRepoRemote(name, rawURL)
 }
 
-// SetUserName sets the Git user.name configuration.
-// If global is true, sets the global configuration; otherwise sets local repository configuration.
-// Returns an error if not in a Git repository (when global is false) or if the git command fails.
-func SetUserName(name string, global bool) error {
-	if !global && !CheckGitRepo() {
-		return errors.New("not a git repository")
+// GetRepoRemotes retrieves and resolves all remotes of the Git repository.
+// The remotes are ordered by the configured remote order, followed by the rest in git's order.
+// Remotes whose URL cannot be parsed are left out and reported with an InvalidRemotesError, returned together
+// with the remaining remotes. If none of the remotes can be parsed, that is an error of its own.
+// Returns an error if not in a Git repository.
+func (r Repo) GetRepoRemotes() ([]RepoRemote, error) {
+	if !r.CheckGitRepo() {
+		return nil, errors.New("not a git repository")
 	}
 
-	args := []string{"config", "user.name", name}
-	if global {
-		args = []string{"config", "--global", "user.name", name}
+	output, err := r.command("config", "-z", "--get-regexp", `^remote\..*\.url$`).Output()
+	if err != nil {
+		var exitError *exec.ExitError
+
+		// exit status 1 means there are no remotes
+		if errors.As(err, &exitError) && exitError.ExitCode() == 1 {
+			return nil, nil
+		}
+		return nil, err
 	}
 
-	cmd := exec.Command("git", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
-}
+	var names []string
+	rawURLs := map[string]string{}
 
-// UnsetUserName removes the Git user.name configuration.
-// If global is true, unsets the global configuration; otherwise unsets local repository configuration.
-// Returns an error if not in a Git repository (when global is false), if no username is set, or if the git command fails.
-func UnsetUserName(global bool) error {
-	if !global && !CheckGitRepo() {
-		return errors.New("not a git repository")
-	}
+	for _, entry := range strings.Split(string(output), "\x00") {
+		key, rawURL, found := strings.Cut(entry, "\n")
+		if !found {
+			continue
+		}
 
-	var args []string
-	if !global {
-		_, err := GetUserName()
-		if err != nil {
-			return errors.New("no local username to unset")
+		name := strings.TrimSuffix(strings.TrimPrefix(key, "remote."), ".url")
+		if _, seen := rawURLs[name]; !seen {
+			names = append(names, name)
 		}
+		rawURLs[name] = rawURL
+	}
 
-		args = []string{"config", "--unset", "user.name"}
-	} else {
-		_, err := GetGlobalUserName()
+	var remotes []RepoRemote
+	var invalid []error
+	for _, name := range OrderRemotes(names, r.settings.GetRemoteOrder()) {
+		remote, err := r.newRepoRemote(name, rawURLs[name])
 		if err != nil {
-			return errors.New("no global username to unset")
+			invalid = append(invalid, err)
+			continue
 		}
+		remotes = append(remotes, remote)
+	}
 
-		args = []string{"config", "--global", "--unset", "user.name"}
+	if len(invalid) == 0 {
+		return remotes, nil
+	}
+	if len(remotes) == 0 {
+		return nil, errors.Join(invalid...)
 	}
+	return remotes, &InvalidRemotesError{Errs: invalid}
+}
 
-	cmd := exec.Command("git", args...)
-	_, err := cmd.Output()
+// InvalidRemotesError lists the remotes GetRepoRemotes left out because their URL couldn't be parsed.
+type InvalidRemotesError struct {
+	Errs []error
+}
+
+func (e *InvalidRemotesError) Error() string {
+	messages := make([]string, len(e.Errs))
+	for i, err := range e.Errs {
+		messages[i] = err.Error()
+	}
+	return "skipped " + strings.Join(messages, ", ")
+}
+
+// IsInvalidRemotes reports whether err only tells about remotes that were left out, so the remotes returned
+// with it can still be used.
+func IsInvalidRemotes(err error) bool {
+	var invalid *InvalidRemotesError
+	return errors.As(err, &invalid)
+}
+
+// newRepoRemote resolves the raw URL of a remote of repo.
+func (r Repo) newRepoRemote(name string, rawURL string) (RepoRemote, error) {
+	resolvedURL, remote, err := r.ResolveRemote(rawURL)
 	if err != nil {
-		return err
+		return RepoRemote{}, fmt.Errorf("remote %s: %v", name, err)
 	}
-	return nil
+	return RepoRemote{Name: name, RawURL: rawURL, ResolvedURL: resolvedURL, URL: remote}, nil
 }
 
-// GetUserName retrieves the local Git user.name configuration.
-// Returns the username string or an error if not in a Git repository or if the username is not set.
-// Returns a custom NotSetError if the username is not configured locally.
-func GetUserName() (string, error) {
-	if !CheckGitRepo() {
-		return "", errors.New("not a git repository")
+// gitCommand builds a git command running in dir. An empty dir stands for the current directory.
+func gitCommand(dir string, args ...string) *exec.Cmd {
+	if dir != "" {
+		args = append([]string{"-C", dir}, args...)
+	}
+	return exec.Command("git", args...)
+}
+
+// GetRemoteNames retrieves the names of all remotes of the Git repository.
+// Returns an error if not in a Git repository.
+func (r Repo) GetRemoteNames() ([]string, error) {
+	if !r.CheckGitRepo() {
+		return nil, errors.New("not a git repository")
 	}
-	cmd := exec.Command("git", "config", "--get", "--local", "user.name")
-	output, err := cmd.CombinedOutput()
 
+	output, err := r.command("remote").Output()
 	if err != nil {
-		var exitError *exec.ExitError
+		return nil, err
+	}
 
-		ok := errors.As(err, &exitError)
-		if ok && exitError.ExitCode() == 1 && err.Error() == "exit status 1" {
-			return "", &custom_errors.NotSetError{ConfigName: "username"}
-		} else {
-			return "", err
-		}
+	return strings.Fields(string(output)), nil
+}
+
+// GetRemoteURL retrieves the URL of the remote with the given name as configured, without any rewrites.
+// Returns an error if not in a Git repository or if the remote URL cannot be retrieved.
+func (r Repo) GetRemoteURL(name string) (string, error) {
+	if !r.CheckGitRepo() {
+		return "", errors.New("not a git repository")
+	}
+	cmd := r.command("config", "--get", "remote."+name+".url")
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("no remote named %s", name)
 	}
+
 	return strings.TrimSpace(string(output)), nil
 }
 
-// SetUserEmail sets the Git user.email configuration.
-// If global is true, sets the global configuration; otherwise sets local repository configuration.
-// Returns an error if not in a Git repository (when global is false) or if the git command fails.
-func SetUserEmail(email string, global bool) error {
-	if !global && !CheckGitRepo() {
-		return errors.New("not a git repository")
+// SetUserName sets the Git user.name configuration in the given scope.
+// Returns an error if the scope needs a Git repository and there is none, or if the git command fails.
+func (r Repo) SetUserName(name string, scope Scope) error {
+	return r.setConfigValue("user.name", name, scope)
+}
+
+// UnsetUserName removes the Git user.name configuration from the given scope.
+// Returns an error if the scope needs a Git repository and there is none, if no username is set, or if the git command fails.
+func (r Repo) UnsetUserName(scope Scope) error {
+	if _, err := r.GetUserName(scope); err != nil {
+		var notSetErr *custom_errors.NotSetError
+		if errors.As(err, &notSetErr) {
+			return fmt.Errorf("no %s username to unset", scope)
+		}
+		return err
+	}
+	return r.unsetConfigValue("user.name", scope)
+}
+
+// GetUserName retrieves the Git user.name configuration of the given scope.
+// Returns the username string or an error if the scope needs a Git repository and there is none.
+// Returns a custom NotSetError if the username is not configured in the scope.
+func (r Repo) GetUserName(scope Scope) (string, error) {
+	return r.getConfigValue("user.name", "username", scope)
+}
+
+// SetUserEmail sets the Git user.email configuration in the given scope.
+// Returns an error if the scope needs a Git repository and there is none, or if the git command fails.
+func (r Repo) SetUserEmail(email string, scope Scope) error {
+	return r.setConfigValue("user.email", email, scope)
+}
+
+// GetUserEmail retrieves the Git user.email configuration of the given scope.
+// Returns the email string or an error if the scope needs a Git repository and there is none.
+// Returns a custom NotSetError if the email is not configured in the scope.
+func (r Repo) GetUserEmail(scope Scope) (string, error) {
+	return r.getConfigValue("user.email", "email", scope)
+}
+
+// UnsetUserEmail removes the Git user.email configuration from the given scope.
+// Returns an error if the scope needs a Git repository and there is none, if no email is set, or if the git command fails.
+func (r Repo) UnsetUserEmail(scope Scope) error {
+	if _, err := r.GetUserEmail(scope); err != nil {
+		var notSetErr *custom_errors.NotSetError
+		if errors.As(err, &notSetErr) {
+			return fmt.Errorf("no %s email to unset", scope)
+		}
+		return err
 	}
+	return r.unsetConfigValue("user.email", scope)
+}
 
-	args := []string{"config", "user.email", email}
-	if global {
-		args = []string{"config", "--global", "user.email", email}
+// setConfigValue sets an arbitrary git configuration key in the given scope.
+// Writing to the worktree scope enables extensions.worktreeConfig first.
+func (r Repo) setConfigValue(key string, value string, scope Scope) error {
+	if err := r.checkScope(scope); err != nil {
+		return err
+	}
+
+	if scope == ScopeWorktree {
+		if err := r.EnableWorktreeConfig(); err != nil {
+			return err
+		}
 	}
 
-	cmd := exec.Command("git", args...)
-	cmd.Stdout = os.Stdout
+	old, err := r.currentValue(key, scope)
+	if err != nil {
+		return err
+	}
+
+	cmd := r.command("config", scope.flag(), key, value)
+	cmd.Stdout = os.Stderr
 	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+
+	r.record(key, scope, old, &value)
+	return nil
 }
 
-// GetUserEmail retrieves the local Git user.email configuration.
-// Returns the email string or an error if not in a Git repository or if the email is not set.
-// Returns a custom NotSetError if the email is not configured locally.
-func GetUserEmail() (string, error) {
-	if !CheckGitRepo() {
-		return "", errors.New("not a git repository")
+// currentValue returns the value of key in the given scope, nil if it isn't set.
+func (r Repo) currentValue(key string, scope Scope) (*string, error) {
+	value, err := r.getConfigValue(key, key, scope)
+	if err != nil {
+		var notSetErr *custom_errors.NotSetError
+		if errors.As(err, &notSetErr) {
+			return nil, nil
+		}
+		return nil, err
 	}
-	cmd := exec.Command("git", "config", "--get", "--local", "user.email")
-	output, err := cmd.CombinedOutput()
+	return &value, nil
+}
 
-	if err != nil {
-		var exitError *exec.ExitError
+// record adds a change of key in the given scope to the recorder of the handle, if it has one.
+// Changes to the local and worktree scopes are recorded with the work tree they were made in.
+func (r Repo) record(key string, scope Scope, old *string, updated *string) {
+	if r.recorder == nil {
+		return
+	}
 
-		ok := errors.As(err, &exitError)
-		if ok && exitError.ExitCode() == 1 && err.Error() == "exit status 1" {
-			return "", &custom_errors.NotSetError{ConfigName: "email"}
-		} else {
-			return "", err
+	change := Change{Kind: ChangeGit, Scope: scope.String(), Key: key, Old: old, New: updated}
+	if scope.NeedsRepo() {
+		change.Repo = r.dir
+		if root, err := r.GetRepoRoot(); err == nil {
+			change.Repo = filepath.Clean(root)
+		} else if change.Repo == "" {
+			change.Repo, _ = os.Getwd()
 		}
 	}
-	return strings.TrimSpace(string(output)), nil
+	r.recorder.record(change)
 }
 
-// GetGlobalUserName retrieves the global Git user.name configuration.
-// Returns the username string or an error if the username is not set globally.
-// Returns a custom NotSetError if the username is not configured globally.
-func GetGlobalUserName() (string, error) {
-	cmd := exec.Command("git", "config", "--get", "--global", "user.name")
+// getConfigValue retrieves an arbitrary git configuration key from the given scope.
+// Returns a custom NotSetError carrying configName if the key is not configured.
+func (r Repo) getConfigValue(key string, configName string, scope Scope) (string, error) {
+	if err := r.checkScope(scope); err != nil {
+		return "", err
+	}
+
+	notSetErr := &custom_errors.NotSetError{ConfigName: configName, Scope: scope.String()}
+
+	// without the extension, git would read the local config for --worktree
+	if scope == ScopeWorktree && !r.WorktreeConfigEnabled() {
+		return "", notSetErr
+	}
+
+	cmd := r.command("config", "--get", scope.flag(), key)
 	output, err := cmd.CombinedOutput()
 
 	if err != nil {
@@ -195,7 +341,7 @@ func GetGlobalUserName() (string, error) {
 
 		ok := errors.As(err, &exitError)
 		if ok && exitError.ExitCode() == 1 && err.Error() == "exit status 1" {
-			return "", &custom_errors.NotSetError{ConfigName: "username", Global: true}
+			return "", notSetErr
 		} else {
 			return "", err
 		}
@@ -203,56 +349,265 @@ func GetGlobalUserName() (string, error) {
 	return strings.TrimSpace(string(output)), nil
 }
 
-// GetGlobalUserEmail retrieves the global Git user.email configuration.
-// Returns the email string or an error if the email is not set globally.
-// Returns a custom NotSetError if the email is not configured globally.
-func GetGlobalUserEmail() (string, error) {
-	cmd := exec.Command("git", "config", "--get", "--global", "user.email")
-	output, err := cmd.CombinedOutput()
+// unsetConfigValue removes an arbitrary git configuration key from the given scope.
+// Keys that are not set are skipped silently.
+func (r Repo) unsetConfigValue(key string, scope Scope) error {
+	if err := r.checkScope(scope); err != nil {
+		return err
+	}
 
+	if scope == ScopeWorktree && !r.WorktreeConfigEnabled() {
+		return nil
+	}
+
+	old, err := r.currentValue(key, scope)
+	if err != nil {
+		return err
+	}
+
+	cmd := r.command("config", scope.flag(), "--unset", key)
+	_, err = cmd.Output()
 	if err != nil {
 		var exitError *exec.ExitError
 
-		ok := errors.As(err, &exitError)
-		if ok && exitError.ExitCode() == 1 && err.Error() == "exit status 1" {
-			return "", &custom_errors.NotSetError{ConfigName: "email", Global: true}
-		} else {
-			return "", err
+		// git exits with 5 when the key does not exist
+		if errors.As(err, &exitError) && exitError.ExitCode() == 5 {
+			return nil
 		}
+		return err
 	}
-	return strings.TrimSpace(string(output)), nil
+
+	r.record(key, scope, old, nil)
+	return nil
 }
 
-// UnsetUserEmail removes the Git user.email configuration.
-// If global is true, unsets the global configuration; otherwise unsets local repository configuration.
-// Returns an error if not in a Git repository (when global is false), if no email is set, or if the git command fails.
-func UnsetUserEmail(global bool) error {
-	if !global && !CheckGitRepo() {
-		return errors.New("not a git repository")
+// SetSigningConfig applies the commit signing settings of a profile to the given scope.
+// Writes user.signingkey, gpg.format, commit.gpgsign and tag.gpgsign.
+// If the profile carries no signing key, any existing signing settings in the scope are removed
+// so that a previously set key isn't used with the new identity.
+func (r Repo) SetSigningConfig(profile models.ProfileConfig, scope Scope) error {
+	if profile.SigningKey == "" {
+		return r.UnsetSigningConfig(scope)
 	}
 
-	var args []string
+	if err := r.setConfigValue("user.signingkey", profile.SigningKey, scope); err != nil {
+		return err
+	}
 
-	if !global {
-		_, err := GetUserEmail()
-		if err != nil {
-			return errors.New("no local email to unset")
+	if profile.SigningFormat != "" {
+		if err := r.setConfigValue("gpg.format", profile.SigningFormat, scope); err != nil {
+			return err
 		}
+	} else if err := r.unsetConfigValue("gpg.format", scope); err != nil {
+		return err
+	}
 
-		args = []string{"config", "--unset", "user.email"}
-	} else {
-		_, err := GetGlobalUserEmail()
-		if err != nil {
-			return errors.New("no global email to unset")
+	if err := r.setConfigValue("commit.gpgsign", strconv.FormatBool(profile.SignCommits), scope); err != nil {
+		return err
+	}
+
+	return r.setConfigValue("tag.gpgsign", strconv.FormatBool(profile.SignTags), scope)
+}
+
+// UnsetSigningConfig removes user.signingkey, gpg.format, commit.gpgsign and tag.gpgsign from the given scope.
+func (r Repo) UnsetSigningConfig(scope Scope) error {
+	for _, key := range []string{"user.signingkey", "gpg.format", "commit.gpgsign", "tag.gpgsign"} {
+		if err := r.unsetConfigValue(key, scope); err != nil {
+			return err
 		}
+	}
+	return nil
+}
+
+// GetSigningKey retrieves the user.signingkey configuration.
+// Returns a custom NotSetError if no signing key is configured in the requested scope.
+func (r Repo) GetSigningKey(scope Scope) (string, error) {
+	return r.getConfigValue("user.signingkey", "signing key", scope)
+}
+
+// GetSigningFormat retrieves the gpg.format configuration.
+// Returns a custom NotSetError if no signing format is configured in the requested scope.
+func (r Repo) GetSigningFormat(scope Scope) (string, error) {
+	return r.getConfigValue("gpg.format", "signing format", scope)
+}
+
+// GetCommitSigning retrieves the commit.gpgsign configuration.
+// Returns a custom NotSetError if commit signing is not configured in the requested scope.
+func (r Repo) GetCommitSigning(scope Scope) (string, error) {
+	return r.getConfigValue("commit.gpgsign", "commit signing", scope)
+}
+
+// GetTagSigning retrieves the tag.gpgsign configuration.
+// Returns a custom NotSetError if tag signing is not configured in the requested scope.
+func (r Repo) GetTagSigning(scope Scope) (string, error) {
+	return r.getConfigValue("tag.gpgsign", "tag signing", scope)
+}
+
+// sshCommandSuffix marks a core.sshCommand as written by git-profile.
+const sshCommandSuffix = "-o IdentitiesOnly=yes"
+
+// BuildSSHCommand builds the core.sshCommand value that makes git use the given identity file.
+// A leading ~ is expanded to the home directory, since git doesn't do this for core.sshCommand reliably.
+func BuildSSHCommand(keyPath string) string {
+	keyPath = ExpandHome(keyPath)
+
+	// git runs the command through the shell, so anything but plain path characters is quoted
+	if strings.IndexFunc(keyPath, func(r rune) bool { return !isPlainPathRune(r) }) >= 0 {
+		keyPath = "'" + strings.ReplaceAll(keyPath, "'", `'\''`) + "'"
+	}
+
+	return fmt.Sprintf("ssh -i %s %s", keyPath, sshCommandSuffix)
+}
+
+// isPlainPathRune reports whether r can appear in a shell word without quoting.
+func isPlainPathRune(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("/._-~:@%+=,", r)
+}
+
+// ParseSSHCommand extracts the identity file from a core.sshCommand written by BuildSSHCommand.
+// Returns false if the command wasn't written by git-profile.
+func ParseSSHCommand(sshCommand string) (string, bool) {
+	if !strings.HasPrefix(sshCommand, "ssh -i ") || !strings.HasSuffix(sshCommand, " "+sshCommandSuffix) {
+		return "", false
+	}
+
+	keyPath := strings.TrimSuffix(strings.TrimPrefix(sshCommand, "ssh -i "), " "+sshCommandSuffix)
+	if strings.HasPrefix(keyPath, "'") && strings.HasSuffix(keyPath, "'") && len(keyPath) > 1 {
+		keyPath = strings.ReplaceAll(keyPath[1:len(keyPath)-1], `'\''`, "'")
+	}
+
+	return keyPath, keyPath != ""
+}
+
+// SetSSHKey makes git use the given SSH identity file by writing core.sshCommand.
+// If keyPath is empty, a core.sshCommand previously written by git-profile is removed.
+func (r Repo) SetSSHKey(keyPath string, scope Scope) error {
+	if keyPath == "" {
+		return r.UnsetSSHKey(scope)
+	}
+	return r.setConfigValue("core.sshCommand", BuildSSHCommand(keyPath), scope)
+}
+
+// GetSSHCommand retrieves the core.sshCommand configuration.
+// Returns a custom NotSetError if no SSH command is configured in the requested scope.
+func (r Repo) GetSSHCommand(scope Scope) (string, error) {
+	return r.getConfigValue("core.sshCommand", "ssh command", scope)
+}
 
-		args = []string{"config", "--global", "--unset", "user.email"}
+// GetSSHKey retrieves the SSH identity file configured through core.sshCommand.
+// Returns a custom NotSetError if no SSH command is configured in the requested scope,
+// and an error if the configured command wasn't written by git-profile.
+func (r Repo) GetSSHKey(scope Scope) (string, error) {
+	sshCommand, err := r.GetSSHCommand(scope)
+	if err != nil {
+		return "", err
 	}
 
-	cmd := exec.Command("git", args...)
-	_, err := cmd.Output()
+	keyPath, ok := ParseSSHCommand(sshCommand)
+	if !ok {
+		return "", fmt.Errorf("custom ssh command in use: %s", sshCommand)
+	}
+	return keyPath, nil
+}
+
+// UnsetSSHKey removes core.sshCommand if it was written by git-profile.
+// Custom SSH commands configured by the user are left untouched.
+func (r Repo) UnsetSSHKey(scope Scope) error {
+	sshCommand, err := r.GetSSHCommand(scope)
 	if err != nil {
+		var notSetErr *custom_errors.NotSetError
+		if errors.As(err, &notSetErr) {
+			return nil
+		}
 		return err
 	}
+
+	if _, ok := ParseSSHCommand(sshCommand); !ok {
+		return nil
+	}
+	return r.unsetConfigValue("core.sshCommand", scope)
+}
+
+// GetRepoRoot retrieves the top-level directory of the working tree of repo.
+// Returns an error if not in a Git repository.
+func (r Repo) GetRepoRoot() (string, error) {
+	if !r.CheckGitRepo() {
+		return "", errors.New("not a git repository")
+	}
+
+	cmd := r.command("rev-parse", "--show-toplevel")
+	output, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// ApplyProfile writes the attributes of a profile to the given scope.
+// This covers name, email, the commit signing settings and the SSH key.
+func (r Repo) ApplyProfile(profile models.ProfileConfig, scope Scope) error {
+	if err := r.SetUserName(profile.Name, scope); err != nil {
+		return fmt.Errorf("failed to set user name: %v", err)
+	}
+
+	if err := r.SetUserEmail(profile.Email, scope); err != nil {
+		return fmt.Errorf("failed to set user email: %v", err)
+	}
+
+	if err := r.SetSigningConfig(profile, scope); err != nil {
+		return fmt.Errorf("failed to set signing configuration: %v", err)
+	}
+
+	if err := r.SetSSHKey(profile.SSHKey, scope); err != nil {
+		return fmt.Errorf("failed to set ssh key: %v", err)
+	}
+
 	return nil
 }
+
+// ProfileApplied reports whether the given scope already has the name, email, signing settings and SSH key of the profile.
+func (r Repo) ProfileApplied(profile models.ProfileConfig, scope Scope) bool {
+	config, err := readConfigAt(r.dir, scope.flag())
+	if err != nil {
+		return false
+	}
+	return profileConfigApplied(config, profile)
+}
+
+// profileConfigApplied reports whether the git config of a single scope, keyed in lower case as read by readConfigAt,
+// already carries everything ApplyProfile writes for the profile.
+func profileConfigApplied(config map[string]string, profile models.ProfileConfig) bool {
+	if config["user.name"] != profile.Name || config["user.email"] != profile.Email || !signingConfigApplied(config, profile) {
+		return false
+	}
+
+	sshCommand := config["core.sshcommand"]
+	if profile.SSHKey == "" {
+		_, ours := ParseSSHCommand(sshCommand)
+		return !ours
+	}
+	return sshCommand == BuildSSHCommand(profile.SSHKey)
+}
+
+// signingConfigApplied reports whether the git config of a single scope has the signing settings SetSigningConfig
+// writes for the profile: none at all without a signing key.
+func signingConfigApplied(config map[string]string, profile models.ProfileConfig) bool {
+	if profile.SigningKey == "" {
+		for _, key := range []string{"user.signingkey", "gpg.format", "commit.gpgsign", "tag.gpgsign"} {
+			if _, set := config[key]; set {
+				return false
+			}
+		}
+		return true
+	}
+
+	format, formatSet := config["gpg.format"]
+	if format != profile.SigningFormat || formatSet && profile.SigningFormat == "" {
+		return false
+	}
+
+	signCommits, commitsSet := config["commit.gpgsign"]
+	signTags, tagsSet := config["tag.gpgsign"]
+	return config["user.signingkey"] == profile.SigningKey && commitsSet && tagsSet &&
+		parseGitBool(signCommits) == profile.SignCommits && parseGitBool(signTags) == profile.SignTags
+}
@@ -0,0 +1,509 @@
+// Package internal
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shieldine/git-profile/models"
+)
+
+// backupRefPrefix is where the heads of rewritten histories are kept.
+const backupRefPrefix = "refs/git-profile/backup/"
+
+// Signature is an identity together with the raw git timestamp, e.g. "1700000000 +0100".
+type Signature struct {
+	Identity
+	Date string
+}
+
+// RawCommit is a commit object split into the parts git-profile rewrites.
+type RawCommit struct {
+	Hash      string
+	Tree      string
+	Parents   []string
+	Author    Signature
+	Committer Signature
+	Message   string
+	// Signed tells whether the commit carries a gpgsig header, which a rewrite can't keep.
+	Signed bool
+}
+
+// signatureLine matches the author and committer headers of a commit object.
+var signatureLine = regexp.MustCompile(`^(author|committer) (.*) <(.*)> (\d+ [+-]\d{4})$`)
+
+// ParseRawCommit parses the output of `git cat-file commit`.
+func ParseRawCommit(hash string, raw string) (RawCommit, error) {
+	header, message, found := strings.Cut(raw, "\n\n")
+	if !found {
+		header = strings.TrimSuffix(raw, "\n")
+	}
+
+	commit := RawCommit{Hash: hash, Message: message}
+
+	for _, line := range strings.Split(header, "\n") {
+		key, value, _ := strings.Cut(line, " ")
+
+		switch key {
+		case "tree":
+			commit.Tree = value
+		case "parent":
+			commit.Parents = append(commit.Parents, value)
+		case "gpgsig", "gpgsig-sha256":
+			commit.Signed = true
+		case "author", "committer":
+			match := signatureLine.FindStringSubmatch(line)
+			if match == nil {
+				return RawCommit{}, fmt.Errorf("commit %s: malformed %s line", hash, key)
+			}
+
+			signature := Signature{Identity: Identity{Name: match[2], Email: match[3]}, Date: match[4]}
+			if key == "author" {
+				commit.Author = signature
+			} else {
+				commit.Committer = signature
+			}
+		}
+	}
+
+	if commit.Tree == "" {
+		return RawCommit{}, fmt.Errorf("commit %s: no tree", hash)
+	}
+	return commit, nil
+}
+
+// readRawCommit reads and parses a commit of the repository at dir.
+func readRawCommit(dir string, hash string) (RawCommit, error) {
+	output, err := gitCommand(dir, "cat-file", "commit", hash).Output()
+	if err != nil {
+		return RawCommit{}, fmt.Errorf("failed to read commit %s: %v", hash, err)
+	}
+	return ParseRawCommit(hash, string(output))
+}
+
+// writeCommit creates a commit object from a RawCommit in the repository at dir and returns its hash.
+// Tree, parents, identities, dates and message are kept as they are. If signing carries a signing key,
+// the commit is signed with it in the format of signing, not the one configured for the repository;
+// other signatures don't survive a rewrite.
+func writeCommit(dir string, commit RawCommit, signing models.ProfileConfig) (string, error) {
+	var args []string
+	if signing.SigningKey != "" {
+		format := signing.SigningFormat
+		if format == "" {
+			// an empty format stands for git's default, which the repository may override
+			format = "openpgp"
+		}
+		args = append(args, "-c", "gpg.format="+format)
+	}
+
+	args = append(args, "commit-tree", commit.Tree)
+	for _, parent := range commit.Parents {
+		args = append(args, "-p", parent)
+	}
+	if signing.SigningKey != "" {
+		args = append(args, "-S"+signing.SigningKey)
+	} else {
+		args = append(args, "--no-gpg-sign")
+	}
+
+	cmd := gitCommand(dir, args...)
+	cmd.Stdin = strings.NewReader(commit.Message)
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME="+commit.Author.Name,
+		"GIT_AUTHOR_EMAIL="+commit.Author.Email,
+		"GIT_AUTHOR_DATE=@"+commit.Author.Date,
+		"GIT_COMMITTER_NAME="+commit.Committer.Name,
+		"GIT_COMMITTER_EMAIL="+commit.Committer.Email,
+		"GIT_COMMITTER_DATE=@"+commit.Committer.Date,
+	)
+
+	output, err := cmd.Output()
+	if err != nil {
+		var exitError *exec.ExitError
+		if errors.As(err, &exitError) {
+			return "", fmt.Errorf("failed to write commit %s: %s", commit.Hash, strings.TrimSpace(string(exitError.Stderr)))
+		}
+		return "", fmt.Errorf("failed to write commit %s: %v", commit.Hash, err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// IdentityPattern selects identities by name, email or both.
+type IdentityPattern struct {
+	Name  string
+	Email string
+}
+
+// identityPatternForm matches "Name <email>".
+var identityPatternForm = regexp.MustCompile(`^(.*?)\s*<([^<>]*)>$`)
+
+// ParseIdentityPattern parses "Name <email>", a bare email or a bare name.
+func ParseIdentityPattern(pattern string) (IdentityPattern, error) {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" {
+		return IdentityPattern{}, errors.New("empty identity")
+	}
+
+	if match := identityPatternForm.FindStringSubmatch(pattern); match != nil {
+		return IdentityPattern{Name: match[1], Email: match[2]}, nil
+	}
+	if strings.Contains(pattern, "@") {
+		return IdentityPattern{Email: pattern}, nil
+	}
+	return IdentityPattern{Name: pattern}, nil
+}
+
+// Matches reports whether an identity has the name and email of the pattern, where set.
+// Emails are compared case-insensitively.
+func (p IdentityPattern) Matches(identity Identity) bool {
+	if p.Name != "" && p.Name != identity.Name {
+		return false
+	}
+	if p.Email != "" && !strings.EqualFold(p.Email, identity.Email) {
+		return false
+	}
+	return p.Name != "" || p.Email != ""
+}
+
+// HistoryRewrite describes a rewrite of the history leading to HEAD.
+type HistoryRewrite struct {
+	// Range selects the commits that may be rewritten, in git rev-list syntax. It must end at HEAD
+	// and may only hold revisions, see ValidateRevRange.
+	// If empty, all commits not reachable from any remote-tracking branch are selected.
+	Range string
+	// FirstParent only follows the first parent of merges, leaving the commits they merged in alone.
+	FirstParent bool
+	// Rewrite changes a commit in place and reports whether it changed anything.
+	Rewrite func(commit *RawCommit) bool
+	// Signing signs rewritten commits committed by its identity, if it carries a signing key.
+	Signing models.ProfileConfig
+	// Force allows rewriting commits that are reachable from remote-tracking branches,
+	// and dropping the signatures of commits that can't be signed again.
+	Force bool
+	// DryRun only computes which commits would be rewritten.
+	DryRun bool
+}
+
+// HistoryRewriteResult is the outcome of a history rewrite.
+type HistoryRewriteResult struct {
+	// Changed lists the original hashes of the commits whose contents changed.
+	Changed []string `json:"changed" yaml:"changed"`
+	// Rewritten counts all commits that got a new hash, including descendants of changed ones.
+	Rewritten int `json:"rewritten" yaml:"rewritten"`
+	// Unsigned lists the original hashes of the signed commits that lose their signature,
+	// as they are rewritten without being signed again.
+	Unsigned  []string `json:"unsigned,omitempty" yaml:"unsigned,omitempty"`
+	Ref       string   `json:"ref,omitempty" yaml:"ref,omitempty"`
+	OldHead   string   `json:"old_head,omitempty" yaml:"old_head,omitempty"`
+	NewHead   string   `json:"new_head,omitempty" yaml:"new_head,omitempty"`
+	BackupRef string   `json:"backup_ref,omitempty" yaml:"backup_ref,omitempty"`
+}
+
+// RewriteHistory rewrites the commits selected by rewrite.Range in the repository at dir and moves
+// the current branch (or a detached HEAD) to the new history. Before that, the old head is saved
+// under refs/git-profile/backup/. Published commits are only rewritten with rewrite.Force, and so are
+// signed commits that would lose their signature, including descendants only rewritten for their new parent.
+// Trees are left untouched, so the index and working tree stay valid.
+func RewriteHistory(dir string, rewrite HistoryRewrite) (HistoryRewriteResult, error) {
+	result := HistoryRewriteResult{}
+
+	oldHead, err := revParse(dir, "HEAD")
+	if err != nil {
+		return result, errors.New("repository has no commits")
+	}
+	result.OldHead = oldHead
+	result.NewHead = oldHead
+
+	if err := ValidateRevRange(rewrite.Range); err != nil {
+		return result, err
+	}
+
+	rangeArgs := []string{"HEAD", "--not", "--remotes"}
+	if rewrite.Range != "" {
+		rangeArgs = strings.Fields(rewrite.Range)
+	}
+	if rewrite.FirstParent {
+		rangeArgs = append([]string{"--first-parent"}, rangeArgs...)
+	}
+
+	selected, err := revList(dir, append([]string{"--reverse", "--topo-order"}, rangeArgs...)...)
+	if err != nil {
+		return result, err
+	}
+
+	// exclusions go first, as the range may carry --not itself
+	outsideHead, err := revList(dir, append([]string{"^HEAD"}, rangeArgs...)...)
+	if err != nil {
+		return result, err
+	}
+	if len(outsideHead) != 0 {
+		return result, fmt.Errorf("range %s contains commits that are not part of HEAD", rewrite.Range)
+	}
+
+	if len(selected) == 0 {
+		return result, nil
+	}
+	if selected[len(selected)-1] != oldHead {
+		return result, fmt.Errorf("range %s must end at HEAD", rewrite.Range)
+	}
+
+	commits := make([]RawCommit, len(selected))
+	mapping := map[string]string{}
+	rewritten := map[string]bool{}
+
+	// first pass: find out which commits change, without writing anything
+	for i, hash := range selected {
+		commit, err := readRawCommit(dir, hash)
+		if err != nil {
+			return result, err
+		}
+
+		changed := rewrite.Rewrite(&commit)
+		if changed {
+			result.Changed = append(result.Changed, hash)
+		}
+
+		for _, parent := range commit.Parents {
+			if rewritten[parent] {
+				changed = true
+			}
+		}
+		rewritten[hash] = changed
+		commits[i] = commit
+	}
+
+	for _, commit := range commits {
+		if !rewritten[commit.Hash] {
+			continue
+		}
+		result.Rewritten++
+		if commit.Signed && !signsCommit(rewrite.Signing, commit) {
+			result.Unsigned = append(result.Unsigned, commit.Hash)
+		}
+	}
+
+	if result.Rewritten == 0 || rewrite.DryRun {
+		return result, nil
+	}
+
+	if !rewrite.Force && len(result.Unsigned) != 0 {
+		return result, fmt.Errorf("%d of the commits to rewrite are signed and would lose their signature, use --force to rewrite them anyway:\n\t%s",
+			len(result.Unsigned), strings.Join(result.Unsigned, "\n\t"))
+	}
+
+	if !rewrite.Force {
+		published, err := countPublished(dir, rangeArgs, rewritten)
+		if err != nil {
+			return result, err
+		}
+		if published != 0 {
+			return result, fmt.Errorf("%d of the commits to rewrite are already pushed, use --force to rewrite them anyway", published)
+		}
+	}
+
+	// second pass: write the new commits, parents first
+	for _, commit := range commits {
+		if !rewritten[commit.Hash] {
+			continue
+		}
+
+		for i, parent := range commit.Parents {
+			if newParent, ok := mapping[parent]; ok {
+				commit.Parents[i] = newParent
+			}
+		}
+
+		signing := models.ProfileConfig{}
+		if signsCommit(rewrite.Signing, commit) {
+			signing = rewrite.Signing
+		}
+
+		newHash, err := writeCommit(dir, commit, signing)
+		if err != nil {
+			return result, err
+		}
+		mapping[commit.Hash] = newHash
+	}
+
+	result.NewHead = mapping[oldHead]
+
+	result.Ref, err = currentRef(dir)
+	if err != nil {
+		return result, err
+	}
+
+	result.BackupRef = backupRefPrefix + strconv.FormatInt(time.Now().UnixNano(), 10)
+	if err := gitCommand(dir, "update-ref", result.BackupRef, oldHead).Run(); err != nil {
+		return result, fmt.Errorf("failed to create backup ref: %v", err)
+	}
+
+	err = gitCommand(dir, "update-ref", "-m", "git-profile: rewrite history", result.Ref, result.NewHead, oldHead).Run()
+	if err != nil {
+		return result, fmt.Errorf("failed to update %s: %v", result.Ref, err)
+	}
+
+	return result, nil
+}
+
+// signsCommit reports whether a rewritten commit is signed again with the key of signing,
+// which is only done for commits signing committed itself.
+func signsCommit(signing models.ProfileConfig, commit RawCommit) bool {
+	return signing.SignCommits && signing.SigningKey != "" && IdentityMatchesProfile(commit.Committer.Identity, signing)
+}
+
+// countPublished counts the rewritten commits that are reachable from a remote-tracking branch.
+func countPublished(dir string, rangeArgs []string, rewritten map[string]bool) (int, error) {
+	unpublished, err := revList(dir, append([]string{"--not", "--remotes", "--not"}, rangeArgs...)...)
+	if err != nil {
+		return 0, err
+	}
+
+	isUnpublished := map[string]bool{}
+	for _, hash := range unpublished {
+		isUnpublished[hash] = true
+	}
+
+	published := 0
+	for hash, changed := range rewritten {
+		if changed && !isUnpublished[hash] {
+			published++
+		}
+	}
+	return published, nil
+}
+
+// revParse resolves a revision of the repository at dir to a commit hash.
+func revParse(dir string, rev string) (string, error) {
+	output, err := gitCommand(dir, "rev-parse", "--verify", "--quiet", rev+"^{commit}").Output()
+	if err != nil {
+		return "", fmt.Errorf("unknown revision %s", rev)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// revList runs git rev-list in the repository at dir.
+func revList(dir string, args ...string) ([]string, error) {
+	output, err := gitCommand(dir, append([]string{"rev-list"}, args...)...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("invalid range %s", strings.Join(args, " "))
+	}
+	return strings.Fields(string(output)), nil
+}
+
+// currentRef returns the branch HEAD points at, or HEAD itself if it is detached.
+func currentRef(dir string) (string, error) {
+	output, err := gitCommand(dir, "symbolic-ref", "--quiet", "HEAD").Output()
+	if err != nil {
+		return "HEAD", nil
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// ReplaceIdentity returns a rewrite function that gives authors and committers matching from the identity of to.
+func ReplaceIdentity(from IdentityPattern, to models.ProfileConfig) func(commit *RawCommit) bool {
+	target := Identity{Name: to.Name, Email: to.Email}
+
+	return func(commit *RawCommit) bool {
+		changed := false
+
+		if from.Matches(commit.Author.Identity) && !commit.Author.Identity.Equal(target) {
+			commit.Author.Identity = target
+			changed = true
+		}
+		if from.Matches(commit.Committer.Identity) && !commit.Committer.Identity.Equal(target) {
+			commit.Committer.Identity = target
+			changed = true
+		}
+		return changed
+	}
+}
+
+// SetIdentity returns a rewrite function that gives authors and committers the identity of to.
+// If resign is true, commits are reported as changed even if they had the identity already,
+// so that they get signed again.
+func SetIdentity(to models.ProfileConfig, resign bool) func(commit *RawCommit) bool {
+	target := Identity{Name: to.Name, Email: to.Email}
+
+	return func(commit *RawCommit) bool {
+		changed := resign || !commit.Author.Identity.Equal(target) || !commit.Committer.Identity.Equal(target)
+
+		commit.Author.Identity = target
+		commit.Committer.Identity = target
+		return changed
+	}
+}
+
+// LastCommitsRange returns the range of the last count commits of HEAD, counting first parents only.
+// Rewrite it with FirstParent, so that the commits merged in by a merge among them are left alone.
+func LastCommitsRange(dir string, count int) (string, error) {
+	if count < 1 {
+		return "", errors.New("number of commits must be at least 1")
+	}
+
+	output, err := gitCommand(dir, "rev-list", "--first-parent", "--count", "HEAD").Output()
+	if err != nil {
+		return "", errors.New("repository has no commits")
+	}
+
+	total, err := strconv.Atoi(strings.TrimSpace(string(output)))
+	if err != nil {
+		return "", err
+	}
+
+	if count > total {
+		return "", fmt.Errorf("HEAD only has %d commits", total)
+	}
+	if count == total {
+		return "HEAD", nil
+	}
+	return fmt.Sprintf("HEAD~%d..HEAD", count), nil
+}
+
+// GetEffectiveProfile builds a profile from the identity and signing settings in effect for the repository at dir.
+func GetEffectiveProfile(dir string) (models.ProfileConfig, error) {
+	config, err := readConfigAt(dir, "")
+	if err != nil {
+		return models.ProfileConfig{}, err
+	}
+
+	profile := models.ProfileConfig{
+		Name:          config["user.name"],
+		Email:         config["user.email"],
+		SigningKey:    config["user.signingkey"],
+		SigningFormat: config["gpg.format"],
+		SignCommits:   parseGitBool(config["commit.gpgsign"]),
+	}
+
+	if profile.Name == "" || profile.Email == "" {
+		return models.ProfileConfig{}, errors.New("no user.name or user.email set")
+	}
+	return profile, nil
+}
+
+// parseGitBool interprets a boolean config value the way git does.
+func parseGitBool(value string) bool {
+	switch strings.ToLower(value) {
+	case "true", "yes", "on", "1":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,234 @@
+// Package internal
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Shieldine/git-profile/custom_errors"
+)
+
+// hookMarker identifies hooks written by git-profile.
+const hookMarker = "# git-profile managed hook"
+
+// chainedHookSuffix is appended to the name of a hook that was in place before git-profile installed its own.
+const chainedHookSuffix = ".git-profile-chained"
+
+// GetRepoHooksDir returns the hooks directory of repo.
+// core.hooksPath is respected, just like git does.
+func (r Repo) GetRepoHooksDir() (string, error) {
+	if !r.CheckGitRepo() {
+		return "", errors.New("not a git repository")
+	}
+
+	output, err := r.command("rev-parse", "--path-format=absolute", "--git-path", "hooks").Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// GetGlobalHooksDir returns the hooks directory used for all repositories.
+// An existing global core.hooksPath is reused, otherwise the directory next to the config file in configDir is used.
+// The second return value reports whether core.hooksPath still has to be pointed at the directory.
+func GetGlobalHooksDir(configDir string) (string, bool, error) {
+	hooksPath, err := Repo{}.getConfigValue("core.hooksPath", "hooks path", ScopeGlobal)
+	if err == nil {
+		return ExpandHome(hooksPath), false, nil
+	}
+
+	var notSetErr *custom_errors.NotSetError
+	if !errors.As(err, &notSetErr) {
+		return "", false, err
+	}
+	return GetDefaultGlobalHooksDir(configDir), true, nil
+}
+
+// GetDefaultGlobalHooksDir returns the hooks directory git-profile manages itself below configDir.
+func GetDefaultGlobalHooksDir(configDir string) string {
+	return filepath.Join(configDir, "hooks")
+}
+
+// SetGlobalHooksPath points the global core.hooksPath at dir.
+func SetGlobalHooksPath(dir string) error {
+	return Repo{}.setConfigValue("core.hooksPath", filepath.ToSlash(dir), ScopeGlobal)
+}
+
+// UnsetGlobalHooksPath removes the global core.hooksPath if it points at the directory git-profile manages below configDir.
+func UnsetGlobalHooksPath(configDir string) error {
+	hooksPath, err := Repo{}.getConfigValue("core.hooksPath", "hooks path", ScopeGlobal)
+	if err != nil {
+		return nil
+	}
+
+	if filepath.Clean(ExpandHome(hooksPath)) != filepath.Clean(GetDefaultGlobalHooksDir(configDir)) {
+		return nil
+	}
+	return Repo{}.unsetConfigValue("core.hooksPath", ScopeGlobal)
+}
+
+// GetTemplateHooksDir returns the hooks directory of the template git copies into new repositories and clones.
+// An existing global init.templateDir is reused, otherwise a template directory next to the config file in configDir is used.
+// The second return value reports whether init.templateDir still has to be pointed at the template.
+func GetTemplateHooksDir(configDir string) (string, bool, error) {
+	templateDir, err := Repo{}.getConfigValue("init.templateDir", "template directory", ScopeGlobal)
+	if err == nil {
+		return filepath.Join(ExpandHome(templateDir), "hooks"), false, nil
+	}
+
+	var notSetErr *custom_errors.NotSetError
+	if !errors.As(err, &notSetErr) {
+		return "", false, err
+	}
+	return filepath.Join(GetDefaultTemplateDir(configDir), "hooks"), true, nil
+}
+
+// GetDefaultTemplateDir returns the template directory git-profile manages itself below configDir.
+func GetDefaultTemplateDir(configDir string) string {
+	return filepath.Join(configDir, "template")
+}
+
+// SetGlobalTemplateDir points the global init.templateDir at dir.
+func SetGlobalTemplateDir(dir string) error {
+	return Repo{}.setConfigValue("init.templateDir", filepath.ToSlash(dir), ScopeGlobal)
+}
+
+// UnsetGlobalTemplateDir removes the global init.templateDir if it points at the template git-profile manages below configDir.
+func UnsetGlobalTemplateDir(configDir string) error {
+	templateDir, err := Repo{}.getConfigValue("init.templateDir", "template directory", ScopeGlobal)
+	if err != nil {
+		return nil
+	}
+
+	if filepath.Clean(ExpandHome(templateDir)) != filepath.Clean(GetDefaultTemplateDir(configDir)) {
+		return nil
+	}
+	return Repo{}.unsetConfigValue("init.templateDir", ScopeGlobal)
+}
+
+// IsNullRevision reports whether rev is git's all-zero object name,
+// which post-checkout receives as previous HEAD on the first checkout of a clone.
+func IsNullRevision(rev string) bool {
+	return rev != "" && strings.Trim(rev, "0") == ""
+}
+
+// shellQuote quotes a string for use in a POSIX shell script.
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// GetExecutableCommand returns the command hooks use to call git-profile with the config file at configPath.
+// The absolute path is preferred, so hooks also work where git-profile isn't on PATH.
+// The config file is passed along, as git runs hooks without --config or the environment it was found through.
+func GetExecutableCommand(configPath string) string {
+	command := "git-profile"
+	if exePath, err := os.Executable(); err == nil {
+		command = shellQuote(filepath.ToSlash(exePath))
+	}
+	if configPath == "" {
+		return command
+	}
+	return command + " --config " + shellQuote(filepath.ToSlash(configPath))
+}
+
+// RenderHook renders a hook script that runs command and then any chained hooks.
+// The hook that was in place before is run next. If chainRepoHook is true,
+// the repository's own hook is run as well, since a global core.hooksPath hides it from git.
+func RenderHook(name string, command string, chainRepoHook bool) string {
+	var builder strings.Builder
+
+	builder.WriteString("#!/bin/sh\n")
+	builder.WriteString(hookMarker + "\n")
+	builder.WriteString(command + " || exit $?\n")
+	builder.WriteString("\n")
+	builder.WriteString(fmt.Sprintf("chained=\"$(dirname \"$0\")/%s%s\"\n", name, chainedHookSuffix))
+	builder.WriteString("if [ -x \"$chained\" ]; then\n")
+	builder.WriteString("\t\"$chained\" \"$@\" || exit $?\n")
+	builder.WriteString("fi\n")
+
+	if chainRepoHook {
+		builder.WriteString("\n")
+		builder.WriteString(fmt.Sprintf("repo_hook=\"$(git rev-parse --git-common-dir)/hooks/%s\"\n", name))
+		builder.WriteString("if [ -x \"$repo_hook\" ] && [ \"$repo_hook\" != \"$0\" ]; then\n")
+		builder.WriteString("\t\"$repo_hook\" \"$@\" || exit $?\n")
+		builder.WriteString("fi\n")
+	}
+
+	builder.WriteString("exit 0\n")
+	return builder.String()
+}
+
+// IsManagedHook reports whether the hook file at path was written by git-profile.
+func IsManagedHook(path string) bool {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	return strings.Contains(string(content), hookMarker)
+}
+
+// InstallHook writes a hook into hooksDir. An existing hook that wasn't written by git-profile
+// is kept under a new name and chained, instead of being overwritten.
+func InstallHook(hooksDir string, name string, content string) error {
+	if err := os.MkdirAll(hooksDir, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create hooks directory: %v", err)
+	}
+
+	hookPath := filepath.Join(hooksDir, name)
+	chainedPath := hookPath + chainedHookSuffix
+
+	if _, err := os.Stat(hookPath); err == nil && !IsManagedHook(hookPath) {
+		if _, err := os.Stat(chainedPath); err == nil {
+			return fmt.Errorf("both %s and %s exist, refusing to overwrite either", hookPath, chainedPath)
+		}
+		if err := os.Rename(hookPath, chainedPath); err != nil {
+			return fmt.Errorf("failed to keep existing hook: %v", err)
+		}
+	}
+
+	if err := writeFileAtomic(hookPath, []byte(content), 0755); err != nil {
+		return fmt.Errorf("failed to write hook: %v", err)
+	}
+	return os.Chmod(hookPath, 0755)
+}
+
+// UninstallHook removes a hook written by git-profile from hooksDir and puts a chained hook back in place.
+// Hooks not written by git-profile are left untouched.
+func UninstallHook(hooksDir string, name string) error {
+	hookPath := filepath.Join(hooksDir, name)
+	chainedPath := hookPath + chainedHookSuffix
+
+	if _, err := os.Stat(hookPath); os.IsNotExist(err) {
+		return fmt.Errorf("no %s hook installed", name)
+	}
+	if !IsManagedHook(hookPath) {
+		return fmt.Errorf("%s hook wasn't installed by git-profile", name)
+	}
+
+	if err := os.Remove(hookPath); err != nil {
+		return fmt.Errorf("failed to remove hook: %v", err)
+	}
+
+	if _, err := os.Stat(chainedPath); err == nil {
+		if err := os.Rename(chainedPath, hookPath); err != nil {
+			return fmt.Errorf("failed to restore previous hook: %v", err)
+		}
+	}
+	return nil
+}
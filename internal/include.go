@@ -0,0 +1,504 @@
+// Package internal
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package internal
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/Shieldine/git-profile/models"
+)
+
+const (
+	includeFileHeader = "# Generated by git-profile for profile "
+	includeBlockBegin = "# BEGIN git-profile managed block - changes will be overwritten by git-profile include sync"
+	includeBlockEnd   = "# END git-profile managed block"
+)
+
+// IncludeRule is a single [includeIf] block pointing at the include file of a profile.
+type IncludeRule struct {
+	Condition   string `json:"condition" yaml:"condition"`
+	ProfileName string `json:"profile_name" yaml:"profile_name"`
+	Path        string `json:"path" yaml:"path"`
+}
+
+// GetIncludeDir returns the directory the generated per-profile include files are stored in,
+// next to the config file in configDir.
+func GetIncludeDir(configDir string) string {
+	return filepath.Join(configDir, "includes")
+}
+
+// GetGlobalGitConfigPath returns the path of the global git configuration file.
+// GIT_CONFIG_GLOBAL is respected the same way git does.
+func GetGlobalGitConfigPath() (string, error) {
+	if path := os.Getenv("GIT_CONFIG_GLOBAL"); path != "" {
+		return path, nil
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine home directory: %v", err)
+	}
+	return filepath.Join(homeDir, ".gitconfig"), nil
+}
+
+// originConditions returns the includeIf conditions matching remotes covered by an origin pattern.
+// Covers HTTPS, scp-like SSH and ssh:// remote URLs. Returns false for regular expressions,
+// which git's includeIf conditions can't express.
+func originConditions(origin string) ([]string, bool) {
+	if strings.HasPrefix(origin, originRegexPrefix) {
+		return nil, false
+	}
+
+	host, path, hasPath := strings.Cut(strings.Trim(origin, "/"), "/")
+
+	var paths []string
+	switch {
+	case !hasPath:
+		paths = []string{"*/**"}
+	case strings.HasSuffix(path, "*"):
+		paths = []string{path}
+	default:
+		paths = []string{path, path + ".git"}
+	}
+
+	var conditions []string
+	for _, path := range paths {
+		conditions = append(conditions,
+			fmt.Sprintf("hasconfig:remote.*.url:https://%s/%s", host, path),
+			fmt.Sprintf("hasconfig:remote.*.url:http://%s/%s", host, path),
+			fmt.Sprintf("hasconfig:remote.*.url:*@%s:%s", host, path),
+			fmt.Sprintf("hasconfig:remote.*.url:ssh://*@%s/%s", host, path),
+		)
+	}
+	return conditions, true
+}
+
+// pathCondition returns the includeIf condition matching repositories covered by a path rule.
+// gitdir conditions match the .git directory, so patterns for a single working tree get it appended.
+func pathCondition(path string) string {
+	path = filepath.ToSlash(path)
+	if !strings.HasSuffix(path, "/") && !strings.HasSuffix(path, "**") {
+		path += "/.git"
+	}
+	return "gitdir:" + path
+}
+
+// includeCandidate is a profile rule translated into includeIf conditions.
+type includeCandidate struct {
+	rule        models.Rule
+	path        bool
+	specificity int
+	conditions  []string
+	profiles    []models.ProfileConfig
+}
+
+// BuildIncludeRules turns the rules of profiles into includeIf rules.
+// The rules are ordered by priority and specificity, since git lets later includes win.
+// Rules git can't express and conditions shared by several profiles at the same priority
+// are returned as skipped descriptions instead.
+func BuildIncludeRules(profiles []models.ProfileConfig, includeDir string) ([]IncludeRule, []string) {
+	candidates := map[string]*includeCandidate{}
+	var keys []string
+	var skipped []string
+
+	for _, profile := range profiles {
+		if err := ValidateProfileName(profile.ProfileName); err != nil {
+			skipped = append(skipped, fmt.Sprintf("profile %q (%v)", profile.ProfileName, err))
+			continue
+		}
+
+		for _, rule := range GetProfileRules(profile) {
+			var candidate includeCandidate
+
+			switch {
+			case rule.Path != "" && rule.Origin != "":
+				skipped = append(skipped, fmt.Sprintf("rule of profile %s (path and origin can't be combined in includeIf)", profile.ProfileName))
+				continue
+			case rule.Path != "":
+				candidate = includeCandidate{rule: rule, path: true, conditions: []string{pathCondition(rule.Path)}}
+			case rule.Origin != "":
+				conditions, ok := originConditions(rule.Origin)
+				if !ok {
+					skipped = append(skipped, fmt.Sprintf("origin %s of profile %s (regular expressions can't be expressed in includeIf)", rule.Origin, profile.ProfileName))
+					continue
+				}
+				candidate = includeCandidate{rule: rule, conditions: conditions, specificity: OriginSpecificity(rule.Origin)}
+			default:
+				continue
+			}
+
+			key := fmt.Sprintf("%d|%s", rule.Priority, candidate.conditions[0])
+			if existing, ok := candidates[key]; ok {
+				existing.profiles = append(existing.profiles, profile)
+				continue
+			}
+
+			candidate.profiles = []models.ProfileConfig{profile}
+			candidates[key] = &candidate
+			keys = append(keys, key)
+		}
+	}
+
+	sort.SliceStable(keys, func(i, j int) bool {
+		a, b := candidates[keys[i]], candidates[keys[j]]
+		if a.rule.Priority != b.rule.Priority {
+			return a.rule.Priority < b.rule.Priority
+		}
+		if a.path != b.path {
+			return !a.path
+		}
+		if a.specificity != b.specificity {
+			return a.specificity < b.specificity
+		}
+		return a.conditions[0] < b.conditions[0]
+	})
+
+	var rules []IncludeRule
+
+	for _, key := range keys {
+		candidate := candidates[key]
+
+		if len(candidate.profiles) > 1 {
+			condition := "origin " + candidate.rule.Origin
+			if candidate.rule.Path != "" {
+				condition = "path " + candidate.rule.Path
+			}
+			skipped = append(skipped, fmt.Sprintf("%s (shared by %d profiles)", condition, len(candidate.profiles)))
+			continue
+		}
+
+		for _, condition := range candidate.conditions {
+			rules = append(rules, IncludeRule{
+				Condition:   condition,
+				ProfileName: candidate.profiles[0].ProfileName,
+				Path:        filepath.Join(includeDir, candidate.profiles[0].ProfileName+".gitconfig"),
+			})
+		}
+	}
+
+	return rules, skipped
+}
+
+// quoteConfigValue quotes a value for use in a git configuration file.
+// Newlines and tabs are escaped, other control characters can't be written safely and are rejected.
+func quoteConfigValue(value string) (string, error) {
+	if index := strings.IndexFunc(value, func(r rune) bool { return unicode.IsControl(r) && r != '\n' && r != '\t' }); index >= 0 {
+		return "", fmt.Errorf("value %q contains a control character", value)
+	}
+
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	value = strings.ReplaceAll(value, "\n", `\n`)
+	value = strings.ReplaceAll(value, "\t", `\t`)
+	return `"` + value + `"`, nil
+}
+
+// RenderIncludeFile renders the git configuration applied by a profile's include file.
+func RenderIncludeFile(profile models.ProfileConfig) (string, error) {
+	var builder strings.Builder
+	var err error
+
+	setting := func(key string, value string) {
+		if err != nil {
+			return
+		}
+		quoted, quoteErr := quoteConfigValue(value)
+		if quoteErr != nil {
+			err = fmt.Errorf("invalid %s of profile %s: %v", key, profile.ProfileName, quoteErr)
+			return
+		}
+		builder.WriteString(fmt.Sprintf("\t%s = %s\n", key, quoted))
+	}
+
+	builder.WriteString(includeFileHeader + profile.ProfileName + "\n")
+	builder.WriteString("[user]\n")
+	setting("name", profile.Name)
+	setting("email", profile.Email)
+
+	if profile.SigningKey != "" {
+		setting("signingkey", profile.SigningKey)
+		if profile.SigningFormat != "" {
+			builder.WriteString("[gpg]\n")
+			setting("format", profile.SigningFormat)
+		}
+		builder.WriteString("[commit]\n")
+		builder.WriteString(fmt.Sprintf("\tgpgsign = %s\n", strconv.FormatBool(profile.SignCommits)))
+		builder.WriteString("[tag]\n")
+		builder.WriteString(fmt.Sprintf("\tgpgsign = %s\n", strconv.FormatBool(profile.SignTags)))
+	}
+
+	if profile.SSHKey != "" {
+		builder.WriteString("[core]\n")
+		setting("sshCommand", BuildSSHCommand(profile.SSHKey))
+	}
+
+	if err != nil {
+		return "", err
+	}
+	return builder.String(), nil
+}
+
+// RenderIncludeBlock renders the managed block of includeIf sections for the given rules.
+// Returns an empty string if there are no rules.
+func RenderIncludeBlock(rules []IncludeRule) (string, error) {
+	if len(rules) == 0 {
+		return "", nil
+	}
+
+	var builder strings.Builder
+
+	builder.WriteString(includeBlockBegin + "\n")
+	for _, rule := range rules {
+		condition, err := quoteConfigValue(rule.Condition)
+		if err != nil {
+			return "", fmt.Errorf("invalid include condition of profile %s: %v", rule.ProfileName, err)
+		}
+		path, err := quoteConfigValue(filepath.ToSlash(rule.Path))
+		if err != nil {
+			return "", fmt.Errorf("invalid include path of profile %s: %v", rule.ProfileName, err)
+		}
+
+		builder.WriteString(fmt.Sprintf("[includeIf %s]\n", condition))
+		builder.WriteString(fmt.Sprintf("\tpath = %s\n", path))
+	}
+	builder.WriteString(includeBlockEnd + "\n")
+
+	return builder.String(), nil
+}
+
+// ReplaceManagedBlock removes all git-profile managed blocks from a git configuration
+// and appends block in their place. Everything outside the managed blocks is kept as is.
+func ReplaceManagedBlock(content string, block string) string {
+	var kept []string
+	inBlock := false
+	found := false
+
+	for _, line := range strings.SplitAfter(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(trimmed, "# BEGIN git-profile managed block"):
+			inBlock = true
+			found = true
+		case inBlock && trimmed == includeBlockEnd:
+			inBlock = false
+		case !inBlock:
+			kept = append(kept, line)
+		}
+	}
+
+	if !found && block == "" {
+		return content
+	}
+
+	result := strings.TrimRight(strings.Join(kept, ""), "\n")
+	if result != "" {
+		result += "\n"
+	}
+
+	if block != "" {
+		if result != "" {
+			result += "\n"
+		}
+		result += block
+	}
+
+	return result
+}
+
+// writeFileAtomic replaces the file at path by writing to a temporary file and renaming it.
+// The data is flushed to disk before the rename, so a crash leaves either the old or the new content.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	tempFile, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tempPath := tempFile.Name()
+
+	if _, err := tempFile.Write(data); err != nil {
+		_ = tempFile.Close()
+		_ = os.Remove(tempPath)
+		return err
+	}
+	if err := tempFile.Sync(); err != nil {
+		_ = tempFile.Close()
+		_ = os.Remove(tempPath)
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		_ = os.Remove(tempPath)
+		return err
+	}
+	if err := os.Chmod(tempPath, perm); err != nil {
+		_ = os.Remove(tempPath)
+		return err
+	}
+
+	if err := os.Rename(tempPath, path); err != nil {
+		_ = os.Remove(tempPath)
+		return err
+	}
+
+	syncDir(filepath.Dir(path))
+	return nil
+}
+
+// SyncIncludes writes an include file per profile into the include directory below configDir and rewrites
+// the managed includeIf block in the git configuration at gitConfigPath.
+// Returns the rules written and descriptions of the profile rules that had to be skipped.
+// Nothing is written if a profile name isn't a valid file name or a value can't be written to git's configuration.
+func SyncIncludes(gitConfigPath string, configDir string, profiles []models.ProfileConfig) ([]IncludeRule, []string, error) {
+	includeDir := GetIncludeDir(configDir)
+
+	includeFiles := make(map[string]string, len(profiles))
+	for _, profile := range profiles {
+		if err := ValidateProfileName(profile.ProfileName); err != nil {
+			return nil, nil, err
+		}
+		content, err := RenderIncludeFile(profile)
+		if err != nil {
+			return nil, nil, err
+		}
+		includePath := filepath.Join(includeDir, profile.ProfileName+".gitconfig")
+		generated, err := isGeneratedInclude(includePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !generated {
+			return nil, nil, fmt.Errorf("%s wasn't generated by git-profile, move it away to sync profile %s", includePath, profile.ProfileName)
+		}
+		includeFiles[includePath] = content
+	}
+
+	rules, skipped := BuildIncludeRules(profiles, includeDir)
+	block, err := RenderIncludeBlock(rules)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := removeIncludeFiles(includeDir); err != nil {
+		return nil, nil, err
+	}
+	if err := os.MkdirAll(includeDir, os.ModePerm); err != nil {
+		return nil, nil, fmt.Errorf("failed to create include directory: %v", err)
+	}
+
+	for _, profile := range profiles {
+		includePath := filepath.Join(includeDir, profile.ProfileName+".gitconfig")
+		if err := os.WriteFile(includePath, []byte(includeFiles[includePath]), 0644); err != nil {
+			return nil, nil, fmt.Errorf("failed to write include file for profile %s: %v", profile.ProfileName, err)
+		}
+	}
+
+	if err := rewriteManagedBlock(gitConfigPath, block); err != nil {
+		return nil, nil, err
+	}
+
+	return rules, skipped, nil
+}
+
+// RemoveIncludes removes the managed includeIf block from the git configuration at gitConfigPath
+// and deletes the generated include files below configDir.
+func RemoveIncludes(gitConfigPath string, configDir string) error {
+	if err := rewriteManagedBlock(gitConfigPath, ""); err != nil {
+		return err
+	}
+
+	includeDir := GetIncludeDir(configDir)
+	if err := removeIncludeFiles(includeDir); err != nil {
+		return err
+	}
+	// the directory is left alone if the user keeps anything else in it
+	_ = os.Remove(includeDir)
+	return nil
+}
+
+// removeIncludeFiles deletes the include files git-profile generated in includeDir, recognized by their header.
+// Other files in the directory are kept.
+func removeIncludeFiles(includeDir string) error {
+	paths, err := filepath.Glob(filepath.Join(includeDir, "*.gitconfig"))
+	if err != nil {
+		return fmt.Errorf("failed to list include files: %v", err)
+	}
+
+	for _, path := range paths {
+		generated, err := isGeneratedInclude(path)
+		if err != nil {
+			return err
+		}
+		if !generated {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove include file: %v", err)
+		}
+	}
+	return nil
+}
+
+// isGeneratedInclude reports whether the file at path was generated by git-profile.
+// A file that doesn't exist counts as generated, as nothing of the user's would be lost by writing it.
+func isGeneratedInclude(path string) (bool, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read include file: %v", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	header, _ := bufio.NewReader(file).ReadString('\n')
+	return strings.HasPrefix(header, includeFileHeader), nil
+}
+
+// rewriteManagedBlock replaces the managed block of the git configuration at gitConfigPath with block.
+func rewriteManagedBlock(gitConfigPath string, block string) error {
+	// follow symlinked dotfiles, so the rename doesn't replace the link itself
+	if resolved, err := filepath.EvalSymlinks(gitConfigPath); err == nil {
+		gitConfigPath = resolved
+	}
+
+	content, err := os.ReadFile(gitConfigPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read git config: %v", err)
+	}
+
+	if len(content) == 0 && block == "" {
+		return nil
+	}
+
+	updated := ReplaceManagedBlock(string(content), block)
+	if updated == string(content) {
+		return nil
+	}
+
+	if err := writeFileAtomic(gitConfigPath, []byte(updated), 0644); err != nil {
+		return fmt.Errorf("failed to write git config: %v", err)
+	}
+	return nil
+}
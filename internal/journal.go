@@ -0,0 +1,461 @@
+// Package internal
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shieldine/git-profile/models"
+)
+
+// Kinds of values a Change can be about.
+const (
+	// ChangeGit is a git config key in a scope.
+	ChangeGit = "git"
+	// ChangeProfile is a profile in the config file, keyed by its name. Values are the profile as JSON.
+	ChangeProfile = "profile"
+	// ChangeSettings are the settings of the config file. Values are the settings as JSON.
+	ChangeSettings = "settings"
+)
+
+// Change is a value changed by an operation. A nil Old or New means the value wasn't set.
+type Change struct {
+	Kind string `json:"kind" yaml:"kind"`
+	// Scope is the git config scope of a git change.
+	Scope string `json:"scope,omitempty" yaml:"scope,omitempty"`
+	// Repo is the work tree of a git change in the local or worktree scope.
+	Repo string `json:"repo,omitempty" yaml:"repo,omitempty"`
+	// File and Layer are the config file of a profile or settings change and the layer it makes up.
+	File  string  `json:"file,omitempty" yaml:"file,omitempty"`
+	Layer string  `json:"layer,omitempty" yaml:"layer,omitempty"`
+	Key   string  `json:"key" yaml:"key"`
+	Old   *string `json:"old" yaml:"old"`
+	New   *string `json:"new" yaml:"new"`
+}
+
+// String describes the change in a single line.
+func (c Change) String() string {
+	describe := func(value *string) string {
+		if value == nil {
+			return "(unset)"
+		}
+		return fmt.Sprintf("%q", *value)
+	}
+
+	switch c.Kind {
+	case ChangeProfile:
+		switch {
+		case c.Old == nil:
+			return "profile " + c.Key + ": added"
+		case c.New == nil:
+			return "profile " + c.Key + ": removed"
+		}
+		return "profile " + c.Key + ": changed"
+	case ChangeSettings:
+		return "settings: changed"
+	}
+
+	where := c.Scope
+	if c.Repo != "" {
+		where += " (" + c.Repo + ")"
+	}
+	return fmt.Sprintf("%s %s: %s -> %s", where, c.Key, describe(c.Old), describe(c.New))
+}
+
+// Operation is an entry of the journal: the changes made by one command.
+type Operation struct {
+	ID      int       `json:"id" yaml:"id"`
+	Time    time.Time `json:"time" yaml:"time"`
+	Command string    `json:"command" yaml:"command"`
+	// Undoes is the ID of the operation this one reverted, zero if it isn't an undo.
+	Undoes  int      `json:"undoes,omitempty" yaml:"undoes,omitempty"`
+	Changes []Change `json:"changes" yaml:"changes"`
+}
+
+// Recorder collects the changes made while a command runs.
+// Attach it to a FileStore with SetRecorder and to a Repo with WithRecorder. A nil Recorder records nothing.
+type Recorder struct {
+	mutex   sync.Mutex
+	changes []Change
+}
+
+// record adds a change, unless old and new are the same.
+func (r *Recorder) record(change Change) {
+	if r == nil || sameValue(change.Old, change.New) {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.changes = append(r.changes, change)
+}
+
+// Changes returns the changes recorded so far.
+func (r *Recorder) Changes() []Change {
+	if r == nil {
+		return nil
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]Change(nil), r.changes...)
+}
+
+// sameValue reports whether two values of a change are equal, treating nil as unset.
+func sameValue(a *string, b *string) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+// Journal is the append-only log of operations, kept as one JSON object per line.
+type Journal struct {
+	path string
+}
+
+// GetJournalPath returns where the journal is kept, next to the config file in configDir.
+func GetJournalPath(configDir string) string {
+	return filepath.Join(configDir, "journal.jsonl")
+}
+
+// NewJournal returns the journal at path. The file is created on the first append.
+func NewJournal(path string) *Journal {
+	return &Journal{path: path}
+}
+
+// Operations returns all operations in the journal, oldest first.
+func (j *Journal) Operations() ([]Operation, error) {
+	file, err := os.Open(j.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Operation{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %v", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	operations := []Operation{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		var operation Operation
+		if err := json.Unmarshal(scanner.Bytes(), &operation); err != nil {
+			return nil, fmt.Errorf("failed to read journal line %d: %v", line, err)
+		}
+		operations = append(operations, operation)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read journal: %v", err)
+	}
+	return operations, nil
+}
+
+// Append adds an operation to the journal and returns it with its ID, one more than the last one.
+func (j *Journal) Append(operation Operation) (Operation, error) {
+	if err := os.MkdirAll(filepath.Dir(j.path), os.ModePerm); err != nil {
+		return Operation{}, fmt.Errorf("failed to create journal directory: %v", err)
+	}
+
+	lock, err := lockFile(j.path+".lock", true)
+	if err != nil {
+		return Operation{}, err
+	}
+	defer lock.Unlock()
+
+	operations, err := j.Operations()
+	if err != nil {
+		return Operation{}, err
+	}
+	operation.ID = 1
+	if len(operations) != 0 {
+		operation.ID = operations[len(operations)-1].ID + 1
+	}
+
+	line, err := json.Marshal(operation)
+	if err != nil {
+		return Operation{}, err
+	}
+
+	file, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return Operation{}, fmt.Errorf("failed to open journal: %v", err)
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		_ = file.Close()
+		return Operation{}, fmt.Errorf("failed to write journal: %v", err)
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return Operation{}, fmt.Errorf("failed to write journal: %v", err)
+	}
+	return operation, file.Close()
+}
+
+// LastUndoable returns the newest operation that hasn't been undone yet. Undos themselves aren't undone,
+// so undoing repeatedly walks back through the journal.
+func (j *Journal) LastUndoable() (Operation, error) {
+	operations, err := j.Operations()
+	if err != nil {
+		return Operation{}, err
+	}
+
+	undone := map[int]bool{}
+	for _, operation := range operations {
+		if operation.Undoes != 0 {
+			undone[operation.Undoes] = true
+		}
+	}
+
+	for i := len(operations) - 1; i >= 0; i-- {
+		if operations[i].Undoes == 0 && !undone[operations[i].ID] {
+			return operations[i], nil
+		}
+	}
+	return Operation{}, errors.New("nothing to undo")
+}
+
+// Undo reverts the changes of an operation: git config values are set back in their scope and repository,
+// and profiles and settings are set back in their config file, configPath for changes that don't name one.
+// Repositories are read with settings, and the reverting changes are recorded with recorder.
+// Unless force is set, nothing is reverted if any value was changed again since, as that change would be lost,
+// or if a config file the operation changed was removed since.
+func Undo(operation Operation, configPath string, settings GitSettings, recorder *Recorder, force bool) error {
+	changes := make([]Change, len(operation.Changes))
+	first := map[string]int{}
+	last := map[string]int{}
+	for i, change := range operation.Changes {
+		if change.Kind != ChangeGit {
+			if change.File == "" {
+				change.File = configPath
+			}
+			if change.Layer == "" {
+				change.Layer = LayerUser
+			}
+		}
+		changes[i] = change
+
+		identity := changeIdentity(change)
+		if _, ok := first[identity]; !ok {
+			first[identity] = i
+		}
+		last[identity] = i
+	}
+
+	stores := map[string]*FileStore{}
+	var files []string
+	var conflicts []string
+	for _, change := range changes {
+		if change.Kind == ChangeGit {
+			continue
+		}
+		if _, ok := stores[change.File]; ok {
+			continue
+		}
+
+		// a config file removed since isn't brought back, unless forced
+		if _, err := os.Stat(change.File); errors.Is(err, os.ErrNotExist) && !force {
+			stores[change.File] = nil
+			conflicts = append(conflicts, fmt.Sprintf("config file %s no longer exists", change.File))
+			continue
+		}
+
+		store, err := newFileStore(change.File, change.Layer)
+		if err != nil {
+			return err
+		}
+		store.SetRecorder(recorder)
+		stores[change.File] = store
+		files = append(files, change.File)
+	}
+
+	if !force {
+		// a key changed several times by the operation only has to still hold the value it was changed to last
+		for i, change := range changes {
+			if last[changeIdentity(change)] != i || (change.Kind != ChangeGit && stores[change.File] == nil) {
+				continue
+			}
+			current, err := currentChangeValue(change, stores[change.File], settings)
+			if err != nil {
+				return err
+			}
+			if !sameValue(current, change.New) {
+				conflicts = append(conflicts, change.String())
+			}
+		}
+		if len(conflicts) != 0 {
+			return fmt.Errorf("changed again since operation %d, use --force to revert anyway:\n\t%s",
+				operation.ID, strings.Join(conflicts, "\n\t"))
+		}
+	}
+
+	storeChanges := map[string][]Change{}
+	// every key is set back to the value it had before its first change
+	for i := len(changes) - 1; i >= 0; i-- {
+		change := changes[i]
+		if first[changeIdentity(change)] != i {
+			continue
+		}
+		if change.Kind != ChangeGit {
+			storeChanges[change.File] = append(storeChanges[change.File], change)
+			continue
+		}
+
+		scope, err := ParseScope(change.Scope)
+		if err != nil {
+			return err
+		}
+		repo := Repo{dir: change.Repo, settings: settings, recorder: recorder}
+		if change.Old == nil {
+			err = repo.unsetConfigValue(change.Key, scope)
+		} else {
+			err = repo.setConfigValue(change.Key, *change.Old, scope)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to revert %s: %v", change, err)
+		}
+	}
+
+	for _, file := range files {
+		if err := stores[file].Revert(storeChanges[file]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// changeIdentity returns what identifies the key of a change: its kind, where it's stored and its name.
+func changeIdentity(change Change) string {
+	if change.Kind == ChangeGit {
+		return strings.Join([]string{change.Kind, change.Scope, change.Repo, change.Key}, "\x00")
+	}
+	return strings.Join([]string{change.Kind, change.File, change.Key}, "\x00")
+}
+
+// currentChangeValue returns the value the key of a change has now, in the same form as the change.
+// Profiles and settings are read from store, the config file of the change.
+func currentChangeValue(change Change, store *FileStore, settings GitSettings) (*string, error) {
+	switch change.Kind {
+	case ChangeProfile:
+		profile := store.GetProfileByName(change.Key)
+		if profile.ProfileName == "" {
+			return nil, nil
+		}
+		return profileValue(profile), nil
+	case ChangeSettings:
+		return settingsValue(store.Config()), nil
+	}
+
+	scope, err := ParseScope(change.Scope)
+	if err != nil {
+		return nil, err
+	}
+	return Repo{dir: change.Repo, settings: settings}.currentValue(change.Key, scope)
+}
+
+// profileValue encodes a profile as the value of a ChangeProfile.
+func profileValue(profile models.ProfileConfig) *string {
+	encoded, _ := json.Marshal(profile)
+	value := string(encoded)
+	return &value
+}
+
+// settingsValue encodes the settings of a config as the value of a ChangeSettings.
+func settingsValue(conf Config) *string {
+	conf.Profiles = nil
+	encoded, _ := json.Marshal(conf)
+	value := string(encoded)
+	return &value
+}
+
+// recordConfigChanges records the profiles and settings that differ between two versions of the config file of store.
+func recordConfigChanges(recorder *Recorder, store *FileStore, old Config, updated Config) {
+	if recorder == nil {
+		return
+	}
+
+	path, layer := store.path, store.layer
+	recorder.record(Change{Kind: ChangeSettings, File: path, Layer: layer, Key: "settings", Old: settingsValue(old), New: settingsValue(updated)})
+
+	oldProfiles := map[string]*string{}
+	for _, profile := range old.Profiles {
+		oldProfiles[profile.ProfileName] = profileValue(profile)
+	}
+
+	for _, profile := range updated.Profiles {
+		recorder.record(Change{Kind: ChangeProfile, File: path, Layer: layer, Key: profile.ProfileName, Old: oldProfiles[profile.ProfileName], New: profileValue(profile)})
+		delete(oldProfiles, profile.ProfileName)
+	}
+	for _, profile := range old.Profiles {
+		if value, ok := oldProfiles[profile.ProfileName]; ok {
+			recorder.record(Change{Kind: ChangeProfile, File: path, Layer: layer, Key: profile.ProfileName, Old: value})
+		}
+	}
+}
+
+// applyChanges sets the profiles and settings of conf back to the old values of the changes, in the given order.
+func applyChanges(conf *Config, changes []Change) error {
+	for _, change := range changes {
+		switch change.Kind {
+		case ChangeSettings:
+			if change.Old == nil {
+				continue
+			}
+			var settings Config
+			if err := json.Unmarshal([]byte(*change.Old), &settings); err != nil {
+				return fmt.Errorf("failed to decode settings: %v", err)
+			}
+			settings.Profiles = conf.Profiles
+			*conf = settings
+		case ChangeProfile:
+			index := -1
+			for i, profile := range conf.Profiles {
+				if profile.ProfileName == change.Key {
+					index = i
+				}
+			}
+
+			if change.Old == nil {
+				if index >= 0 {
+					conf.Profiles = append(conf.Profiles[:index], conf.Profiles[index+1:]...)
+				}
+				continue
+			}
+
+			var profile models.ProfileConfig
+			if err := json.Unmarshal([]byte(*change.Old), &profile); err != nil {
+				return fmt.Errorf("failed to decode profile %s: %v", change.Key, err)
+			}
+			if index >= 0 {
+				conf.Profiles[index] = profile
+			} else {
+				conf.Profiles = append(conf.Profiles, profile)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,350 @@
+// Package internal
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/BurntSushi/toml"
+	"github.com/Shieldine/git-profile/models"
+)
+
+// The layers of the configuration, from lowest to highest precedence.
+const (
+	// LayerRepo is the .git-profile.toml committed in a repository. Anyone able to push to the repository
+	// can change it, so it only adds profiles of its own and its settings are ignored.
+	LayerRepo = "repo"
+	// LayerSystem is the config file shared by all users of the machine, see SystemConfigPath.
+	LayerSystem = "system"
+	// LayerUser is the config file of the user, see ResolveConfigPath.
+	LayerUser = "user"
+)
+
+// layerOrder lists the layers from lowest to highest precedence.
+var layerOrder = []string{LayerRepo, LayerSystem, LayerUser}
+
+// RepoConfigFile is the name of the config file of the repo layer, in the root of the work tree.
+const RepoConfigFile = ".git-profile.toml"
+
+// SystemConfigPath returns the location of the system layer: GIT_PROFILE_SYSTEM_CONFIG if it is set,
+// otherwise %ProgramData%\git-profile\config.toml on Windows and /etc/git-profile/config.toml elsewhere.
+func SystemConfigPath() string {
+	if path := os.Getenv("GIT_PROFILE_SYSTEM_CONFIG"); path != "" {
+		return path
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("ProgramData"), "git-profile", "config.toml")
+	}
+	return filepath.Join("/etc", "git-profile", "config.toml")
+}
+
+// Layer is one of the config files a LayeredStore is made of.
+type Layer struct {
+	Name string `json:"name" yaml:"name"`
+	Path string `json:"path" yaml:"path"`
+	// Exists tells whether the file is there. Only the user layer is created when opening the store.
+	Exists bool `json:"exists" yaml:"exists"`
+}
+
+// LayeredStore is a ProfileStore made of several config files: the repo layer, the system layer and the user layer.
+// Profiles of all layers are merged; a profile of a higher layer replaces the one of the same name below it,
+// so the repo layer can't shadow a profile of the system or user layer.
+// Each setting is taken from the highest layer that sets it, the repo layer never sets any.
+//
+// Changes to a profile are saved in the layer it comes from. New profiles go to the user layer unless added
+// with AddProfileTo. Backups, Clear and Restore only work on the user layer, which also decides Path and Dir.
+type LayeredStore struct {
+	// paths are the locations of the layers, empty for a layer that isn't used.
+	paths map[string]string
+	// stores are the loaded layers, nil for a layer without a file.
+	stores   map[string]*FileStore
+	recorder *Recorder
+
+	// conf is the merged config, origins the layer each of its profiles comes from.
+	conf    Config
+	origins map[string]string
+}
+
+// NewLayeredStore opens the layers at the given paths. The user layer is created if it doesn't exist yet,
+// the system and repo layers are only read if they do. Leave systemPath or repoPath empty to leave out that layer.
+func NewLayeredStore(systemPath string, userPath string, repoPath string) (*LayeredStore, error) {
+	store := &LayeredStore{
+		paths:  map[string]string{LayerSystem: systemPath, LayerUser: userPath, LayerRepo: repoPath},
+		stores: map[string]*FileStore{},
+	}
+
+	userStore, err := NewFileStore(userPath)
+	if err != nil {
+		return nil, err
+	}
+	store.stores[LayerUser] = userStore
+
+	for _, layer := range []string{LayerSystem, LayerRepo} {
+		if err := store.loadLayer(layer); err != nil {
+			return nil, err
+		}
+	}
+
+	store.merge()
+	return store, nil
+}
+
+// loadLayer reads a system or repo layer, if its file exists.
+func (s *LayeredStore) loadLayer(layer string) error {
+	if s.paths[layer] == "" {
+		return nil
+	}
+	if _, err := os.Stat(s.paths[layer]); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	layerStore := &FileStore{path: s.paths[layer], layer: layer, recorder: s.recorder}
+	if err := layerStore.Load(); err != nil {
+		return err
+	}
+	s.stores[layer] = layerStore
+	return nil
+}
+
+// ForRepo returns the store with the repo layer of the repository whose work tree is at workTree,
+// instead of the one it was opened with. An empty workTree leaves out the repo layer, for profiles
+// used outside of a single repository. The system and user layers are shared with s.
+func (s *LayeredStore) ForRepo(workTree string) (ProfileStore, error) {
+	store := &LayeredStore{
+		paths:    map[string]string{LayerSystem: s.paths[LayerSystem], LayerUser: s.paths[LayerUser]},
+		stores:   map[string]*FileStore{},
+		recorder: s.recorder,
+	}
+	for _, layer := range []string{LayerSystem, LayerUser} {
+		if s.stores[layer] != nil {
+			store.stores[layer] = s.stores[layer]
+		}
+	}
+
+	if workTree != "" {
+		store.paths[LayerRepo] = filepath.Join(workTree, RepoConfigFile)
+		if err := store.loadLayer(LayerRepo); err != nil {
+			return nil, err
+		}
+	}
+
+	store.merge()
+	return store, nil
+}
+
+// RepoProfiles returns a ProfilesFunc giving the profiles of store together with those of each repository's repo layer.
+func RepoProfiles(store ProfileStore) ProfilesFunc {
+	return func(workTree string) ([]models.ProfileConfig, error) {
+		repoStore, err := store.ForRepo(workTree)
+		if err != nil {
+			return nil, err
+		}
+		return repoStore.GetAllProfiles(), nil
+	}
+}
+
+// merge combines the loaded layers into the config the store presents.
+func (s *LayeredStore) merge() {
+	conf := Config{Profiles: []models.ProfileConfig{}}
+	origins := map[string]string{}
+	indexes := map[string]int{}
+
+	for _, layer := range layerOrder {
+		layerStore := s.stores[layer]
+		if layerStore == nil {
+			continue
+		}
+
+		// settings of the repo layer would let a repository weaken verification or run ssh on the user's behalf
+		if layer != LayerRepo {
+			mergeSettings(&conf, layerStore.conf, layerStore.meta)
+		}
+		for _, profile := range layerStore.conf.Profiles {
+			if i, ok := indexes[profile.ProfileName]; ok {
+				conf.Profiles[i] = profile
+			} else {
+				indexes[profile.ProfileName] = len(conf.Profiles)
+				conf.Profiles = append(conf.Profiles, profile)
+			}
+			origins[profile.ProfileName] = layer
+		}
+	}
+
+	s.conf = conf
+	s.origins = origins
+}
+
+// mergeSettings overrides the settings of conf with those the layer sets, as told by its meta data.
+func mergeSettings(conf *Config, layer Config, meta toml.MetaData) {
+	if meta.IsDefined("resolve_ssh_hosts") {
+		conf.ResolveSSHHosts = layer.ResolveSSHHosts
+	}
+	if meta.IsDefined("remote_order") {
+		conf.RemoteOrder = layer.RemoteOrder
+	}
+	if meta.IsDefined("verify_policy") {
+		conf.VerifyPolicy = layer.VerifyPolicy
+	}
+	if meta.IsDefined("backup_limit") {
+		conf.BackupLimit = layer.BackupLimit
+	}
+}
+
+// Layers returns the layers in use, from lowest to highest precedence.
+func (s *LayeredStore) Layers() []Layer {
+	var layers []Layer
+	for _, layer := range layerOrder {
+		if s.paths[layer] != "" {
+			layers = append(layers, Layer{Name: layer, Path: s.paths[layer], Exists: s.stores[layer] != nil})
+		}
+	}
+	return layers
+}
+
+// SetRecorder makes the store record the profiles and settings changed from now on with recorder.
+func (s *LayeredStore) SetRecorder(recorder *Recorder) {
+	s.recorder = recorder
+	for _, layerStore := range s.stores {
+		layerStore.SetRecorder(recorder)
+	}
+}
+
+// Path returns the location of the user layer.
+func (s *LayeredStore) Path() string {
+	return s.stores[LayerUser].Path()
+}
+
+// Dir returns the directory of the user layer.
+func (s *LayeredStore) Dir() string {
+	return s.stores[LayerUser].Dir()
+}
+
+// Config returns the merged settings and profiles.
+func (s *LayeredStore) Config() Config {
+	return s.conf
+}
+
+// GetAllProfiles returns a copy of the merged profiles.
+func (s *LayeredStore) GetAllProfiles() []models.ProfileConfig {
+	return append([]models.ProfileConfig(nil), s.conf.Profiles...)
+}
+
+func (s *LayeredStore) GetProfileByName(profileName string) models.ProfileConfig {
+	for _, profile := range s.conf.Profiles {
+		if profile.ProfileName == profileName {
+			return profile
+		}
+	}
+	return models.ProfileConfig{}
+}
+
+func (s *LayeredStore) LayerOf(profileName string) string {
+	return s.origins[profileName]
+}
+
+// AddProfile adds a profile to the user layer.
+func (s *LayeredStore) AddProfile(profile models.ProfileConfig) error {
+	return s.AddProfileTo(LayerUser, profile)
+}
+
+// AddProfileTo adds a profile to the given layer, creating its file if needed.
+// Names have to be unique across all layers.
+func (s *LayeredStore) AddProfileTo(layer string, profile models.ProfileConfig) error {
+	if err := ValidateProfile(profile); err != nil {
+		return err
+	}
+	if existing := s.LayerOf(profile.ProfileName); existing != "" {
+		return fmt.Errorf("profile with name %s already exists in the %s layer", profile.ProfileName, existing)
+	}
+
+	layerStore, err := s.openLayer(layer)
+	if err != nil {
+		return err
+	}
+	return s.change(layerStore.AddProfile(profile))
+}
+
+// EditProfile changes a profile in the layer it comes from.
+func (s *LayeredStore) EditProfile(profileName string, updatedProfile models.ProfileConfig) error {
+	return s.change(s.profileLayer(profileName).EditProfile(profileName, updatedProfile))
+}
+
+// DeleteProfile removes a profile from the layer it comes from.
+// A profile of the same name in a lower layer takes its place.
+func (s *LayeredStore) DeleteProfile(profileName string) error {
+	return s.change(s.profileLayer(profileName).DeleteProfile(profileName))
+}
+
+// Clear removes all profiles and settings from the user layer. Those of the system and repo layer are kept.
+func (s *LayeredStore) Clear() error {
+	return s.change(s.stores[LayerUser].Clear())
+}
+
+// Backups returns the backups of the user layer.
+func (s *LayeredStore) Backups() ([]Backup, error) {
+	return s.stores[LayerUser].Backups()
+}
+
+// GetBackup returns the backup of the user layer with the given ID.
+func (s *LayeredStore) GetBackup(id string) (Backup, error) {
+	return s.stores[LayerUser].GetBackup(id)
+}
+
+// Restore replaces the user layer with a backup.
+func (s *LayeredStore) Restore(id string) error {
+	return s.change(s.stores[LayerUser].Restore(id))
+}
+
+// change merges the layers again after one of them was written to, and passes on the error of the write.
+func (s *LayeredStore) change(err error) error {
+	s.merge()
+	return err
+}
+
+// profileLayer returns the loaded layer a profile comes from, the user layer if there is no such profile.
+func (s *LayeredStore) profileLayer(profileName string) *FileStore {
+	if layer, ok := s.origins[profileName]; ok {
+		return s.stores[layer]
+	}
+	return s.stores[LayerUser]
+}
+
+// openLayer returns the given layer, creating its file if it doesn't exist yet.
+func (s *LayeredStore) openLayer(layer string) (*FileStore, error) {
+	if layerStore := s.stores[layer]; layerStore != nil {
+		return layerStore, nil
+	}
+
+	switch {
+	case layer != LayerSystem && layer != LayerRepo:
+		return nil, fmt.Errorf("unknown layer %s, use one of system, user or repo", layer)
+	case s.paths[layer] == "" && layer == LayerRepo:
+		return nil, errors.New("the repo layer is only available inside a git repository")
+	case s.paths[layer] == "":
+		return nil, fmt.Errorf("the %s layer isn't used", layer)
+	}
+
+	layerStore, err := newFileStore(s.paths[layer], layer)
+	if err != nil {
+		return nil, err
+	}
+	layerStore.SetRecorder(s.recorder)
+	s.stores[layer] = layerStore
+	return layerStore, nil
+}
@@ -0,0 +1,109 @@
+// Package internal
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// Sources the location of the config file can come from, in the order they are tried.
+const (
+	// ConfigSourceFlag is the --config flag.
+	ConfigSourceFlag = "flag"
+	// ConfigSourceEnv is the GIT_PROFILE_CONFIG environment variable.
+	ConfigSourceEnv = "env"
+	// ConfigSourceXDG is $XDG_CONFIG_HOME/git-profile.
+	ConfigSourceXDG = "xdg"
+	// ConfigSourceDefault is the platform default, see DefaultConfigPath.
+	ConfigSourceDefault = "default"
+)
+
+// ConfigLocation is where the config file is kept and which source decided it.
+type ConfigLocation struct {
+	Path   string `json:"path" yaml:"path"`
+	Source string `json:"source" yaml:"source"`
+}
+
+// ResolveConfigPath returns the location of the config file. The first of these that is set wins:
+// flagPath (the --config flag), the GIT_PROFILE_CONFIG environment variable, the git-profile directory
+// in $XDG_CONFIG_HOME and the platform default.
+// Like the XDG spec requires, a relative XDG_CONFIG_HOME is ignored.
+func ResolveConfigPath(flagPath string) (ConfigLocation, error) {
+	if flagPath != "" {
+		path, err := filepath.Abs(ExpandHome(flagPath))
+		return ConfigLocation{Path: path, Source: ConfigSourceFlag}, err
+	}
+
+	if envPath := os.Getenv("GIT_PROFILE_CONFIG"); envPath != "" {
+		path, err := filepath.Abs(ExpandHome(envPath))
+		return ConfigLocation{Path: path, Source: ConfigSourceEnv}, err
+	}
+
+	if xdgHome := os.Getenv("XDG_CONFIG_HOME"); filepath.IsAbs(xdgHome) {
+		return ConfigLocation{Path: filepath.Join(xdgHome, "git-profile", "config.toml"), Source: ConfigSourceXDG}, nil
+	}
+
+	path, err := DefaultConfigPath()
+	return ConfigLocation{Path: path, Source: ConfigSourceDefault}, err
+}
+
+// LegacyConfigPath returns where older versions kept the config file if it differs from the platform default:
+// next to the executable on Windows. Empty on other platforms.
+func LegacyConfigPath() string {
+	if runtime.GOOS != "windows" {
+		return ""
+	}
+
+	exePath, err := os.Executable()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(exePath), "config.toml")
+}
+
+// MigrateConfig moves the config file at legacyPath to path, if there is one and path doesn't exist yet.
+// The old file is renamed to config.toml.migrated where possible; since path exists from then on, it is
+// migrated only once either way. Reports whether the file was migrated.
+func MigrateConfig(legacyPath string, path string) (bool, error) {
+	if legacyPath == "" || filepath.Clean(legacyPath) == filepath.Clean(path) {
+		return false, nil
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+
+	content, err := os.ReadFile(legacyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read config file at %s: %v", legacyPath, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return false, fmt.Errorf("failed to create config directory: %v", err)
+	}
+	if err := writeFileAtomic(path, content, 0644); err != nil {
+		return false, fmt.Errorf("failed to migrate config file to %s: %v", path, err)
+	}
+
+	// the directory of the executable may not be writable, e.g. under Program Files
+	_ = os.Rename(legacyPath, legacyPath+".migrated")
+	return true, nil
+}
@@ -0,0 +1,46 @@
+// Package internal
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package internal
+
+import (
+	"fmt"
+	"os"
+)
+
+// fileLock is an advisory lock held on a lock file. Only processes that take the same lock are kept out.
+type fileLock struct {
+	file *os.File
+}
+
+// lockFile takes the lock on the file at path, creating it if needed, and waits until it is available.
+// A shared lock can be held by several processes at once, an exclusive one only by a single process.
+func lockFile(path string, exclusive bool) (*fileLock, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %v", err)
+	}
+
+	if err := lockHandle(file, exclusive); err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("failed to lock %s: %v", path, err)
+	}
+	return &fileLock{file: file}, nil
+}
+
+// Unlock releases the lock.
+func (l *fileLock) Unlock() {
+	_ = unlockHandle(l.file)
+	_ = l.file.Close()
+}
@@ -0,0 +1,53 @@
+// Package internal
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+
+//go:build unix
+
+package internal
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockHandle takes a flock on the open file.
+func lockHandle(file *os.File, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+
+	for {
+		err := syscall.Flock(int(file.Fd()), how)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+// unlockHandle releases the flock on the open file.
+func unlockHandle(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
+
+// syncDir flushes a directory to disk, so a rename inside it survives a crash.
+func syncDir(dir string) {
+	file, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = file.Sync()
+	_ = file.Close()
+}
@@ -0,0 +1,58 @@
+// Package internal
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package internal
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+// lockfileExclusiveLock is LOCKFILE_EXCLUSIVE_LOCK of LockFileEx.
+const lockfileExclusiveLock = 0x2
+
+// lockHandle locks the first byte of the open file with LockFileEx.
+func lockHandle(file *os.File, exclusive bool) error {
+	var flags uintptr
+	if exclusive {
+		flags = lockfileExclusiveLock
+	}
+
+	overlapped := new(syscall.Overlapped)
+	result, _, err := procLockFileEx.Call(file.Fd(), flags, 0, 1, 0, uintptr(unsafe.Pointer(overlapped)))
+	if result == 0 {
+		return err
+	}
+	return nil
+}
+
+// unlockHandle releases the lock taken by lockHandle.
+func unlockHandle(file *os.File) error {
+	overlapped := new(syscall.Overlapped)
+	result, _, err := procUnlockFileEx.Call(file.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(overlapped)))
+	if result == 0 {
+		return err
+	}
+	return nil
+}
+
+// syncDir does nothing on Windows, where directories can't be opened for syncing.
+func syncDir(string) {}
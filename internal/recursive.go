@@ -0,0 +1,239 @@
+// Package internal
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Shieldine/git-profile/models"
+)
+
+// concurrency limits how many directories are read and repositories are inspected at once.
+var concurrency = runtime.NumCPU() * 2
+
+// FindWorkTrees finds all git work trees below root, including root itself, nested repositories,
+// submodules and linked worktrees. Directories are read concurrently; unreadable ones are skipped.
+// Returns the absolute paths of the work trees in sorted order.
+func FindWorkTrees(root string) ([]string, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", root)
+	}
+
+	var (
+		found []string
+		mutex sync.Mutex
+		group sync.WaitGroup
+	)
+	semaphore := make(chan struct{}, concurrency)
+
+	var walk func(dir string)
+	walk = func(dir string) {
+		defer group.Done()
+
+		semaphore <- struct{}{}
+		entries, err := os.ReadDir(dir)
+		<-semaphore
+
+		if err != nil {
+			return
+		}
+
+		for _, entry := range entries {
+			// a .git directory marks a repository, a .git file a linked worktree or submodule
+			if entry.Name() == ".git" {
+				mutex.Lock()
+				found = append(found, dir)
+				mutex.Unlock()
+				continue
+			}
+
+			// symlinks are not followed, so loops can't occur
+			if entry.IsDir() {
+				group.Add(1)
+				go walk(filepath.Join(dir, entry.Name()))
+			}
+		}
+	}
+
+	group.Add(1)
+	walk(root)
+	group.Wait()
+
+	sort.Strings(found)
+	return found, nil
+}
+
+// PlanAction is what init does with a repository.
+type PlanAction int
+
+const (
+	// PlanApply means a single profile matches and will be applied.
+	PlanApply PlanAction = iota
+	// PlanUnchanged means a single profile matches and is already applied.
+	PlanUnchanged
+	// PlanAmbiguous means several profiles match, so one has to be picked.
+	PlanAmbiguous
+	// PlanNoMatch means no profile matches.
+	PlanNoMatch
+	// PlanFailed means the repository couldn't be inspected.
+	PlanFailed
+)
+
+// String returns the name of the action as used in machine-readable output.
+func (a PlanAction) String() string {
+	switch a {
+	case PlanApply:
+		return "apply"
+	case PlanUnchanged:
+		return "unchanged"
+	case PlanAmbiguous:
+		return "ambiguous"
+	case PlanNoMatch:
+		return "no_match"
+	}
+	return "failed"
+}
+
+// RepoPlan describes what init would do with a repository.
+type RepoPlan struct {
+	Path     string
+	Remote   RepoRemote
+	Current  Identity
+	Profiles []models.ProfileConfig
+	Action   PlanAction
+	Err      error
+}
+
+// Origin returns the origin the profiles were matched on, the first remote if none matched, or "none".
+func (p RepoPlan) Origin() string {
+	if p.Remote.Name != "" {
+		return p.Remote.URL.Path()
+	}
+	return "none"
+}
+
+// PlanRepos inspects repositories concurrently and plans what init would do with each of them.
+// The plans are returned in the order of paths. Each repository is planned with the profiles profiles returns for it.
+func PlanRepos(settings GitSettings, profiles ProfilesFunc, paths []string) []RepoPlan {
+	plans := make([]RepoPlan, len(paths))
+	semaphore := make(chan struct{}, concurrency)
+
+	var group sync.WaitGroup
+	for i, path := range paths {
+		group.Add(1)
+		go func(i int, path string) {
+			defer group.Done()
+
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			repoProfiles, err := profiles(path)
+			if err != nil {
+				plans[i] = RepoPlan{Path: path, Action: PlanFailed, Err: err}
+				return
+			}
+			plans[i] = PlanRepo(settings, repoProfiles, path)
+		}(i, path)
+	}
+	group.Wait()
+
+	return plans
+}
+
+// PlanRepo inspects the repository at path and plans which of the given profiles init would apply to it.
+// The repository is read with the given git settings.
+func PlanRepo(settings GitSettings, profiles []models.ProfileConfig, path string) RepoPlan {
+	plan := RepoPlan{Path: path, Action: PlanFailed}
+
+	remotes, err := Repo{dir: path, settings: settings}.GetRepoRemotes()
+	// remotes that can't be parsed are left out, the others still decide the profile
+	if err != nil && !IsInvalidRemotes(err) {
+		plan.Err = err
+		return plan
+	}
+	if len(remotes) != 0 {
+		plan.Remote = remotes[0]
+	}
+
+	effective, err := readConfigAt(path, "")
+	if err != nil {
+		plan.Err = err
+		return plan
+	}
+	local, err := readConfigAt(path, "--local")
+	if err != nil {
+		plan.Err = err
+		return plan
+	}
+
+	plan.Current = Identity{Name: effective["user.name"], Email: effective["user.email"]}
+
+	expected, matchedRemote := ResolveRepoProfiles(profiles, remotes, path)
+	plan.Profiles = expected
+	if matchedRemote.Name != "" {
+		plan.Remote = matchedRemote
+	}
+
+	switch {
+	case len(expected) == 0:
+		plan.Action = PlanNoMatch
+	case len(expected) > 1:
+		plan.Action = PlanAmbiguous
+	case profileConfigApplied(local, expected[0]):
+		plan.Action = PlanUnchanged
+	default:
+		plan.Action = PlanApply
+	}
+	return plan
+}
+
+// readConfigAt reads the git config of the repository at dir in one go.
+// With an empty scope, all scopes are read and the value that wins is kept for each key.
+// Keys are lowercased, as git treats them case-insensitively.
+func readConfigAt(dir string, scope string) (map[string]string, error) {
+	args := []string{"config", "-z", "--list"}
+	if scope != "" {
+		args = append(args, scope)
+	}
+
+	output, err := gitCommand(dir, args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read git config of %s: %v", dir, err)
+	}
+
+	values := map[string]string{}
+	for _, entry := range strings.Split(string(output), "\x00") {
+		key, value, _ := strings.Cut(entry, "\n")
+		if key != "" {
+			values[strings.ToLower(key)] = value
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,307 @@
+// Package internal
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package internal
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// RemoteURL is a git remote URL split into the parts profiles can match on.
+// Credentials and user names contained in the URL are never kept.
+type RemoteURL struct {
+	Scheme string
+	Host   string
+	Port   string
+	Owner  string
+	Repo   string
+}
+
+// Path joins host, owner and repository to the form origin patterns are matched against,
+// e.g. "github.com/acme-corp/app". Local remotes have no host and start with the owner.
+func (r RemoteURL) Path() string {
+	var parts []string
+	for _, part := range []string{r.Host, r.Owner, r.Repo} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// HostWithPort returns the host, followed by the port if one is set.
+func (r RemoteURL) HostWithPort() string {
+	if r.Port == "" {
+		return r.Host
+	}
+	return r.Host + ":" + r.Port
+}
+
+// IsLocal reports whether the remote is a repository on the local file system.
+func (r RemoteURL) IsLocal() bool {
+	return r.Scheme == "file"
+}
+
+// windowsDrivePath matches absolute Windows paths such as C:\repos\app or C:/repos/app.
+var windowsDrivePath = regexp.MustCompile(`^[A-Za-z]:[\\/]`)
+
+// ParseRemoteURL splits a git remote URL into scheme, host, port, owner and repository.
+// It understands the same forms git does:
+//   - URLs with a scheme, e.g. https://, ssh://, git://, git+ssh:// and file://
+//   - scp-like syntax with any user, e.g. git@github.com:owner/repo.git or gitlab.corp:group/repo
+//   - local paths, e.g. /srv/git/repo.git, ../repo or C:\repos\repo
+//
+// Explicit ports and IPv6 hosts ([::1]) are supported, embedded credentials are stripped.
+func ParseRemoteURL(rawURL string) (RemoteURL, error) {
+	rawURL = strings.TrimSpace(rawURL)
+	if rawURL == "" {
+		return RemoteURL{}, fmt.Errorf("empty remote url")
+	}
+
+	var remote RemoteURL
+	var path string
+
+	switch {
+	case strings.Contains(rawURL, "://"):
+		parsed, err := url.Parse(rawURL)
+		if err != nil {
+			// don't echo the URL, it may carry credentials
+			return RemoteURL{}, fmt.Errorf("invalid remote url: %v", stripCredentials(err.Error(), rawURL))
+		}
+
+		remote.Scheme = strings.ToLower(parsed.Scheme)
+		remote.Host = strings.ToLower(parsed.Hostname())
+		remote.Port = parsed.Port()
+		path = parsed.Path
+
+		if remote.Scheme == "file" {
+			remote.Host = ""
+			remote.Port = ""
+		} else if remote.Host == "" {
+			return RemoteURL{}, fmt.Errorf("remote url without host: %s", redactURL(rawURL))
+		}
+	case isLocalPath(rawURL):
+		remote.Scheme = "file"
+		path = filepath.ToSlash(rawURL)
+		if windowsDrivePath.MatchString(rawURL) {
+			path = strings.ReplaceAll(rawURL, `\`, "/")
+		}
+	default:
+		host, scpPath, err := splitSCPLike(rawURL)
+		if err != nil {
+			return RemoteURL{}, err
+		}
+		remote.Scheme = "ssh"
+		remote.Host = strings.ToLower(host)
+		path = scpPath
+	}
+
+	path = strings.TrimSuffix(strings.Trim(path, "/"), "/.git")
+	path = strings.TrimSuffix(path, ".git")
+	if idx := strings.LastIndex(path, "/"); idx != -1 {
+		remote.Owner = path[:idx]
+		remote.Repo = path[idx+1:]
+	} else {
+		remote.Repo = path
+	}
+
+	return remote, nil
+}
+
+// isLocalPath reports whether a remote without scheme is a local path rather than scp-like syntax.
+// Like git, anything with a slash before the first colon or without any colon is a path.
+func isLocalPath(rawURL string) bool {
+	if windowsDrivePath.MatchString(rawURL) {
+		return true
+	}
+
+	colon := strings.Index(rawURL, ":")
+	if colon == -1 {
+		return true
+	}
+
+	// IPv6 hosts in scp-like syntax are bracketed and contain colons themselves
+	if bracket := strings.Index(rawURL, "["); bracket != -1 && bracket < colon {
+		return false
+	}
+
+	slash := strings.Index(rawURL, "/")
+	return slash != -1 && slash < colon
+}
+
+// splitSCPLike splits scp-like syntax ([user@]host:path) into host and path.
+func splitSCPLike(rawURL string) (string, string, error) {
+	hostPart := rawURL
+
+	// only an @ before the first colon separates the user, the path may contain @ as well
+	if at := strings.LastIndex(rawURL[:strings.Index(rawURL, ":")+1], "@"); at != -1 {
+		hostPart = rawURL[at+1:]
+	}
+
+	if strings.HasPrefix(hostPart, "[") {
+		end := strings.Index(hostPart, "]:")
+		if end == -1 {
+			return "", "", fmt.Errorf("invalid remote url: unterminated IPv6 host")
+		}
+		return hostPart[1:end], hostPart[end+2:], nil
+	}
+
+	host, path, found := strings.Cut(hostPart, ":")
+	if !found || host == "" {
+		return "", "", fmt.Errorf("unsupported remote url %s", redactURL(rawURL))
+	}
+	return host, path, nil
+}
+
+// redactURL removes user information from a URL before it is shown in messages.
+func redactURL(rawURL string) string {
+	if scheme, rest, found := strings.Cut(rawURL, "://"); found {
+		if at := strings.LastIndex(strings.SplitN(rest, "/", 2)[0], "@"); at != -1 {
+			return scheme + "://" + rest[at+1:]
+		}
+		return rawURL
+	}
+
+	if at := strings.Index(rawURL, "@"); at != -1 {
+		return rawURL[at+1:]
+	}
+	return rawURL
+}
+
+// stripCredentials replaces the raw URL in an error message with its redacted form.
+func stripCredentials(message string, rawURL string) string {
+	return strings.ReplaceAll(message, rawURL, redactURL(rawURL))
+}
+
+// RepoRemote is a remote configured in a repository, resolved for profile matching.
+type RepoRemote struct {
+	Name        string
+	RawURL      string
+	ResolvedURL string
+	URL         RemoteURL
+}
+
+// OrderRemotes sorts remote names by a preference order.
+// Preferred remotes come first in the given order, the others follow in their original order.
+func OrderRemotes(names []string, preference []string) []string {
+	var ordered []string
+	used := map[string]bool{}
+
+	for _, preferred := range preference {
+		for _, name := range names {
+			if name == preferred && !used[name] {
+				ordered = append(ordered, name)
+				used[name] = true
+			}
+		}
+	}
+
+	for _, name := range names {
+		if !used[name] {
+			ordered = append(ordered, name)
+		}
+	}
+
+	return ordered
+}
+
+// originRegexPrefix marks an origin pattern as regular expression.
+const originRegexPrefix = "re:"
+
+// ValidateOrigin checks that an origin pattern can be matched.
+// Only regular expressions can be malformed, globs and hostnames always are valid.
+func ValidateOrigin(pattern string) error {
+	if !strings.HasPrefix(pattern, originRegexPrefix) {
+		return nil
+	}
+	if _, err := regexp.Compile(strings.TrimPrefix(pattern, originRegexPrefix)); err != nil {
+		return fmt.Errorf("invalid origin %q: %v", pattern, err)
+	}
+	return nil
+}
+
+// MatchOrigin reports whether an origin pattern matches a remote.
+// Returns the specificity of the match, so the most specific pattern can win.
+//
+// Patterns come in three forms:
+//   - a hostname, e.g. "github.com", matching any repository on that host
+//   - a glob over host/owner/repository, e.g. "github.com/acme-corp/*", where "**" spans several levels
+//   - a regular expression prefixed with "re:", matched against host/owner/repository
+//
+// An invalid regular expression never matches, see ValidateOrigin.
+func MatchOrigin(pattern string, remote RemoteURL) (int, bool) {
+	if pattern == "" {
+		return 0, false
+	}
+
+	if strings.HasPrefix(pattern, originRegexPrefix) {
+		expression, err := regexp.Compile(strings.TrimPrefix(pattern, originRegexPrefix))
+		if err != nil {
+			return 0, false
+		}
+		if !expression.MatchString(remote.Path()) && !expression.MatchString(remoteWithPortPath(remote)) {
+			return 0, false
+		}
+		return OriginSpecificity(pattern), true
+	}
+
+	patternSegments := strings.Split(strings.Trim(pattern, "/"), "/")
+
+	var targets [][]string
+	if len(patternSegments) == 1 {
+		targets = [][]string{{remote.Host}, {remote.HostWithPort()}}
+	} else {
+		targets = [][]string{strings.Split(remote.Path(), "/"), strings.Split(remoteWithPortPath(remote), "/")}
+	}
+
+	for _, target := range targets {
+		if matchSegments(patternSegments, target) {
+			return OriginSpecificity(pattern), true
+		}
+	}
+	return 0, false
+}
+
+// remoteWithPortPath is like RemoteURL.Path, but with the port kept on the host.
+func remoteWithPortPath(remote RemoteURL) string {
+	withPort := remote
+	withPort.Host = remote.HostWithPort()
+	return withPort.Path()
+}
+
+// OriginSpecificity scores how specific an origin pattern is.
+// Literal segments count more than wildcard ones, and "**" doesn't count at all.
+// A regular expression constrains every level it spells out, but never literally.
+func OriginSpecificity(pattern string) int {
+	if strings.HasPrefix(pattern, originRegexPrefix) {
+		return strings.Count(pattern, "/") + 1
+	}
+
+	specificity := 0
+
+	for _, segment := range strings.Split(strings.Trim(pattern, "/"), "/") {
+		switch {
+		case segment == "**":
+		case strings.ContainsAny(segment, "*?["):
+			specificity += 1
+		default:
+			specificity += 2
+		}
+	}
+	return specificity
+}
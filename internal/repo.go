@@ -0,0 +1,136 @@
+// Package internal
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/Shieldine/git-profile/models"
+)
+
+// GitClient reads and writes the git configuration of a repository, or of the global and system scopes.
+// Repo is the implementation that runs git.
+type GitClient interface {
+	// Dir returns the directory git runs in, empty for the current working directory.
+	Dir() string
+
+	CheckGitRepo() bool
+	GetRepoRoot() (string, error)
+	GetRepoOrigin() (string, error)
+	GetRepoRemote() (RemoteURL, error)
+	GetRepoRemoteByName(name string) (RepoRemote, error)
+	GetRepoRemotes() ([]RepoRemote, error)
+	GetRemoteNames() ([]string, error)
+	GetRemoteURL(name string) (string, error)
+	ResolveRemote(rawURL string) (string, RemoteURL, error)
+	GetRepoHooksDir() (string, error)
+
+	GetUserName(scope Scope) (string, error)
+	SetUserName(name string, scope Scope) error
+	UnsetUserName(scope Scope) error
+	GetUserEmail(scope Scope) (string, error)
+	SetUserEmail(email string, scope Scope) error
+	UnsetUserEmail(scope Scope) error
+	GetSigningKey(scope Scope) (string, error)
+	GetSigningFormat(scope Scope) (string, error)
+	GetCommitSigning(scope Scope) (string, error)
+	GetTagSigning(scope Scope) (string, error)
+	SetSigningConfig(profile models.ProfileConfig, scope Scope) error
+	UnsetSigningConfig(scope Scope) error
+	GetSSHCommand(scope Scope) (string, error)
+	GetSSHKey(scope Scope) (string, error)
+	SetSSHKey(keyPath string, scope Scope) error
+	UnsetSSHKey(scope Scope) error
+
+	ApplyProfile(profile models.ProfileConfig, scope Scope) error
+	ProfileApplied(profile models.ProfileConfig, scope Scope) bool
+	GetEffectiveIdentity() (EffectiveIdentity, error)
+	VerifyIdentity(profiles []models.ProfileConfig, remotes []RepoRemote, repoPath string) (VerifyResult, error)
+}
+
+// GitSettings are the settings of the config file that change how repositories are read.
+type GitSettings struct {
+	// RemoteOrder is the order remotes are preferred in. Without it, origin comes first.
+	RemoteOrder []string
+	// ResolveSSHHosts makes remotes match on the real hostname behind SSH host aliases.
+	ResolveSSHHosts bool
+}
+
+// defaultRemoteOrder is used if the config doesn't set a remote order.
+var defaultRemoteOrder = []string{"origin"}
+
+// GetRemoteOrder returns the configured remote preference order.
+func (s GitSettings) GetRemoteOrder() []string {
+	if len(s.RemoteOrder) == 0 {
+		return defaultRemoteOrder
+	}
+	return s.RemoteOrder
+}
+
+// Repo is a handle on the directory git commands run in, like `git -C <path>`, and implements GitClient.
+// The zero value stands for the current working directory with default settings.
+type Repo struct {
+	// dir is the absolute path of the directory, or empty for the current working directory.
+	dir      string
+	settings GitSettings
+	// recorder collects the config values written through the handle, nil to not record them.
+	recorder *Recorder
+}
+
+// OpenRepo returns a handle on the directory at path. An empty path stands for the current working directory.
+// Returns an error if path isn't an existing directory. Whether it is inside a git repository is up to the caller
+// to check, since not every command needs one.
+func OpenRepo(path string, settings GitSettings) (Repo, error) {
+	if path == "" {
+		return Repo{settings: settings}, nil
+	}
+
+	absPath, err := filepath.Abs(ExpandHome(path))
+	if err != nil {
+		return Repo{}, err
+	}
+
+	info, err := os.Stat(absPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Repo{}, fmt.Errorf("cannot change to %s: no such directory", path)
+	} else if err != nil {
+		return Repo{}, fmt.Errorf("cannot change to %s: %v", path, err)
+	}
+	if !info.IsDir() {
+		return Repo{}, fmt.Errorf("cannot change to %s: not a directory", path)
+	}
+	return Repo{dir: absPath, settings: settings}, nil
+}
+
+// Dir returns the directory git runs in, empty for the current working directory.
+func (r Repo) Dir() string {
+	return r.dir
+}
+
+// WithRecorder returns a copy of the handle that records the git config values it writes with recorder.
+func (r Repo) WithRecorder(recorder *Recorder) Repo {
+	r.recorder = recorder
+	return r
+}
+
+// command builds a git command running in the directory of the repository.
+func (r Repo) command(args ...string) *exec.Cmd {
+	return gitCommand(r.dir, args...)
+}
@@ -0,0 +1,175 @@
+// Package internal
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package internal
+
+import (
+	"bufio"
+	"errors"
+	"os/exec"
+	"strings"
+)
+
+// URLRewrite is a url.<base>.insteadOf or url.<base>.pushInsteadOf rule.
+// URLs starting with Prefix are rewritten to start with Base instead.
+type URLRewrite struct {
+	Base   string
+	Prefix string
+	Push   bool
+}
+
+// GetURLRewrites retrieves the url.<base>.insteadOf and url.<base>.pushInsteadOf rules
+// in effect for repo.
+func (r Repo) GetURLRewrites() ([]URLRewrite, error) {
+	cmd := r.command("config", "-z", "--get-regexp", `^url\..*\.(insteadof|pushinsteadof)$`)
+	output, err := cmd.Output()
+
+	if err != nil {
+		var exitError *exec.ExitError
+
+		// exit status 1 means there are no rewrite rules
+		if errors.As(err, &exitError) && exitError.ExitCode() == 1 {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return ParseURLRewrites(string(output)), nil
+}
+
+// ParseURLRewrites parses the output of `git config -z --get-regexp` for url.* rewrite rules.
+func ParseURLRewrites(output string) []URLRewrite {
+	var rewrites []URLRewrite
+
+	for _, entry := range strings.Split(output, "\x00") {
+		key, value, found := strings.Cut(entry, "\n")
+		if !found {
+			continue
+		}
+
+		lowerKey := strings.ToLower(key)
+		push := strings.HasSuffix(lowerKey, ".pushinsteadof")
+
+		base := strings.TrimPrefix(key, "url.")
+		if push {
+			base = base[:len(base)-len(".pushinsteadof")]
+		} else {
+			base = base[:len(base)-len(".insteadof")]
+		}
+
+		rewrites = append(rewrites, URLRewrite{Base: base, Prefix: value, Push: push})
+	}
+
+	return rewrites
+}
+
+// RewriteURL applies rewrite rules to a remote URL the way git does: the longest matching prefix wins.
+// For push URLs, pushInsteadOf rules take precedence over insteadOf rules.
+func RewriteURL(rawURL string, rewrites []URLRewrite, push bool) string {
+	if push {
+		if rewritten, ok := longestRewrite(rawURL, rewrites, true); ok {
+			return rewritten
+		}
+	}
+
+	if rewritten, ok := longestRewrite(rawURL, rewrites, false); ok {
+		return rewritten
+	}
+	return rawURL
+}
+
+// longestRewrite applies the rule with the longest matching prefix among the push or fetch rules.
+func longestRewrite(rawURL string, rewrites []URLRewrite, push bool) (string, bool) {
+	best := URLRewrite{}
+	found := false
+
+	for _, rewrite := range rewrites {
+		if rewrite.Push != push || !strings.HasPrefix(rawURL, rewrite.Prefix) {
+			continue
+		}
+		if !found || len(rewrite.Prefix) > len(best.Prefix) {
+			best = rewrite
+			found = true
+		}
+	}
+
+	if !found {
+		return rawURL, false
+	}
+	return best.Base + strings.TrimPrefix(rawURL, best.Prefix), true
+}
+
+// ResolveSSHHost looks up the real hostname behind an SSH host alias from ~/.ssh/config.
+// Returns the host unchanged if ssh isn't available or has no HostName for it.
+// Hosts starting with a dash are never resolved, ssh would read them as options.
+func ResolveSSHHost(host string) string {
+	if host == "" || strings.HasPrefix(host, "-") {
+		return host
+	}
+
+	output, err := exec.Command("ssh", "-G", host).Output()
+	if err != nil {
+		return host
+	}
+
+	if hostName := ParseSSHHostName(string(output)); hostName != "" {
+		return strings.ToLower(hostName)
+	}
+	return host
+}
+
+// ParseSSHHostName extracts the hostname from the output of `ssh -G`.
+func ParseSSHHostName(output string) string {
+	scanner := bufio.NewScanner(strings.NewReader(output))
+
+	for scanner.Scan() {
+		key, value, found := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		if found && strings.EqualFold(key, "hostname") {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// ResolveRemote turns a raw remote URL into the remote git effectively pushes to.
+// insteadOf and pushInsteadOf rewrites are applied, and if enabled in the config,
+// SSH host aliases are replaced with their real hostname. The rewrite rules are those in effect for repo.
+func (r Repo) ResolveRemote(rawURL string) (string, RemoteURL, error) {
+	rewrites, err := r.GetURLRewrites()
+	if err != nil {
+		return "", RemoteURL{}, err
+	}
+
+	resolvedURL := RewriteURL(strings.TrimSpace(rawURL), rewrites, true)
+
+	remote, err := ParseRemoteURL(resolvedURL)
+	if err != nil {
+		return "", RemoteURL{}, err
+	}
+
+	if r.settings.ResolveSSHHosts && isSSHScheme(remote.Scheme) {
+		remote.Host = ResolveSSHHost(remote.Host)
+	}
+
+	return resolvedURL, remote, nil
+}
+
+// isSSHScheme reports whether git talks to remotes with the given scheme through ssh.
+func isSSHScheme(scheme string) bool {
+	switch scheme {
+	case "ssh", "git+ssh", "ssh+git":
+		return true
+	}
+	return false
+}
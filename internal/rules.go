@@ -0,0 +1,199 @@
+// Package internal
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Shieldine/git-profile/models"
+)
+
+// RuleMatch describes how well a profile matches a repository.
+// Matches are ordered by priority first, then by how specific the path and origin patterns are.
+type RuleMatch struct {
+	Profile           models.ProfileConfig
+	Rule              models.Rule
+	PathSpecificity   int
+	OriginSpecificity int
+}
+
+// GetProfileRules returns the rules of a profile.
+// The origin of a profile counts as an implicit rule with priority 0.
+func GetProfileRules(profile models.ProfileConfig) []models.Rule {
+	var rules []models.Rule
+
+	if profile.Origin != "" {
+		rules = append(rules, models.Rule{Origin: profile.Origin})
+	}
+	return append(rules, profile.Rules...)
+}
+
+// ExpandHome replaces a leading ~ in path with the home directory of the current user.
+func ExpandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(homeDir, strings.TrimPrefix(path, "~"))
+}
+
+// MatchPathGlob reports whether path matches the glob pattern.
+// Besides the wildcards of filepath.Match, "**" matches any number of directories.
+// Like git's gitdir conditions, a trailing slash matches everything below the directory
+// and a leading ~ is expanded to the home directory.
+func MatchPathGlob(pattern string, path string) bool {
+	pattern = filepath.ToSlash(ExpandHome(pattern))
+	path = filepath.ToSlash(filepath.Clean(path))
+
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(path, "/"))
+}
+
+// matchSegments matches path segments against pattern segments, with "**" spanning any number of segments.
+func matchSegments(pattern []string, path []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(path); i++ {
+				if matchSegments(pattern[1:], path[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(path) == 0 {
+			return false
+		}
+		if ok, err := filepath.Match(pattern[0], path[0]); err != nil || !ok {
+			return false
+		}
+
+		pattern = pattern[1:]
+		path = path[1:]
+	}
+
+	return len(path) == 0
+}
+
+// MatchRule reports whether a rule applies to a repository with the given remote and working tree path.
+// Path conditions rank above origin conditions, since several profiles often share one origin.
+func MatchRule(rule models.Rule, remote RemoteURL, repoPath string) (RuleMatch, bool) {
+	if rule.Path == "" && rule.Origin == "" {
+		return RuleMatch{}, false
+	}
+
+	match := RuleMatch{Rule: rule}
+
+	if rule.Origin != "" {
+		specificity, ok := MatchOrigin(rule.Origin, remote)
+		if !ok {
+			return RuleMatch{}, false
+		}
+		match.OriginSpecificity = specificity
+	}
+
+	if rule.Path != "" {
+		if repoPath == "" || !MatchPathGlob(rule.Path, repoPath) {
+			return RuleMatch{}, false
+		}
+		match.PathSpecificity = 1
+	}
+
+	return match, true
+}
+
+// MatchProfile returns the best matching rule of a profile for the given repository.
+// Returns false if none of the profile's rules apply.
+func MatchProfile(profile models.ProfileConfig, remote RemoteURL, repoPath string) (RuleMatch, bool) {
+	best := RuleMatch{}
+	found := false
+
+	for _, rule := range GetProfileRules(profile) {
+		match, ok := MatchRule(rule, remote, repoPath)
+		if !ok {
+			continue
+		}
+
+		match.Profile = profile
+		if !found || match.betterThan(best) {
+			best = match
+			found = true
+		}
+	}
+
+	return best, found
+}
+
+// betterThan reports whether m wins over other.
+func (m RuleMatch) betterThan(other RuleMatch) bool {
+	if m.Rule.Priority != other.Rule.Priority {
+		return m.Rule.Priority > other.Rule.Priority
+	}
+	if m.PathSpecificity != other.PathSpecificity {
+		return m.PathSpecificity > other.PathSpecificity
+	}
+	return m.OriginSpecificity > other.OriginSpecificity
+}
+
+// ResolveProfiles picks the profiles for a repository from its remote and working tree path.
+// Only the profiles with the best match are returned, so multiple results mean
+// the rules can't tell them apart.
+func ResolveProfiles(profiles []models.ProfileConfig, remote RemoteURL, repoPath string) []models.ProfileConfig {
+	var best []RuleMatch
+
+	for _, profile := range profiles {
+		match, ok := MatchProfile(profile, remote, repoPath)
+		if !ok {
+			continue
+		}
+
+		if len(best) == 0 || match.betterThan(best[0]) {
+			best = []RuleMatch{match}
+		} else if !best[0].betterThan(match) {
+			best = append(best, match)
+		}
+	}
+
+	var resolved []models.ProfileConfig
+	for _, match := range best {
+		resolved = append(resolved, match.Profile)
+	}
+	return resolved
+}
+
+// ResolveRepoProfiles picks the profiles for a repository by looking at each of its remotes in order.
+// The first remote that produces a match wins and is returned alongside the profiles.
+// If no remote matches, path-only rules are tried and an empty remote is returned.
+func ResolveRepoProfiles(profiles []models.ProfileConfig, remotes []RepoRemote, repoPath string) ([]models.ProfileConfig, RepoRemote) {
+	for _, remote := range remotes {
+		if resolved := ResolveProfiles(profiles, remote.URL, repoPath); len(resolved) != 0 {
+			return resolved, remote
+		}
+	}
+
+	return ResolveProfiles(profiles, RemoteURL{}, repoPath), RepoRemote{}
+}
@@ -0,0 +1,108 @@
+// Package internal
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Scope is a git config scope git-profile reads and writes attributes in.
+type Scope int
+
+const (
+	// ScopeLocal is the config of the repository, .git/config.
+	ScopeLocal Scope = iota
+	// ScopeGlobal is the config of the user, ~/.gitconfig or $GIT_CONFIG_GLOBAL.
+	ScopeGlobal
+	// ScopeSystem is the config shared by all users of the machine, e.g. /etc/gitconfig.
+	ScopeSystem
+	// ScopeWorktree is the config of the current worktree only. Writing to it enables extensions.worktreeConfig,
+	// so that linked worktrees of one repository can use different attributes.
+	ScopeWorktree
+)
+
+var scopeNames = []string{"local", "global", "system", "worktree"}
+
+// String returns the name of the scope as git uses it.
+func (s Scope) String() string {
+	if s < 0 || int(s) >= len(scopeNames) {
+		return fmt.Sprintf("Scope(%d)", int(s))
+	}
+	return scopeNames[s]
+}
+
+// MarshalText lets the scope show up by name in JSON and YAML output.
+func (s Scope) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// NeedsRepo reports whether the scope only exists inside a repository.
+func (s Scope) NeedsRepo() bool {
+	return s == ScopeLocal || s == ScopeWorktree
+}
+
+// flag returns the git config option selecting the scope.
+func (s Scope) flag() string {
+	return "--" + s.String()
+}
+
+// ParseScope returns the scope with the given name.
+func ParseScope(name string) (Scope, error) {
+	for i, scopeName := range scopeNames {
+		if strings.EqualFold(name, scopeName) {
+			return Scope(i), nil
+		}
+	}
+	return ScopeLocal, fmt.Errorf("invalid scope %q, expected local, global, system or worktree", name)
+}
+
+// WorktreeConfigEnabled reports whether repo has extensions.worktreeConfig enabled.
+// Without it, git has no separate config per worktree.
+func (r Repo) WorktreeConfigEnabled() bool {
+	output, err := r.command("config", "--bool", "--get", "extensions.worktreeConfig").Output()
+	return err == nil && strings.TrimSpace(string(output)) == "true"
+}
+
+// EnableWorktreeConfig turns on extensions.worktreeConfig for repo.
+// Its config stays shared between all worktrees, while each worktree gets a config of its own on top.
+func (r Repo) EnableWorktreeConfig() error {
+	if r.WorktreeConfigEnabled() {
+		return nil
+	}
+
+	old, err := r.currentValue("extensions.worktreeConfig", ScopeLocal)
+	if err != nil {
+		return err
+	}
+
+	output, err := r.command("config", "--local", "extensions.worktreeConfig", "true").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to enable extensions.worktreeConfig: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	enabled := "true"
+	r.record("extensions.worktreeConfig", ScopeLocal, old, &enabled)
+	return nil
+}
+
+// checkScope makes sure the scope can be used in the directory of repo.
+func (r Repo) checkScope(scope Scope) error {
+	if scope.NeedsRepo() && !r.CheckGitRepo() {
+		return errors.New("not a git repository")
+	}
+	return nil
+}
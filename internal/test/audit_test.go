@@ -0,0 +1,120 @@
+// Package test
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/Shieldine/git-profile/internal"
+	"github.com/Shieldine/git-profile/models"
+)
+
+// commitAs creates an empty commit in the repository at path with the given author and committer.
+func commitAs(t *testing.T, path string, message string, author internal.Identity, committer internal.Identity) {
+	t.Helper()
+
+	cmd := exec.Command("git", "-C", path, "commit", "-q", "--allow-empty", "--no-verify", "-m", message)
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME="+author.Name, "GIT_AUTHOR_EMAIL="+author.Email,
+		"GIT_COMMITTER_NAME="+committer.Name, "GIT_COMMITTER_EMAIL="+committer.Email,
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to commit: %v: %s", err, output)
+	}
+}
+
+// TestParseCommitIdentities tests that git log output is split into commits.
+func TestParseCommitIdentities(t *testing.T) {
+	output := "abc\x00Work\x00work@acme.com\x00GitHub\x00noreply@github.com\x00fix: subject\x1e\n" +
+		"def\x00Me\x00me@example.com\x00Me\x00me@example.com\x00second\x1e\n"
+
+	commits := internal.ParseCommitIdentities(output)
+	if len(commits) != 2 {
+		t.Fatalf("expected 2 commits, got %d", len(commits))
+	}
+
+	if commits[0].Hash != "abc" || commits[0].Subject != "fix: subject" {
+		t.Errorf("unexpected first commit %+v", commits[0])
+	}
+	if commits[0].Committer.Email != "noreply@github.com" {
+		t.Errorf("expected committer noreply@github.com, got %s", commits[0].Committer.Email)
+	}
+	if commits[1].Author.Name != "Me" {
+		t.Errorf("expected author Me, got %s", commits[1].Author.Name)
+	}
+}
+
+// TestAuditRepo tests that commits with a wrong identity are grouped by identity.
+func TestAuditRepo(t *testing.T) {
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "gitconfig"))
+
+	profiles := []models.ProfileConfig{
+		{ProfileName: "work", Name: "Work", Email: "work@acme.com", Origin: "github.com/acme/*"},
+	}
+
+	repo := filepath.Join(t.TempDir(), "repo")
+	initRepo(t, repo, "git@github.com:acme/app.git")
+
+	work := internal.Identity{Name: "Work", Email: "work@acme.com"}
+	me := internal.Identity{Name: "Me", Email: "me@example.com"}
+	bot := internal.Identity{Name: "GitHub", Email: "noreply@github.com"}
+
+	commitAs(t, repo, "good", work, work)
+	commitAs(t, repo, "private", me, me)
+	commitAs(t, repo, "merged", work, bot)
+	commitAs(t, repo, "private again", me, me)
+
+	report := internal.AuditRepo(internal.GitSettings{}, profiles, repo, "", true)
+	if report.Error != "" {
+		t.Fatalf("unexpected error: %s", report.Error)
+	}
+	if report.CommitsChecked != 4 {
+		t.Errorf("expected 4 commits checked, got %d", report.CommitsChecked)
+	}
+	if report.OffendingCommits() != 3 {
+		t.Errorf("expected 3 offending commits, got %d", report.OffendingCommits())
+	}
+	if len(report.Offenders) != 2 {
+		t.Fatalf("expected 2 offending identities, got %+v", report.Offenders)
+	}
+
+	// git log lists the newest commit first
+	if report.Offenders[0].Email != me.Email || len(report.Offenders[0].Commits) != 2 {
+		t.Errorf("expected 2 commits by %s, got %+v", me.Email, report.Offenders[0])
+	}
+	if roles := report.Offenders[0].Commits[0].Roles; len(roles) != 2 {
+		t.Errorf("expected author and committer role, got %v", roles)
+	}
+	if report.Offenders[1].Email != bot.Email {
+		t.Errorf("expected committer %s, got %+v", bot.Email, report.Offenders[1])
+	}
+
+	authorsOnly := internal.AuditRepo(internal.GitSettings{}, profiles, repo, "HEAD~2..HEAD", false)
+	if authorsOnly.CommitsChecked != 2 || authorsOnly.OffendingCommits() != 1 {
+		t.Errorf("expected 1 of 2 commits to offend in range, got %d of %d",
+			authorsOnly.OffendingCommits(), authorsOnly.CommitsChecked)
+	}
+
+	output := filepath.Join(t.TempDir(), "output")
+	if _, err := internal.GetCommitIdentities(repo, "--output="+output); err == nil {
+		t.Error("expected range with an option to be refused")
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("expected the option not to reach git, got %v", err)
+	}
+}
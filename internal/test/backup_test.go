@@ -0,0 +1,143 @@
+// Package test
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Shieldine/git-profile/internal"
+	"github.com/Shieldine/git-profile/models"
+)
+
+// TestBackups tests that every change backs up the previous config and that a backup can be restored.
+func TestBackups(t *testing.T) {
+	store, cleanup := setupTempConfig(t)
+	defer cleanup()
+
+	for _, name := range []string{"work", "personal"} {
+		if err := store.AddProfile(models.ProfileConfig{ProfileName: name, Email: name + "@example.com"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := store.DeleteProfile("work"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := store.Clear(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	backups, err := store.Backups()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(backups) != 4 {
+		t.Fatalf("expected 4 backups, got %d", len(backups))
+	}
+
+	// newest first: before clear, before delete, before the second add, before the first add
+	wantProfiles := []int{1, 2, 1, 0}
+	for i, backup := range backups {
+		if backup.Profiles != wantProfiles[i] {
+			t.Errorf("expected backup %d to have %d profiles, got %d", i, wantProfiles[i], backup.Profiles)
+		}
+	}
+
+	if err := store.Restore(backups[1].ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(store.GetAllProfiles()) != 2 || store.GetProfileByName("work").Email != "work@example.com" {
+		t.Errorf("expected both profiles to be restored, got %v", store.GetAllProfiles())
+	}
+
+	// the restore backed up the cleared config, so it can be undone
+	backups, err = store.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 5 || backups[0].Profiles != 0 {
+		t.Errorf("expected a backup of the cleared config, got %+v", backups)
+	}
+
+	if err := store.Restore("20000101T000000.000000Z"); err == nil {
+		t.Error("expected an error for a missing backup")
+	}
+}
+
+// TestBackupLimit tests that only the configured number of backups is kept.
+func TestBackupLimit(t *testing.T) {
+	store, cleanup := setupTempConfig(t)
+	defer cleanup()
+
+	if err := os.WriteFile(store.Path(), []byte("backup_limit = 3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 5; i++ {
+		if err := store.AddProfile(models.ProfileConfig{ProfileName: fmt.Sprintf("profile%d", i)}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	backups, err := store.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 3 {
+		t.Fatalf("expected 3 backups, got %d", len(backups))
+	}
+	if backups[0].Profiles != 4 || backups[2].Profiles != 2 {
+		t.Errorf("expected the newest backups to be kept, got %+v", backups)
+	}
+}
+
+// TestDiffFiles tests that a diff is returned only for differing files.
+func TestDiffFiles(t *testing.T) {
+	dir := t.TempDir()
+	oldPath := filepath.Join(dir, "old.toml")
+	newPath := filepath.Join(dir, "new.toml")
+
+	if err := os.WriteFile(oldPath, []byte("a = 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(newPath, []byte("a = 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := internal.DiffFiles(oldPath, newPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff != "" {
+		t.Errorf("expected no diff for equal files, got %q", diff)
+	}
+
+	if err := os.WriteFile(newPath, []byte("a = 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	diff, err = internal.DiffFiles(oldPath, newPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(diff, "-a = 1") || !strings.Contains(diff, "+a = 2") {
+		t.Errorf("expected the changed line in the diff, got %q", diff)
+	}
+}
@@ -15,8 +15,12 @@
 package test
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/BurntSushi/toml"
@@ -24,22 +28,19 @@ import (
 	"github.com/Shieldine/git-profile/models"
 )
 
-func setupTempConfig(t *testing.T) (string, func()) {
+// setupTempConfig creates a profile store backed by a config file in a temporary directory.
+func setupTempConfig(t *testing.T) (*internal.FileStore, func()) {
 	tempDir, err := os.MkdirTemp("", "configTest")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	tempConfigPath := filepath.Join(tempDir, "config.toml")
-	internal.Conf = internal.Config{Profiles: []models.ProfileConfig{}}
-	internal.SetConfigPath(tempConfigPath)
-
-	err = internal.SaveConfig()
+	store, err := internal.NewFileStore(filepath.Join(tempDir, "config.toml"))
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	return tempConfigPath, func() {
+	return store, func() {
 		err := os.RemoveAll(tempDir)
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
@@ -47,34 +48,68 @@ func setupTempConfig(t *testing.T) (string, func()) {
 	}
 }
 
+func TestNewFileStore(t *testing.T) {
+	tempDir := t.TempDir()
+	configPath := filepath.Join(tempDir, "nested", "git-profile", "config.toml")
+
+	store, err := internal.NewFileStore(configPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := os.Stat(configPath); err != nil {
+		t.Errorf("expected the config file to be created: %v", err)
+	}
+	if store.Path() != configPath || store.Dir() != filepath.Dir(configPath) {
+		t.Errorf("unexpected paths %s and %s", store.Path(), store.Dir())
+	}
+	if len(store.GetAllProfiles()) != 0 {
+		t.Errorf("expected empty profiles, got %d", len(store.GetAllProfiles()))
+	}
+}
+
+func TestNewFileStoreInvalidConfig(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(configPath, []byte("profiles = ["), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := internal.NewFileStore(configPath); err == nil {
+		t.Error("expected an error for an invalid config file")
+	}
+}
+
 func TestLoadConfig(t *testing.T) {
-	_, cleanup := setupTempConfig(t)
+	store, cleanup := setupTempConfig(t)
 	defer cleanup()
 
-	err := internal.LoadConfig()
+	err := os.WriteFile(store.Path(), []byte("[[profiles]]\nprofile_name = \"test\"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Load()
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	if len(internal.Conf.Profiles) != 0 {
-		t.Errorf("expected empty profiles, got %d", len(internal.Conf.Profiles))
+	if len(store.GetAllProfiles()) != 1 {
+		t.Errorf("expected 1 profile, got %d", len(store.GetAllProfiles()))
 	}
 }
 
 func TestSaveConfig(t *testing.T) {
-	configPath, cleanup := setupTempConfig(t)
+	store, cleanup := setupTempConfig(t)
 	defer cleanup()
 
 	profile := models.ProfileConfig{ProfileName: "test"}
-	internal.Conf.Profiles = append(internal.Conf.Profiles, profile)
-
-	err := internal.SaveConfig()
+	err := store.AddProfile(profile)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
 	var loadedConfig internal.Config
-	_, err = toml.DecodeFile(configPath, &loadedConfig)
+	_, err = toml.DecodeFile(store.Path(), &loadedConfig)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -85,147 +120,320 @@ func TestSaveConfig(t *testing.T) {
 }
 
 func TestAddProfile(t *testing.T) {
-	_, cleanup := setupTempConfig(t)
+	store, cleanup := setupTempConfig(t)
 	defer cleanup()
 
 	profile := models.ProfileConfig{ProfileName: "test"}
-	err := internal.AddProfile(profile)
+	err := store.AddProfile(profile)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	if len(internal.Conf.Profiles) != 1 || internal.Conf.Profiles[0].ProfileName != "test" {
-		t.Errorf("expected profile name 'test', got %v", internal.Conf.Profiles)
+	if len(store.GetAllProfiles()) != 1 || store.GetAllProfiles()[0].ProfileName != "test" {
+		t.Errorf("expected profile name 'test', got %v", store.GetAllProfiles())
 	}
 }
 
 func TestEditProfile(t *testing.T) {
-	_, cleanup := setupTempConfig(t)
+	store, cleanup := setupTempConfig(t)
 	defer cleanup()
 
 	profile := models.ProfileConfig{ProfileName: "test"}
-	err := internal.AddProfile(profile)
+	err := store.AddProfile(profile)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
 	updatedProfile := models.ProfileConfig{ProfileName: "updated"}
-	err = internal.EditProfile("test", updatedProfile)
+	err = store.EditProfile("test", updatedProfile)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	if len(internal.Conf.Profiles) != 1 || internal.Conf.Profiles[0].ProfileName != "updated" {
-		t.Errorf("expected profile name 'updated', got %v", internal.Conf.Profiles)
+	if len(store.GetAllProfiles()) != 1 || store.GetAllProfiles()[0].ProfileName != "updated" {
+		t.Errorf("expected profile name 'updated', got %v", store.GetAllProfiles())
 	}
 }
 
 func TestDeleteProfile(t *testing.T) {
-	_, cleanup := setupTempConfig(t)
+	store, cleanup := setupTempConfig(t)
 	defer cleanup()
 
 	profile := models.ProfileConfig{ProfileName: "test"}
-	err := internal.AddProfile(profile)
+	err := store.AddProfile(profile)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	err = internal.DeleteProfile("test")
+	err = store.DeleteProfile("test")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	if len(internal.Conf.Profiles) != 0 {
-		t.Errorf("expected empty profiles, got %d", len(internal.Conf.Profiles))
+	if len(store.GetAllProfiles()) != 0 {
+		t.Errorf("expected empty profiles, got %d", len(store.GetAllProfiles()))
 	}
 }
 
 func TestGetProfileByName(t *testing.T) {
-	_, cleanup := setupTempConfig(t)
+	store, cleanup := setupTempConfig(t)
 	defer cleanup()
 
 	profile := models.ProfileConfig{ProfileName: "test"}
-	err := internal.AddProfile(profile)
+	err := store.AddProfile(profile)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	retrievedProfile := internal.GetProfileByName("test")
+	retrievedProfile := store.GetProfileByName("test")
 	if retrievedProfile.ProfileName != "test" {
 		t.Errorf("expected profile name 'test', got %s", retrievedProfile.ProfileName)
 	}
 }
 
 func TestGetAllProfiles(t *testing.T) {
-	_, cleanup := setupTempConfig(t)
+	store, cleanup := setupTempConfig(t)
 	defer cleanup()
 
 	profile1 := models.ProfileConfig{ProfileName: "test1"}
 	profile2 := models.ProfileConfig{ProfileName: "test2"}
-	err := internal.AddProfile(profile1)
+	err := store.AddProfile(profile1)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	err = internal.AddProfile(profile2)
+	err = store.AddProfile(profile2)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	profiles := internal.GetAllProfiles()
+	profiles := store.GetAllProfiles()
 	if len(profiles) != 2 {
 		t.Errorf("expected 2 profiles, got %d", len(profiles))
 	}
 }
 
 func TestClearConfig(t *testing.T) {
-	_, cleanup := setupTempConfig(t)
+	store, cleanup := setupTempConfig(t)
 	defer cleanup()
 
 	profile := models.ProfileConfig{ProfileName: "test"}
-	err := internal.AddProfile(profile)
+	err := store.AddProfile(profile)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	err = internal.ClearConfig()
+	err = store.Clear()
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	err = internal.LoadConfig()
+	err = store.Load()
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	profiles := internal.Conf.Profiles
+	profiles := store.GetAllProfiles()
 
 	if len(profiles) != 0 {
-		t.Errorf("expected empty profiles, got %d", len(internal.Conf.Profiles))
+		t.Errorf("expected empty profiles, got %d", len(store.GetAllProfiles()))
 	}
 }
 
 func TestGetProfilesByOrigin(t *testing.T) {
-	_, cleanup := setupTempConfig(t)
+	store, cleanup := setupTempConfig(t)
 	defer cleanup()
 
 	profile1 := models.ProfileConfig{ProfileName: "test1", Origin: "origin1"}
 	profile2 := models.ProfileConfig{ProfileName: "test2", Origin: "origin2"}
 	profile3 := models.ProfileConfig{ProfileName: "test3", Origin: "origin1"}
-	err := internal.AddProfile(profile1)
+	err := store.AddProfile(profile1)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	err = internal.AddProfile(profile2)
+	err = store.AddProfile(profile2)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	err = internal.AddProfile(profile3)
+	err = store.AddProfile(profile3)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	profiles := internal.GetProfilesByOrigin("origin1")
+	profiles := internal.GetProfilesByOrigin(store.GetAllProfiles(), "origin1")
 	if len(profiles) != 2 {
 		t.Errorf("expected 2 profiles, got %d", len(profiles))
 	}
 }
+
+// TestSaveDetectsChanges tests that a store doesn't overwrite changes another one saved after it was loaded.
+func TestSaveDetectsChanges(t *testing.T) {
+	store, cleanup := setupTempConfig(t)
+	defer cleanup()
+
+	other, err := internal.NewFileStore(store.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := other.AddProfile(models.ProfileConfig{ProfileName: "other"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = store.AddProfile(models.ProfileConfig{ProfileName: "mine"})
+	if !errors.Is(err, internal.ErrConfigChanged) {
+		t.Fatalf("expected ErrConfigChanged, got %v", err)
+	}
+	if len(store.GetAllProfiles()) != 0 {
+		t.Errorf("expected the refused change to be dropped, got %v", store.GetAllProfiles())
+	}
+
+	if err := store.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddProfile(models.ProfileConfig{ProfileName: "mine"}); err != nil {
+		t.Fatalf("unexpected error after reloading: %v", err)
+	}
+	if len(store.GetAllProfiles()) != 2 {
+		t.Errorf("expected 2 profiles, got %v", store.GetAllProfiles())
+	}
+}
+
+// TestConcurrentSaves tests that stores saving the same file at once don't lose profiles.
+func TestConcurrentSaves(t *testing.T) {
+	store, cleanup := setupTempConfig(t)
+	defer cleanup()
+
+	const writers = 8
+	var group sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		group.Add(1)
+		go func(i int) {
+			defer group.Done()
+
+			writer, err := internal.NewFileStore(store.Path())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			profile := models.ProfileConfig{ProfileName: fmt.Sprintf("profile%d", i)}
+			for {
+				err := writer.AddProfile(profile)
+				if !errors.Is(err, internal.ErrConfigChanged) {
+					if err != nil {
+						t.Error(err)
+					}
+					return
+				}
+				if err := writer.Load(); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	group.Wait()
+
+	if err := store.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.GetAllProfiles()) != writers {
+		t.Errorf("expected %d profiles, got %d", writers, len(store.GetAllProfiles()))
+	}
+
+	entries, err := os.ReadDir(store.Dir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ".tmp") {
+			t.Errorf("expected no temporary files to be left, found %s", entry.Name())
+		}
+	}
+}
+
+// TestResolveConfigPath tests that --config wins over GIT_PROFILE_CONFIG, which wins over XDG_CONFIG_HOME.
+func TestResolveConfigPath(t *testing.T) {
+	tempDir := t.TempDir()
+	flagPath := filepath.Join(tempDir, "flag.toml")
+	envPath := filepath.Join(tempDir, "env.toml")
+	xdgHome := filepath.Join(tempDir, "xdg")
+
+	tests := []struct {
+		name       string
+		flag       string
+		env        string
+		xdg        string
+		wantPath   string
+		wantSource string
+	}{
+		{"flag", flagPath, envPath, xdgHome, flagPath, internal.ConfigSourceFlag},
+		{"environment", "", envPath, xdgHome, envPath, internal.ConfigSourceEnv},
+		{"xdg", "", "", xdgHome, filepath.Join(xdgHome, "git-profile", "config.toml"), internal.ConfigSourceXDG},
+		{"relative xdg", "", "", "relative", "", internal.ConfigSourceDefault},
+		{"default", "", "", "", "", internal.ConfigSourceDefault},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("GIT_PROFILE_CONFIG", test.env)
+			t.Setenv("XDG_CONFIG_HOME", test.xdg)
+
+			location, err := internal.ResolveConfigPath(test.flag)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if location.Source != test.wantSource {
+				t.Errorf("expected source %s, got %s", test.wantSource, location.Source)
+			}
+
+			wantPath := test.wantPath
+			if wantPath == "" {
+				wantPath, _ = internal.DefaultConfigPath()
+			}
+			if location.Path != wantPath {
+				t.Errorf("expected path %s, got %s", wantPath, location.Path)
+			}
+		})
+	}
+}
+
+// TestMigrateConfig tests that the config file is moved from its old location exactly once.
+func TestMigrateConfig(t *testing.T) {
+	tempDir := t.TempDir()
+	legacyPath := filepath.Join(tempDir, "exe", "config.toml")
+	path := filepath.Join(tempDir, "appdata", "git-profile", "config.toml")
+
+	if err := os.MkdirAll(filepath.Dir(legacyPath), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(legacyPath, []byte("[[profiles]]\nprofile_name = \"work\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	migrated, err := internal.MigrateConfig(legacyPath, path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !migrated {
+		t.Fatal("expected the config file to be migrated")
+	}
+
+	store, err := internal.NewFileStore(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if store.GetProfileByName("work").ProfileName != "work" {
+		t.Error("expected the profiles to be migrated")
+	}
+	if _, err := os.Stat(legacyPath + ".migrated"); err != nil {
+		t.Errorf("expected the old config file to be renamed: %v", err)
+	}
+
+	if err := os.WriteFile(legacyPath, []byte("resolve_ssh_hosts = true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if migrated, err := internal.MigrateConfig(legacyPath, path); err != nil || migrated {
+		t.Errorf("expected an existing config file to be left alone, got %v, %v", migrated, err)
+	}
+}
@@ -0,0 +1,74 @@
+// Package test
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Shieldine/git-profile/internal"
+)
+
+// unsetEnv removes environment variables for the duration of the test.
+func unsetEnv(t *testing.T, names ...string) {
+	t.Helper()
+
+	for _, name := range names {
+		// Setenv registers the original value to be restored after the test
+		t.Setenv(name, "")
+		_ = os.Unsetenv(name)
+	}
+}
+
+// TestGetEffectiveIdentityAt tests that values are resolved over scopes, included files and environment variables.
+func TestGetEffectiveIdentityAt(t *testing.T) {
+	root := t.TempDir()
+	globalConfig := filepath.Join(root, "gitconfig")
+	includedConfig := filepath.Join(root, "work.gitconfig")
+	repo := filepath.Join(root, "repo")
+
+	t.Setenv("GIT_CONFIG_GLOBAL", globalConfig)
+	unsetEnv(t, "GIT_AUTHOR_NAME", "GIT_AUTHOR_EMAIL", "EMAIL")
+	t.Setenv("GIT_COMMITTER_NAME", "Bot")
+
+	initRepo(t, repo, "")
+
+	err := os.WriteFile(includedConfig, []byte("[user]\n\temail = work@acme.com\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gitOutput(t, repo, "config", "--global", "user.name", "Global")
+	gitOutput(t, repo, "config", "--global", "includeIf.gitdir:"+repo+"/.path", includedConfig)
+	gitOutput(t, repo, "config", "author.name", "Local Author")
+
+	identity, err := internal.GetEffectiveIdentityAt(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if identity.AuthorName.Value != "Local Author" || identity.AuthorName.Key != "author.name" || identity.AuthorName.Scope != "local" {
+		t.Errorf("expected author name from local author.name, got %+v", identity.AuthorName)
+	}
+	if identity.AuthorEmail.Value != "work@acme.com" || identity.AuthorEmail.Origin != includedConfig || identity.AuthorEmail.Scope != "global" {
+		t.Errorf("expected author email from included file %s, got %+v", includedConfig, identity.AuthorEmail)
+	}
+	if identity.CommitterName.Value != "Bot" || identity.CommitterName.Scope != internal.EnvScope {
+		t.Errorf("expected committer name from environment, got %+v", identity.CommitterName)
+	}
+	if identity.SigningKey.IsSet() {
+		t.Errorf("expected no signing key, got %+v", identity.SigningKey)
+	}
+}
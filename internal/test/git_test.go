@@ -15,14 +15,20 @@
 package test
 
 import (
+	"errors"
 	"os"
 	"os/exec"
 	"strings"
 	"testing"
 
+	"github.com/Shieldine/git-profile/custom_errors"
 	"github.com/Shieldine/git-profile/internal"
+	"github.com/Shieldine/git-profile/models"
 )
 
+// currentRepo runs git in the current working directory, like the CLI does without -C.
+var currentRepo internal.Repo
+
 // setupTestRepo creates a temporary directory and initializes a git repository in it.
 // Returns the temporary directory path and a cleanup function to remove the directory.
 func setupTestRepo(t *testing.T) (string, func()) {
@@ -70,7 +76,7 @@ func TestCheckGitRepo(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if !internal.CheckGitRepo() {
+	if !currentRepo.CheckGitRepo() {
 		t.Error("expected CheckGitRepo to return true in a git repository")
 	}
 }
@@ -101,7 +107,7 @@ func TestGetRepoOrigin(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	origin, err := internal.GetRepoOrigin()
+	origin, err := currentRepo.GetRepoOrigin()
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -132,7 +138,7 @@ func TestSetUserNameLocal(t *testing.T) {
 	}
 
 	name := "Test User"
-	if err := internal.SetUserName(name, false); err != nil {
+	if err := currentRepo.SetUserName(name, internal.ScopeLocal); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -150,7 +156,7 @@ func TestSetUserNameLocal(t *testing.T) {
 // TestSetUserNameGlobal tests the SetUserName function with global scope to ensure it correctly sets the global username.
 func TestSetUserNameGlobal(t *testing.T) {
 	name := "Global Test User"
-	if err := internal.SetUserName(name, true); err != nil {
+	if err := currentRepo.SetUserName(name, internal.ScopeGlobal); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -196,7 +202,7 @@ func TestUnsetUserNameLocal(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if err := internal.UnsetUserName(false); err != nil {
+	if err := currentRepo.UnsetUserName(internal.ScopeLocal); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -213,7 +219,7 @@ func TestUnsetUserNameGlobal(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if err := internal.UnsetUserName(true); err != nil {
+	if err := currentRepo.UnsetUserName(internal.ScopeGlobal); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -249,7 +255,7 @@ func TestGetUserName(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	retrievedName, err := internal.GetUserName()
+	retrievedName, err := currentRepo.GetUserName(internal.ScopeLocal)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -266,7 +272,7 @@ func TestGetGlobalUserName(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	retrievedName, err := internal.GetGlobalUserName()
+	retrievedName, err := currentRepo.GetUserName(internal.ScopeGlobal)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -302,7 +308,7 @@ func TestSetUserEmailLocal(t *testing.T) {
 	}
 
 	email := "test@example.com"
-	if err := internal.SetUserEmail(email, false); err != nil {
+	if err := currentRepo.SetUserEmail(email, internal.ScopeLocal); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -320,7 +326,7 @@ func TestSetUserEmailLocal(t *testing.T) {
 // TestSetUserEmailGlobal tests the SetUserEmail function with global scope to ensure it correctly sets the global user email.
 func TestSetUserEmailGlobal(t *testing.T) {
 	email := "global@example.com"
-	if err := internal.SetUserEmail(email, true); err != nil {
+	if err := currentRepo.SetUserEmail(email, internal.ScopeGlobal); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -366,7 +372,7 @@ func TestUnsetUserEmailLocal(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if err := internal.UnsetUserEmail(false); err != nil {
+	if err := currentRepo.UnsetUserEmail(internal.ScopeLocal); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -383,7 +389,7 @@ func TestUnsetUserEmailGlobal(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if err := internal.UnsetUserEmail(true); err != nil {
+	if err := currentRepo.UnsetUserEmail(internal.ScopeGlobal); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -419,7 +425,7 @@ func TestGetUserEmail(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	retrievedEmail, err := internal.GetUserEmail()
+	retrievedEmail, err := currentRepo.GetUserEmail(internal.ScopeLocal)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -436,7 +442,7 @@ func TestGetGlobalUserEmail(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	retrievedEmail, err := internal.GetGlobalUserEmail()
+	retrievedEmail, err := currentRepo.GetUserEmail(internal.ScopeGlobal)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -449,3 +455,442 @@ func TestGetGlobalUserEmail(t *testing.T) {
 		t.Errorf("unexpected error while unsetting global user email: %s", err)
 	}
 }
+
+// TestSetSigningConfigLocal tests the SetSigningConfig function to ensure it writes all signing settings locally.
+func TestSetSigningConfigLocal(t *testing.T) {
+	tempDir, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	originalDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(dir string) {
+		err := os.Chdir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}(originalDir)
+
+	err = os.Chdir(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	profile := models.ProfileConfig{
+		SigningKey:    "~/.ssh/id_ed25519.pub",
+		SigningFormat: "ssh",
+		SignCommits:   true,
+	}
+	if err := currentRepo.SetSigningConfig(profile, internal.ScopeLocal); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]string{
+		"user.signingkey": "~/.ssh/id_ed25519.pub",
+		"gpg.format":      "ssh",
+		"commit.gpgsign":  "true",
+		"tag.gpgsign":     "false",
+	}
+	for key, value := range expected {
+		output, err := exec.Command("git", "config", "--get", "--local", key).Output()
+		if err != nil {
+			t.Fatalf("unexpected error reading %s: %v", key, err)
+		}
+		if strings.TrimSpace(string(output)) != value {
+			t.Errorf("expected %s to be %s, got %s", key, value, output)
+		}
+	}
+
+	retrievedKey, err := currentRepo.GetSigningKey(internal.ScopeLocal)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if retrievedKey != profile.SigningKey {
+		t.Errorf("expected signing key to be %s, got %s", profile.SigningKey, retrievedKey)
+	}
+}
+
+// TestSetSigningConfigWithoutKey tests that applying a profile without signing key clears stale signing settings.
+func TestSetSigningConfigWithoutKey(t *testing.T) {
+	tempDir, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	originalDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(dir string) {
+		err := os.Chdir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}(originalDir)
+
+	err = os.Chdir(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command("git", "config", "--local", "user.signingkey", "OLDKEY")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := currentRepo.SetSigningConfig(models.ProfileConfig{}, internal.ScopeLocal); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cmd = exec.Command("git", "config", "--get", "--local", "user.signingkey")
+	if err := cmd.Run(); err == nil {
+		t.Error("expected an error when getting removed signing key, but got none")
+	}
+}
+
+// TestUnsetSigningConfigLocal tests the UnsetSigningConfig function to ensure it removes all local signing settings.
+func TestUnsetSigningConfigLocal(t *testing.T) {
+	tempDir, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	originalDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(dir string) {
+		err := os.Chdir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}(originalDir)
+
+	err = os.Chdir(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{
+		{"config", "--local", "user.signingkey", "ABCDEF"},
+		{"config", "--local", "commit.gpgsign", "true"},
+	} {
+		if err := exec.Command("git", args...).Run(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := currentRepo.UnsetSigningConfig(internal.ScopeLocal); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, key := range []string{"user.signingkey", "commit.gpgsign"} {
+		if err := exec.Command("git", "config", "--get", "--local", key).Run(); err == nil {
+			t.Errorf("expected an error when getting unset %s, but got none", key)
+		}
+	}
+}
+
+// TestBuildSSHCommand tests that BuildSSHCommand output can be parsed back by ParseSSHCommand.
+func TestBuildSSHCommand(t *testing.T) {
+	tests := []string{
+		"/home/user/.ssh/id_work",
+		"/home/user/my keys/id_work",
+		"/home/user/it's/id_work",
+		"/tmp/id_work;touch pwned",
+	}
+
+	for _, keyPath := range tests {
+		sshCommand := internal.BuildSSHCommand(keyPath)
+
+		parsed, ok := internal.ParseSSHCommand(sshCommand)
+		if !ok {
+			t.Errorf("expected %q to be recognized as git-profile ssh command", sshCommand)
+			continue
+		}
+		if parsed != keyPath {
+			t.Errorf("expected key path %q, got %q", keyPath, parsed)
+		}
+	}
+
+	if sshCommand := internal.BuildSSHCommand("/tmp/id_work|sh"); !strings.HasPrefix(sshCommand, "ssh -i '/tmp/id_work|sh' ") {
+		t.Errorf("expected shell metacharacters to be quoted, got %q", sshCommand)
+	}
+
+	if _, ok := internal.ParseSSHCommand("ssh -F /etc/custom_config"); ok {
+		t.Error("expected custom ssh command not to be recognized")
+	}
+}
+
+// TestSetSSHKeyLocal tests the SetSSHKey and UnsetSSHKey functions with local scope.
+func TestSetSSHKeyLocal(t *testing.T) {
+	tempDir, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	originalDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(dir string) {
+		err := os.Chdir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}(originalDir)
+
+	err = os.Chdir(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := currentRepo.SetSSHKey("/keys/id_work", internal.ScopeLocal); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	output, err := exec.Command("git", "config", "--get", "--local", "core.sshCommand").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(output)) != "ssh -i /keys/id_work -o IdentitiesOnly=yes" {
+		t.Errorf("unexpected core.sshCommand: %s", output)
+	}
+
+	keyPath, err := currentRepo.GetSSHKey(internal.ScopeLocal)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if keyPath != "/keys/id_work" {
+		t.Errorf("expected ssh key to be /keys/id_work, got %s", keyPath)
+	}
+
+	if err := currentRepo.UnsetSSHKey(internal.ScopeLocal); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := exec.Command("git", "config", "--get", "--local", "core.sshCommand").Run(); err == nil {
+		t.Error("expected an error when getting unset core.sshCommand, but got none")
+	}
+}
+
+// TestUnsetSSHKeyKeepsCustomCommand tests that UnsetSSHKey doesn't remove an SSH command set by the user.
+func TestUnsetSSHKeyKeepsCustomCommand(t *testing.T) {
+	tempDir, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	originalDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(dir string) {
+		err := os.Chdir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}(originalDir)
+
+	err = os.Chdir(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := exec.Command("git", "config", "--local", "core.sshCommand", "ssh -F /etc/custom").Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := currentRepo.UnsetSSHKey(internal.ScopeLocal); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := exec.Command("git", "config", "--get", "--local", "core.sshCommand").Run(); err != nil {
+		t.Error("expected custom core.sshCommand to be kept")
+	}
+}
+
+// TestParseScope tests that ParseScope accepts the git config scope names and rejects others.
+func TestParseScope(t *testing.T) {
+	for _, scope := range []internal.Scope{internal.ScopeLocal, internal.ScopeGlobal, internal.ScopeSystem, internal.ScopeWorktree} {
+		parsed, err := internal.ParseScope(scope.String())
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", scope, err)
+		}
+		if parsed != scope {
+			t.Errorf("expected %s, got %s", scope, parsed)
+		}
+	}
+
+	if _, err := internal.ParseScope("repo"); err == nil {
+		t.Error("expected an error for an unknown scope, but got none")
+	}
+}
+
+// TestSetUserNameWorktree tests that two linked worktrees of one repository can use different names in worktree scope.
+func TestSetUserNameWorktree(t *testing.T) {
+	tempDir, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	originalDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(dir string) {
+		err := os.Chdir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}(originalDir)
+
+	err = os.Chdir(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var notSetErr *custom_errors.NotSetError
+	if _, err := currentRepo.GetUserName(internal.ScopeWorktree); !errors.As(err, &notSetErr) {
+		t.Fatalf("expected a NotSetError before extensions.worktreeConfig is enabled, got %v", err)
+	}
+
+	commit := exec.Command("git", "-c", "user.name=Setup", "-c", "user.email=setup@example.com", "commit", "--allow-empty", "-m", "init")
+	if output, err := commit.CombinedOutput(); err != nil {
+		t.Fatalf("failed to commit: %v: %s", err, output)
+	}
+	if output, err := exec.Command("git", "worktree", "add", "-b", "linked", "linked").CombinedOutput(); err != nil {
+		t.Fatalf("failed to add worktree: %v: %s", err, output)
+	}
+
+	if err := currentRepo.SetUserName("Main User", internal.ScopeLocal); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = os.Chdir("linked")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := currentRepo.SetUserName("Linked User", internal.ScopeWorktree); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	name, err := currentRepo.GetUserName(internal.ScopeWorktree)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if name != "Linked User" {
+		t.Errorf("expected worktree user name to be Linked User, got %s", name)
+	}
+
+	output, err := exec.Command("git", "config", "--get", "user.name").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(output)) != "Linked User" {
+		t.Errorf("expected linked worktree to use Linked User, got %s", output)
+	}
+
+	err = os.Chdir(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output, err = exec.Command("git", "config", "--get", "user.name").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(output)) != "Main User" {
+		t.Errorf("expected main worktree to keep Main User, got %s", output)
+	}
+}
+
+// TestRepoHandle tests that a Repo opened on a directory works on its directory instead of the current one.
+func TestRepoHandle(t *testing.T) {
+	tempDir, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	repo, err := internal.OpenRepo(tempDir, internal.GitSettings{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !repo.CheckGitRepo() {
+		t.Error("expected CheckGitRepo to return true for the repository")
+	}
+
+	notRepo, err := internal.OpenRepo(t.TempDir(), internal.GitSettings{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if notRepo.CheckGitRepo() {
+		t.Error("expected CheckGitRepo to return false for a plain directory")
+	}
+
+	if _, err := internal.OpenRepo(tempDir+"/missing", internal.GitSettings{}); err == nil {
+		t.Error("expected an error when opening a missing directory, but got none")
+	}
+
+	if err := exec.Command("git", "-C", tempDir, "remote", "add", "origin", "https://example.com/repo.git").Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	origin, err := repo.GetRepoOrigin()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if origin != "example.com" {
+		t.Errorf("expected origin to be 'example.com', got %s", origin)
+	}
+
+	if err := repo.SetUserName("Handle User", internal.ScopeLocal); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	output, err := exec.Command("git", "-C", tempDir, "config", "--get", "--local", "user.name").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(output)) != "Handle User" {
+		t.Errorf("expected user name to be Handle User, got %s", output)
+	}
+
+	name, err := repo.GetUserName(internal.ScopeLocal)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if name != "Handle User" {
+		t.Errorf("expected user name to be Handle User, got %s", name)
+	}
+}
+
+// TestProfileAppliedSigning tests that a changed signing setting makes a profile count as not applied.
+func TestProfileAppliedSigning(t *testing.T) {
+	tempDir, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	repo, err := internal.OpenRepo(tempDir, internal.GitSettings{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if output, err := exec.Command("git", "-C", tempDir, "remote", "add", "upstream", "git@github.com:acme/app.git").CombinedOutput(); err != nil {
+		t.Fatalf("failed to add remote: %v: %s", err, output)
+	}
+
+	profile := models.ProfileConfig{ProfileName: "work", Name: "Work", Email: "work@acme.com", Origin: "github.com", SigningKey: "ABCD1234"}
+	if err := repo.ApplyProfile(profile, internal.ScopeLocal); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !repo.ProfileApplied(profile, internal.ScopeLocal) {
+		t.Error("expected the profile to be applied")
+	}
+
+	for name, change := range map[string]func(profile *models.ProfileConfig){
+		"sign commits":   func(profile *models.ProfileConfig) { profile.SignCommits = true },
+		"sign tags":      func(profile *models.ProfileConfig) { profile.SignTags = true },
+		"signing format": func(profile *models.ProfileConfig) { profile.SigningFormat = "ssh" },
+		"no signing key": func(profile *models.ProfileConfig) { profile.SigningKey = "" },
+	} {
+		changed := profile
+		change(&changed)
+
+		if repo.ProfileApplied(changed, internal.ScopeLocal) {
+			t.Errorf("%s: expected the changed profile not to count as applied", name)
+		}
+		if plan := internal.PlanRepo(internal.GitSettings{}, []models.ProfileConfig{changed}, tempDir); plan.Action != internal.PlanApply {
+			t.Errorf("%s: expected init to apply the changed profile, got %v", name, plan.Action)
+		}
+	}
+}
@@ -0,0 +1,377 @@
+// Package test
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Shieldine/git-profile/internal"
+	"github.com/Shieldine/git-profile/models"
+)
+
+// gitOutput runs git in the repository at path and returns its trimmed output.
+func gitOutput(t *testing.T, path string, args ...string) string {
+	t.Helper()
+
+	output, err := exec.Command("git", append([]string{"-C", path}, args...)...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s failed: %v: %s", strings.Join(args, " "), err, output)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// TestParseIdentityPattern tests the accepted forms of --from.
+func TestParseIdentityPattern(t *testing.T) {
+	tests := map[string]internal.IdentityPattern{
+		"Me <me@example.com>": {Name: "Me", Email: "me@example.com"},
+		"me@example.com":      {Email: "me@example.com"},
+		"John Doe":            {Name: "John Doe"},
+		"<me@example.com>":    {Email: "me@example.com"},
+	}
+
+	for input, expected := range tests {
+		pattern, err := internal.ParseIdentityPattern(input)
+		if err != nil {
+			t.Errorf("ParseIdentityPattern(%q) failed: %v", input, err)
+			continue
+		}
+		if pattern != expected {
+			t.Errorf("ParseIdentityPattern(%q) = %+v, expected %+v", input, pattern, expected)
+		}
+	}
+
+	pattern, _ := internal.ParseIdentityPattern("ME@example.com")
+	if !pattern.Matches(internal.Identity{Name: "Anyone", Email: "me@example.com"}) {
+		t.Error("expected email pattern to match case-insensitively and regardless of name")
+	}
+	if pattern.Matches(internal.Identity{Name: "Anyone", Email: "other@example.com"}) {
+		t.Error("expected email pattern not to match another email")
+	}
+
+	if _, err := internal.ParseIdentityPattern(" "); err == nil {
+		t.Error("expected error for empty identity")
+	}
+}
+
+// TestParseRawCommit tests that commit objects are split into their parts.
+func TestParseRawCommit(t *testing.T) {
+	raw := "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n" +
+		"parent aaaa\n" +
+		"parent bbbb\n" +
+		"author Me <me@example.com> 1700000000 +0100\n" +
+		"committer GitHub <noreply@github.com> 1700000100 -0500\n" +
+		"gpgsig -----BEGIN PGP SIGNATURE-----\n" +
+		" abc\n" +
+		" -----END PGP SIGNATURE-----\n" +
+		"\n" +
+		"Subject\n\nBody\n"
+
+	commit, err := internal.ParseRawCommit("cccc", raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(commit.Parents) != 2 || commit.Parents[1] != "bbbb" {
+		t.Errorf("expected two parents, got %v", commit.Parents)
+	}
+	if commit.Author.Email != "me@example.com" || commit.Author.Date != "1700000000 +0100" {
+		t.Errorf("unexpected author %+v", commit.Author)
+	}
+	if commit.Committer.Name != "GitHub" || commit.Committer.Date != "1700000100 -0500" {
+		t.Errorf("unexpected committer %+v", commit.Committer)
+	}
+	if commit.Message != "Subject\n\nBody\n" {
+		t.Errorf("unexpected message %q", commit.Message)
+	}
+	if !commit.Signed {
+		t.Error("expected the commit to be signed")
+	}
+}
+
+// TestRewriteHistory tests that only unpushed commits are rewritten, merges included, and a backup is kept.
+func TestRewriteHistory(t *testing.T) {
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "gitconfig"))
+
+	root := t.TempDir()
+	remote := filepath.Join(root, "remote.git")
+	repo := filepath.Join(root, "repo")
+
+	gitOutput(t, root, "init", "-q", "--bare", remote)
+	initRepo(t, repo, remote)
+
+	me := internal.Identity{Name: "Me", Email: "me@example.com"}
+	other := internal.Identity{Name: "Other", Email: "other@example.com"}
+	work := models.ProfileConfig{ProfileName: "work", Name: "Work", Email: "work@acme.com"}
+
+	commitAs(t, repo, "pushed", me, me)
+	gitOutput(t, repo, "push", "-q", "origin", "HEAD:refs/heads/main")
+	gitOutput(t, repo, "fetch", "-q", "origin")
+
+	commitAs(t, repo, "mine", me, me)
+	commitAs(t, repo, "theirs", other, other)
+	base := gitOutput(t, repo, "rev-parse", "HEAD")
+	side := gitOutput(t, repo, "-c", "user.name=Other", "-c", "user.email=other@example.com", "commit-tree", base+"^{tree}", "-p", base, "-m", "side")
+	gitOutput(t, repo, "-c", "user.name=Me", "-c", "user.email=me@example.com", "merge", "-q", "--no-ff", "-m", "merge", side)
+
+	oldHead := gitOutput(t, repo, "rev-parse", "HEAD")
+	oldDate := gitOutput(t, repo, "log", "-1", "--format=%ad", "HEAD~1")
+
+	pattern, _ := internal.ParseIdentityPattern("me@example.com")
+	result, err := internal.RewriteHistory(repo, internal.HistoryRewrite{
+		Rewrite: internal.ReplaceIdentity(pattern, work),
+	})
+	if err != nil {
+		t.Fatalf("failed to rewrite history: %v", err)
+	}
+
+	if len(result.Changed) != 2 {
+		t.Errorf("expected 2 changed commits (mine and merge), got %d", len(result.Changed))
+	}
+	// theirs and side descend from mine, so they get new hashes as well
+	if result.Rewritten != 4 {
+		t.Errorf("expected 4 rewritten commits, got %d", result.Rewritten)
+	}
+
+	authors := gitOutput(t, repo, "log", "--first-parent", "--format=%ae")
+	expected := "work@acme.com\nother@example.com\nwork@acme.com\nme@example.com"
+	if authors != expected {
+		t.Errorf("expected authors\n%s\ngot\n%s", expected, authors)
+	}
+
+	if parents := strings.Fields(gitOutput(t, repo, "log", "-1", "--format=%P")); len(parents) != 2 {
+		t.Errorf("expected merge to keep both parents, got %v", parents)
+	}
+	if date := gitOutput(t, repo, "log", "-1", "--format=%ad", "HEAD~1"); date != oldDate {
+		t.Errorf("expected date %s to be kept, got %s", oldDate, date)
+	}
+	if backup := gitOutput(t, repo, "rev-parse", result.BackupRef); backup != oldHead {
+		t.Errorf("expected backup ref to point at %s, got %s", oldHead, backup)
+	}
+}
+
+// TestRewriteHistoryRefusesPublished tests that pushed commits are only rewritten with Force.
+func TestRewriteHistoryRefusesPublished(t *testing.T) {
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "gitconfig"))
+
+	root := t.TempDir()
+	remote := filepath.Join(root, "remote.git")
+	repo := filepath.Join(root, "repo")
+
+	gitOutput(t, root, "init", "-q", "--bare", remote)
+	initRepo(t, repo, remote)
+
+	me := internal.Identity{Name: "Me", Email: "me@example.com"}
+	work := models.ProfileConfig{ProfileName: "work", Name: "Work", Email: "work@acme.com"}
+
+	commitAs(t, repo, "first", me, me)
+	commitAs(t, repo, "second", me, me)
+	gitOutput(t, repo, "push", "-q", "origin", "HEAD:refs/heads/main")
+	gitOutput(t, repo, "fetch", "-q", "origin")
+
+	pattern, _ := internal.ParseIdentityPattern("me@example.com")
+	rewrite := internal.HistoryRewrite{Range: "HEAD", Rewrite: internal.ReplaceIdentity(pattern, work)}
+
+	if _, err := internal.RewriteHistory(repo, rewrite); err == nil {
+		t.Fatal("expected rewriting pushed commits to be refused")
+	}
+	if author := gitOutput(t, repo, "log", "-1", "--format=%ae"); author != me.Email {
+		t.Errorf("expected history to be untouched, got author %s", author)
+	}
+
+	rewrite.Force = true
+	result, err := internal.RewriteHistory(repo, rewrite)
+	if err != nil {
+		t.Fatalf("expected forced rewrite to work: %v", err)
+	}
+	if result.Rewritten != 2 {
+		t.Errorf("expected 2 rewritten commits, got %d", result.Rewritten)
+	}
+
+	if _, err := internal.RewriteHistory(repo, internal.HistoryRewrite{
+		Range:   "HEAD~1",
+		Rewrite: internal.ReplaceIdentity(pattern, work),
+	}); err == nil {
+		t.Error("expected range not ending at HEAD to be refused")
+	}
+
+	output := filepath.Join(root, "output")
+	if _, err := internal.RewriteHistory(repo, internal.HistoryRewrite{
+		Range:   "--output=" + output + " HEAD",
+		Rewrite: internal.ReplaceIdentity(pattern, work),
+		Force:   true,
+	}); err == nil {
+		t.Error("expected range with an option to be refused")
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("expected the option not to reach git, got %v", err)
+	}
+}
+
+// createSSHSigningKey creates an SSH key to sign commits with and returns the path of its public key.
+// The test is skipped if ssh-keygen isn't available.
+func createSSHSigningKey(t *testing.T) string {
+	t.Helper()
+
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen not available")
+	}
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	if output, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", keyPath).CombinedOutput(); err != nil {
+		t.Fatalf("failed to create key: %v: %s", err, output)
+	}
+	return keyPath + ".pub"
+}
+
+// TestRewriteHistorySigned tests that signed descendants losing their signature are reported and need Force.
+func TestRewriteHistorySigned(t *testing.T) {
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "gitconfig"))
+	keyPath := createSSHSigningKey(t)
+
+	repo := filepath.Join(t.TempDir(), "repo")
+	initRepo(t, repo, "")
+
+	me := internal.Identity{Name: "Me", Email: "me@example.com"}
+	work := models.ProfileConfig{ProfileName: "work", Name: "Work", Email: "work@acme.com"}
+
+	commitAs(t, repo, "mine", me, me)
+	gitOutput(t, repo, "-c", "user.name=Other", "-c", "user.email=other@example.com", "-c", "gpg.format=ssh",
+		"-c", "user.signingkey="+keyPath, "commit", "-q", "--allow-empty", "-S", "-m", "theirs")
+	signed := gitOutput(t, repo, "rev-parse", "HEAD")
+
+	pattern, _ := internal.ParseIdentityPattern("me@example.com")
+	rewrite := internal.HistoryRewrite{Range: "HEAD", Rewrite: internal.ReplaceIdentity(pattern, work), DryRun: true}
+
+	result, err := internal.RewriteHistory(repo, rewrite)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Unsigned) != 1 || result.Unsigned[0] != signed {
+		t.Errorf("expected the dry run to report %s losing its signature, got %v", signed, result.Unsigned)
+	}
+
+	rewrite.DryRun = false
+	if _, err := internal.RewriteHistory(repo, rewrite); err == nil || !strings.Contains(err.Error(), signed) {
+		t.Fatalf("expected dropping the signature of %s to be refused, got %v", signed, err)
+	}
+	if head := gitOutput(t, repo, "rev-parse", "HEAD"); head != signed {
+		t.Errorf("expected history to be untouched, got %s", head)
+	}
+
+	rewrite.Force = true
+	if result, err = internal.RewriteHistory(repo, rewrite); err != nil {
+		t.Fatalf("expected forced rewrite to work: %v", err)
+	}
+	if len(result.Unsigned) != 1 || gitOutput(t, repo, "log", "-1", "--format=%G?") != "N" {
+		t.Errorf("expected the signature to be dropped and reported, got %v", result.Unsigned)
+	}
+}
+
+// TestAmendLastCommits tests that only the last commits get the new identity, signed if a key is given.
+func TestAmendLastCommits(t *testing.T) {
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "gitconfig"))
+
+	repo := filepath.Join(t.TempDir(), "repo")
+	initRepo(t, repo, "")
+
+	me := internal.Identity{Name: "Me", Email: "me@example.com"}
+	for _, message := range []string{"first", "second", "third"} {
+		commitAs(t, repo, message, me, me)
+	}
+
+	if _, err := internal.LastCommitsRange(repo, 4); err == nil {
+		t.Error("expected error when amending more commits than there are")
+	}
+
+	revRange, err := internal.LastCommitsRange(repo, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	work := models.ProfileConfig{ProfileName: "work", Name: "Work", Email: "work@acme.com"}
+
+	if _, err := exec.LookPath("ssh-keygen"); err == nil {
+		keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+		if output, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", keyPath).CombinedOutput(); err != nil {
+			t.Fatalf("failed to create key: %v: %s", err, output)
+		}
+		work.SigningKey = keyPath + ".pub"
+		work.SigningFormat = "ssh"
+		work.SignCommits = true
+		// the format of the profile wins over the one of the repository
+		gitOutput(t, repo, "config", "gpg.format", "x509")
+	}
+
+	result, err := internal.RewriteHistory(repo, internal.HistoryRewrite{
+		Range:       revRange,
+		FirstParent: true,
+		Rewrite:     internal.SetIdentity(work, work.SigningKey != ""),
+		Signing:     work,
+	})
+	if err != nil {
+		t.Fatalf("failed to amend: %v", err)
+	}
+	if result.Rewritten != 2 {
+		t.Errorf("expected 2 rewritten commits, got %d", result.Rewritten)
+	}
+
+	identities := gitOutput(t, repo, "log", "--format=%an <%ae> | %cn <%ce>")
+	expected := "Work <work@acme.com> | Work <work@acme.com>\n" +
+		"Work <work@acme.com> | Work <work@acme.com>\n" +
+		"Me <me@example.com> | Me <me@example.com>"
+	if identities != expected {
+		t.Errorf("expected identities\n%s\ngot\n%s", expected, identities)
+	}
+
+	if work.SigningKey != "" {
+		if raw := gitOutput(t, repo, "cat-file", "commit", "HEAD"); !strings.Contains(raw, "BEGIN SSH SIGNATURE") {
+			t.Error("expected amended commit to be signed with the SSH key")
+		}
+	}
+}
+
+// TestAmendSignedWithoutKey tests that amending signed commits without a key to sign them again needs Force.
+func TestAmendSignedWithoutKey(t *testing.T) {
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "gitconfig"))
+	keyPath := createSSHSigningKey(t)
+
+	repo := filepath.Join(t.TempDir(), "repo")
+	initRepo(t, repo, "")
+	gitOutput(t, repo, "-c", "user.name=Me", "-c", "user.email=me@example.com", "-c", "gpg.format=ssh",
+		"-c", "user.signingkey="+keyPath, "commit", "-q", "--allow-empty", "-S", "-m", "signed")
+	signed := gitOutput(t, repo, "rev-parse", "HEAD")
+
+	work := models.ProfileConfig{ProfileName: "work", Name: "Work", Email: "work@acme.com"}
+	rewrite := internal.HistoryRewrite{Range: "HEAD", Rewrite: internal.SetIdentity(work, false), Signing: work}
+
+	if _, err := internal.RewriteHistory(repo, rewrite); err == nil {
+		t.Fatal("expected dropping the signature to be refused")
+	}
+	if head := gitOutput(t, repo, "rev-parse", "HEAD"); head != signed {
+		t.Errorf("expected history to be untouched, got %s", head)
+	}
+
+	rewrite.Force = true
+	result, err := internal.RewriteHistory(repo, rewrite)
+	if err != nil {
+		t.Fatalf("expected forced amend to work: %v", err)
+	}
+	if len(result.Unsigned) != 1 || result.Unsigned[0] != signed {
+		t.Errorf("expected %s to be reported as unsigned, got %v", signed, result.Unsigned)
+	}
+}
@@ -0,0 +1,194 @@
+// Package test
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Shieldine/git-profile/internal"
+	"github.com/Shieldine/git-profile/models"
+)
+
+// TestRenderHook tests that hooks run the command and chain previous hooks.
+func TestRenderHook(t *testing.T) {
+	hook := internal.RenderHook("pre-commit", "git-profile verify --hook", false)
+
+	if !strings.HasPrefix(hook, "#!/bin/sh\n") {
+		t.Errorf("expected shebang, got %q", hook)
+	}
+	if !strings.Contains(hook, "git-profile verify --hook || exit $?") {
+		t.Errorf("expected hook to run the command, got %q", hook)
+	}
+	if !strings.Contains(hook, "pre-commit.git-profile-chained") {
+		t.Errorf("expected hook to chain the previous hook, got %q", hook)
+	}
+	if strings.Contains(hook, "repo_hook") {
+		t.Errorf("expected repository hook not to be chained, got %q", hook)
+	}
+
+	globalHook := internal.RenderHook("pre-commit", "git-profile verify --hook", true)
+	if !strings.Contains(globalHook, "--git-common-dir)/hooks/pre-commit") {
+		t.Errorf("expected global hook to chain the repository hook, got %q", globalHook)
+	}
+}
+
+// TestGetExecutableCommand tests that hooks pass the config file on to git-profile.
+func TestGetExecutableCommand(t *testing.T) {
+	command := internal.GetExecutableCommand("/home/me/my configs/config.toml")
+	if !strings.HasSuffix(command, " --config '/home/me/my configs/config.toml'") {
+		t.Errorf("expected the quoted config file to be passed, got %q", command)
+	}
+	if command := internal.GetExecutableCommand(""); strings.Contains(command, "--config") {
+		t.Errorf("expected no config file to be passed, got %q", command)
+	}
+}
+
+// TestInstallHookChaining tests that existing hooks are kept on install and restored on uninstall.
+func TestInstallHookChaining(t *testing.T) {
+	hooksDir := t.TempDir()
+	hookPath := filepath.Join(hooksDir, "pre-commit")
+	existing := "#!/bin/sh\necho existing\n"
+
+	if err := os.WriteFile(hookPath, []byte(existing), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	hook := internal.RenderHook("pre-commit", "true", false)
+	if err := internal.InstallHook(hooksDir, "pre-commit", hook); err != nil {
+		t.Fatalf("failed to install hook: %v", err)
+	}
+
+	chained, err := os.ReadFile(hookPath + ".git-profile-chained")
+	if err != nil || string(chained) != existing {
+		t.Errorf("expected existing hook to be chained, got %q (%v)", chained, err)
+	}
+	if !internal.IsManagedHook(hookPath) {
+		t.Error("expected installed hook to be managed")
+	}
+
+	// installing again must not chain the managed hook itself
+	if err := internal.InstallHook(hooksDir, "pre-commit", hook); err != nil {
+		t.Fatalf("failed to reinstall hook: %v", err)
+	}
+	chained, _ = os.ReadFile(hookPath + ".git-profile-chained")
+	if string(chained) != existing {
+		t.Errorf("expected chained hook to be untouched by reinstall, got %q", chained)
+	}
+
+	if err := internal.UninstallHook(hooksDir, "pre-commit"); err != nil {
+		t.Fatalf("failed to uninstall hook: %v", err)
+	}
+
+	restored, err := os.ReadFile(hookPath)
+	if err != nil || string(restored) != existing {
+		t.Errorf("expected existing hook to be restored, got %q (%v)", restored, err)
+	}
+	if _, err := os.Stat(hookPath + ".git-profile-chained"); !os.IsNotExist(err) {
+		t.Error("expected chained hook to be gone after uninstall")
+	}
+}
+
+// TestUninstallForeignHook tests that hooks not written by git-profile are left alone.
+func TestUninstallForeignHook(t *testing.T) {
+	hooksDir := t.TempDir()
+	hookPath := filepath.Join(hooksDir, "pre-commit")
+
+	if err := os.WriteFile(hookPath, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := internal.UninstallHook(hooksDir, "pre-commit"); err == nil {
+		t.Error("expected error when uninstalling a foreign hook")
+	}
+	if _, err := os.Stat(hookPath); err != nil {
+		t.Errorf("expected foreign hook to be kept, got %v", err)
+	}
+}
+
+// TestParseVerifyPolicy tests that verify policies are validated.
+func TestParseVerifyPolicy(t *testing.T) {
+	for _, policy := range []string{"refuse", "fix", "warn", "FIX"} {
+		if _, err := internal.ParseVerifyPolicy(policy); err != nil {
+			t.Errorf("expected policy %s to be valid, got %v", policy, err)
+		}
+	}
+
+	if _, err := internal.ParseVerifyPolicy("ignore"); err == nil {
+		t.Error("expected error for unknown policy")
+	}
+}
+
+// TestVerifyIdentity tests that the effective identity is compared with the expected profiles.
+func TestVerifyIdentity(t *testing.T) {
+	profiles := []models.ProfileConfig{
+		{ProfileName: "work", Name: "Work", Email: "work@acme.com", Origin: "github.com/acme/*"},
+		{ProfileName: "personal", Name: "Me", Email: "me@example.com", Origin: "github.com"},
+	}
+
+	remote, err := internal.ParseRemoteURL("git@github.com:acme/app.git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	remotes := []internal.RepoRemote{{Name: "origin", URL: remote}}
+
+	t.Setenv("GIT_AUTHOR_NAME", "Work")
+	t.Setenv("GIT_AUTHOR_EMAIL", "Work@ACME.com")
+
+	result, err := currentRepo.VerifyIdentity(profiles, remotes, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.OK() || result.Matched.ProfileName != "work" {
+		t.Errorf("expected identity to match profile work, got %+v", result)
+	}
+
+	t.Setenv("GIT_AUTHOR_NAME", "Me")
+	t.Setenv("GIT_AUTHOR_EMAIL", "me@example.com")
+
+	result, err = currentRepo.VerifyIdentity(profiles, remotes, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.OK() {
+		t.Errorf("expected personal identity to be rejected for acme repository, got %+v", result)
+	}
+
+	result, err = currentRepo.VerifyIdentity(profiles, nil, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.HasExpectation() || !result.OK() {
+		t.Errorf("expected repository without remotes to pass, got %+v", result)
+	}
+}
+
+// TestIsNullRevision tests that the previous HEAD of a fresh clone is recognized for SHA-1 and SHA-256.
+func TestIsNullRevision(t *testing.T) {
+	tests := map[string]bool{
+		strings.Repeat("0", 40):                    true,
+		strings.Repeat("0", 64):                    true,
+		"a94a8fe5ccb19ba61c4c0873d391e987982fbbd3": false,
+		"": false,
+	}
+
+	for rev, expected := range tests {
+		if got := internal.IsNullRevision(rev); got != expected {
+			t.Errorf("IsNullRevision(%q) = %v, expected %v", rev, got, expected)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+// Package test
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Shieldine/git-profile/internal"
+	"github.com/Shieldine/git-profile/models"
+)
+
+// TestBuildIncludeRules tests that profiles are turned into includeIf rules and ambiguous origins are skipped.
+func TestBuildIncludeRules(t *testing.T) {
+	profiles := []models.ProfileConfig{
+		{ProfileName: "work", Origin: "gitlab.company.com"},
+		{ProfileName: "personal", Origin: "github.com"},
+		{ProfileName: "other", Origin: "github.com"},
+		{ProfileName: "no-origin"},
+	}
+
+	rules, skipped := internal.BuildIncludeRules(profiles, "/includes")
+
+	if len(skipped) != 1 || !strings.Contains(skipped[0], "github.com") {
+		t.Errorf("expected github.com to be skipped, got %v", skipped)
+	}
+
+	if len(rules) == 0 {
+		t.Fatal("expected rules for gitlab.company.com, got none")
+	}
+	for _, rule := range rules {
+		if rule.ProfileName != "work" {
+			t.Errorf("expected only rules for profile work, got %s", rule.ProfileName)
+		}
+		if !strings.Contains(rule.Condition, "gitlab.company.com") {
+			t.Errorf("expected condition to contain origin, got %s", rule.Condition)
+		}
+	}
+}
+
+// TestBuildIncludeRulesOrder tests that path rules come after origin rules, so git lets them win.
+func TestBuildIncludeRulesOrder(t *testing.T) {
+	profiles := []models.ProfileConfig{
+		{ProfileName: "acme", Rules: []models.Rule{{Path: "~/work/acme/**"}}},
+		{ProfileName: "personal", Origin: "github.com"},
+		{ProfileName: "mixed", Rules: []models.Rule{{Path: "~/mixed/", Origin: "github.com"}}},
+	}
+
+	rules, skipped := internal.BuildIncludeRules(profiles, "/includes")
+
+	if len(skipped) != 1 || !strings.Contains(skipped[0], "mixed") {
+		t.Errorf("expected rule of profile mixed to be skipped, got %v", skipped)
+	}
+
+	if len(rules) == 0 {
+		t.Fatal("expected rules, got none")
+	}
+	last := rules[len(rules)-1]
+	if last.ProfileName != "acme" || last.Condition != "gitdir:~/work/acme/**" {
+		t.Errorf("expected path rule of acme to come last, got %v", last)
+	}
+}
+
+// TestReplaceManagedBlock tests that only the managed block is rewritten.
+func TestReplaceManagedBlock(t *testing.T) {
+	userConfig := "[core]\n\teditor = vim\n"
+	block, err := internal.RenderIncludeBlock([]internal.IncludeRule{
+		{Condition: "hasconfig:remote.*.url:https://github.com/**", ProfileName: "work", Path: "/includes/work.gitconfig"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	withBlock := internal.ReplaceManagedBlock(userConfig, block)
+	if !strings.HasPrefix(withBlock, userConfig) {
+		t.Errorf("expected user config to be kept, got %q", withBlock)
+	}
+	if !strings.Contains(withBlock, `[includeIf "hasconfig:remote.*.url:https://github.com/**"]`) {
+		t.Errorf("expected includeIf block, got %q", withBlock)
+	}
+
+	resynced := internal.ReplaceManagedBlock(withBlock+"[alias]\n\tst = status\n", block)
+	if strings.Count(resynced, "[includeIf") != 1 {
+		t.Errorf("expected exactly one includeIf block after resync, got %q", resynced)
+	}
+	if !strings.Contains(resynced, "[alias]") {
+		t.Errorf("expected config added after the block to be kept, got %q", resynced)
+	}
+
+	removed := internal.ReplaceManagedBlock(resynced, "")
+	if strings.Contains(removed, "includeIf") || strings.Contains(removed, "git-profile") {
+		t.Errorf("expected managed block to be removed, got %q", removed)
+	}
+
+	if internal.ReplaceManagedBlock(userConfig, "") != userConfig {
+		t.Error("expected config without managed block to stay unchanged")
+	}
+}
+
+// TestSyncIncludes tests that git picks up a profile through the generated includeIf rules.
+func TestSyncIncludes(t *testing.T) {
+	store, cleanupConfig := setupTempConfig(t)
+	defer cleanupConfig()
+
+	tempDir, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	gitConfigPath := filepath.Join(t.TempDir(), "gitconfig")
+	if err := os.WriteFile(gitConfigPath, []byte("[core]\n\teditor = vim\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := store.AddProfile(models.ProfileConfig{ProfileName: "work", Name: "Work User", Email: "work@example.com", Origin: "example.com"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, _, err := internal.SyncIncludes(gitConfigPath, store.Dir(), store.GetAllProfiles()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cmd := exec.Command("git", "remote", "add", "origin", "git@example.com:team/repo.git")
+	cmd.Dir = tempDir
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd = exec.Command("git", "config", "--get", "user.email")
+	cmd.Dir = tempDir
+	cmd.Env = append(os.Environ(), "GIT_CONFIG_GLOBAL="+gitConfigPath)
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("expected user.email from include file: %v", err)
+	}
+	if strings.TrimSpace(string(output)) != "work@example.com" {
+		t.Errorf("expected user email to be work@example.com, got %s", output)
+	}
+
+	if err := internal.RemoveIncludes(gitConfigPath, store.Dir()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	content, err := os.ReadFile(gitConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "[core]\n\teditor = vim\n" {
+		t.Errorf("expected original git config after removal, got %q", content)
+	}
+}
+
+// TestRenderIncludeFileEscaping tests that values can't break out of their setting into other sections.
+func TestRenderIncludeFileEscaping(t *testing.T) {
+	email := "work@example.com\n[core]\n\tsshCommand = touch pwned"
+	content, err := internal.RenderIncludeFile(models.ProfileConfig{ProfileName: "work", Name: "Work\tUser", Email: email})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	includePath := filepath.Join(t.TempDir(), "work.gitconfig")
+	if err := os.WriteFile(includePath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := exec.Command("git", "config", "--file", includePath, "--get", "user.email").Output()
+	if err != nil {
+		t.Fatalf("expected user.email in include file: %v", err)
+	}
+	if strings.TrimSuffix(string(output), "\n") != email {
+		t.Errorf("expected email %q, got %q", email, output)
+	}
+
+	if err := exec.Command("git", "config", "--file", includePath, "--get", "core.sshCommand").Run(); err == nil {
+		t.Errorf("expected no core.sshCommand in include file, got %q", content)
+	}
+
+	if _, err := internal.RenderIncludeFile(models.ProfileConfig{ProfileName: "work", Name: "Work\x1bUser", Email: "work@example.com"}); err == nil {
+		t.Error("expected an error for a control character in the name")
+	}
+}
+
+// TestSyncIncludesProfileNames tests that profile names which aren't a single file name are rejected.
+func TestSyncIncludesProfileNames(t *testing.T) {
+	store, cleanupConfig := setupTempConfig(t)
+	defer cleanupConfig()
+
+	for _, name := range []string{"", ".", "..", "../evil", "work/evil", `work\evil`, "c:evil", "work\nevil"} {
+		err := store.AddProfile(models.ProfileConfig{ProfileName: name, Name: "Work User", Email: "work@example.com", Origin: "example.com"})
+		if err == nil {
+			t.Errorf("expected an error adding profile %q", name)
+		}
+	}
+	if len(store.GetAllProfiles()) != 0 {
+		t.Fatalf("expected no profiles to be added, got %v", store.GetAllProfiles())
+	}
+
+	profiles := []models.ProfileConfig{
+		{ProfileName: "../evil", Name: "Evil User", Email: "evil@example.com", Origin: "example.com"},
+	}
+
+	rules, skipped := internal.BuildIncludeRules(profiles, "/includes")
+	if len(rules) != 0 || len(skipped) != 1 {
+		t.Errorf("expected the profile to be skipped, got rules %v and skipped %v", rules, skipped)
+	}
+
+	gitConfigPath := filepath.Join(t.TempDir(), "gitconfig")
+	if _, _, err := internal.SyncIncludes(gitConfigPath, store.Dir(), profiles); err == nil {
+		t.Error("expected an error syncing a profile with an unsafe name")
+	}
+	if _, err := os.Stat(filepath.Join(store.Dir(), "evil.gitconfig")); !os.IsNotExist(err) {
+		t.Errorf("expected no include file outside the include directory, got %v", err)
+	}
+	if _, err := os.Stat(gitConfigPath); !os.IsNotExist(err) {
+		t.Errorf("expected git config to stay untouched, got %v", err)
+	}
+}
+
+// TestSyncIncludesKeepsOtherFiles tests that only the include files git-profile generated are replaced or removed.
+func TestSyncIncludesKeepsOtherFiles(t *testing.T) {
+	store, cleanupConfig := setupTempConfig(t)
+	defer cleanupConfig()
+
+	includeDir := internal.GetIncludeDir(store.Dir())
+	if err := os.MkdirAll(includeDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	important := filepath.Join(includeDir, "important.txt")
+	own := filepath.Join(includeDir, "own.gitconfig")
+	for _, path := range []string{important, own} {
+		if err := os.WriteFile(path, []byte("[alias]\n\tst = status\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gitConfigPath := filepath.Join(t.TempDir(), "gitconfig")
+	profiles := []models.ProfileConfig{{ProfileName: "work", Name: "Work User", Email: "work@example.com", Origin: "example.com"}}
+	for i := 0; i < 2; i++ {
+		if _, _, err := internal.SyncIncludes(gitConfigPath, store.Dir(), profiles); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(includeDir, "work.gitconfig")); err != nil {
+		t.Errorf("expected the include file of work, got %v", err)
+	}
+
+	profiles = append(profiles, models.ProfileConfig{ProfileName: "own", Name: "Own", Email: "own@example.com", Origin: "example.org"})
+	if _, _, err := internal.SyncIncludes(gitConfigPath, store.Dir(), profiles); err == nil {
+		t.Error("expected an error overwriting an include file git-profile didn't generate")
+	}
+
+	if err := internal.RemoveIncludes(gitConfigPath, store.Dir()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(includeDir, "work.gitconfig")); !os.IsNotExist(err) {
+		t.Errorf("expected the include file of work to be removed, got %v", err)
+	}
+	for _, path := range []string{important, own} {
+		if content, err := os.ReadFile(path); err != nil || !strings.Contains(string(content), "st = status") {
+			t.Errorf("expected %s to be kept, got %q, %v", path, content, err)
+		}
+	}
+}
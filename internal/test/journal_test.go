@@ -0,0 +1,221 @@
+// Package test
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Shieldine/git-profile/internal"
+	"github.com/Shieldine/git-profile/models"
+)
+
+// TestJournal tests that operations get increasing IDs and that undoing walks back through the journal.
+func TestJournal(t *testing.T) {
+	journal := internal.NewJournal(internal.GetJournalPath(t.TempDir()))
+
+	if _, err := journal.LastUndoable(); err == nil {
+		t.Error("expected nothing to undo in an empty journal")
+	}
+
+	for _, command := range []string{"add work", "set work"} {
+		if _, err := journal.Append(internal.Operation{Command: command}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	operations, err := journal.Operations()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(operations) != 2 || operations[0].ID != 1 || operations[1].ID != 2 || operations[1].Command != "set work" {
+		t.Fatalf("expected operations 1 and 2 in order, got %+v", operations)
+	}
+
+	for _, want := range []int{2, 1} {
+		operation, err := journal.LastUndoable()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if operation.ID != want {
+			t.Errorf("expected operation %d to be undone next, got %d", want, operation.ID)
+		}
+		if _, err := journal.Append(internal.Operation{Command: "undo", Undoes: operation.ID}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if _, err := journal.LastUndoable(); err == nil {
+		t.Error("expected nothing left to undo")
+	}
+}
+
+// TestUndo tests that undoing reverts both profiles and git config values, unless they were changed again since.
+func TestUndo(t *testing.T) {
+	repoDir, cleanupRepo := setupTestRepo(t)
+	defer cleanupRepo()
+	store, cleanupConfig := setupTempConfig(t)
+	defer cleanupConfig()
+
+	gitConfig := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"config", "--local"}, args...)...)
+		cmd.Dir = repoDir
+		output, _ := cmd.Output()
+		return strings.TrimSpace(string(output))
+	}
+	gitConfig("user.name", "Before")
+
+	recorder := &internal.Recorder{}
+	store.SetRecorder(recorder)
+	repo, err := internal.OpenRepo(repoDir, internal.GitSettings{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	profile := models.ProfileConfig{ProfileName: "work", Name: "Work", Email: "work@example.com", Origin: "github.com"}
+	if err := store.AddProfile(profile); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := repo.WithRecorder(recorder).ApplyProfile(profile, internal.ScopeLocal); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	changes := recorder.Changes()
+	if len(changes) != 3 {
+		t.Fatalf("expected the profile, user.name and user.email to be recorded, got %+v", changes)
+	}
+	if changes[1].Key != "user.name" || *changes[1].Old != "Before" || changes[1].Repo == "" {
+		t.Errorf("expected user.name to be recorded with its old value and repository, got %+v", changes[1])
+	}
+	if want, _ := filepath.EvalSymlinks(repoDir); want != "" {
+		if got, _ := filepath.EvalSymlinks(changes[1].Repo); got != want {
+			t.Errorf("expected repository %s, got %s", want, got)
+		}
+	}
+
+	operation := internal.Operation{ID: 1, Changes: changes}
+
+	gitConfig("user.email", "manual@example.com")
+	if err := internal.Undo(operation, store.Path(), internal.GitSettings{}, nil, false); err == nil {
+		t.Error("expected a value changed since to block the undo")
+	}
+	if gitConfig("user.name") != "Work" || store.GetProfileByName("work").ProfileName == "" {
+		t.Error("expected nothing to be reverted after a conflict")
+	}
+
+	if err := internal.Undo(operation, store.Path(), internal.GitSettings{}, nil, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := gitConfig("user.name"); got != "Before" {
+		t.Errorf("expected user.name to be Before again, got %q", got)
+	}
+	if got := gitConfig("user.email"); got != "" {
+		t.Errorf("expected user.email to be unset again, got %q", got)
+	}
+	if err := store.Load(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if store.GetProfileByName("work").ProfileName != "" {
+		t.Error("expected the added profile to be removed again")
+	}
+}
+
+// TestUndoRepeatedChanges tests that a key changed several times by one operation is set back to its first value.
+func TestUndoRepeatedChanges(t *testing.T) {
+	repoDir, cleanupRepo := setupTestRepo(t)
+	defer cleanupRepo()
+	store, cleanupConfig := setupTempConfig(t)
+	defer cleanupConfig()
+
+	profile := models.ProfileConfig{ProfileName: "work", Name: "Work", Email: "work@example.com", Origin: "github.com"}
+	if err := store.AddProfile(profile); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	recorder := &internal.Recorder{}
+	store.SetRecorder(recorder)
+	repo, err := internal.OpenRepo(repoDir, internal.GitSettings{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	repo = repo.WithRecorder(recorder)
+
+	for _, name := range []string{"First", "Second"} {
+		if err := repo.SetUserName(name, internal.ScopeLocal); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		profile.Name = name
+		if err := store.EditProfile("work", profile); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	operation := internal.Operation{ID: 1, Changes: recorder.Changes()}
+	if len(operation.Changes) != 4 {
+		t.Fatalf("expected every change to be recorded, got %+v", operation.Changes)
+	}
+
+	if err := internal.Undo(operation, store.Path(), internal.GitSettings{}, nil, false); err != nil {
+		t.Fatalf("expected no conflict for a key changed twice, got %v", err)
+	}
+
+	cmd := exec.Command("git", "config", "--local", "user.name")
+	cmd.Dir = repoDir
+	if output, _ := cmd.Output(); strings.TrimSpace(string(output)) != "" {
+		t.Errorf("expected user.name to be unset again, got %q", output)
+	}
+	if err := store.Load(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := store.GetProfileByName("work").Name; got != "Work" {
+		t.Errorf("expected the profile name to be Work again, got %q", got)
+	}
+}
+
+// TestUndoMissingFile tests that a config file removed since the operation blocks the undo and isn't created again.
+func TestUndoMissingFile(t *testing.T) {
+	setupLockDir(t)
+	store, cleanupConfig := setupTempConfig(t)
+	defer cleanupConfig()
+
+	repoPath := filepath.Join(t.TempDir(), internal.RepoConfigFile)
+	layered, err := internal.NewLayeredStore("", store.Path(), repoPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	recorder := &internal.Recorder{}
+	layered.SetRecorder(recorder)
+
+	profile := models.ProfileConfig{ProfileName: "team", Name: "Team", Email: "team@example.com", Origin: "github.com"}
+	if err := layered.AddProfileTo(internal.LayerRepo, profile); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	operation := internal.Operation{ID: 1, Changes: recorder.Changes()}
+
+	if err := os.Remove(repoPath); err != nil {
+		t.Fatal(err)
+	}
+
+	err = internal.Undo(operation, store.Path(), internal.GitSettings{}, nil, false)
+	if err == nil || !strings.Contains(err.Error(), "no longer exists") {
+		t.Errorf("expected the missing file to block the undo, got %v", err)
+	}
+	if _, err := os.Stat(repoPath); !os.IsNotExist(err) {
+		t.Errorf("expected the removed file not to be created again, got %v", err)
+	}
+}
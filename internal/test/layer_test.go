@@ -0,0 +1,237 @@
+// Package test
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Shieldine/git-profile/internal"
+	"github.com/Shieldine/git-profile/models"
+)
+
+// setupLockDir keeps the lock files of the system and repo layers in a temporary cache directory.
+func setupLockDir(t *testing.T) string {
+	cacheDir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cacheDir)
+	t.Setenv("LocalAppData", cacheDir)
+	t.Setenv("HOME", cacheDir)
+	return cacheDir
+}
+
+// writeLayer writes a config layer into dir and returns its path.
+func writeLayer(t *testing.T, dir string, name string, content string) string {
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestLayeredStore tests that layers are merged by precedence and that changes go to the layer of the profile.
+func TestLayeredStore(t *testing.T) {
+	cacheDir := setupLockDir(t)
+	tempDir := t.TempDir()
+	systemPath := writeLayer(t, tempDir, "system.toml", `verify_policy = "refuse"
+resolve_ssh_hosts = true
+
+[[profiles]]
+  profile_name = "corp"
+  email = "me@corp.com"
+
+[[profiles]]
+  profile_name = "work"
+  email = "system@work.com"
+`)
+	userPath := writeLayer(t, tempDir, "user.toml", `remote_order = ["upstream"]
+
+[[profiles]]
+  profile_name = "work"
+  email = "user@work.com"
+`)
+	repoDir := filepath.Join(tempDir, "repo")
+	if err := os.Mkdir(repoDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	repoPath := writeLayer(t, repoDir, internal.RepoConfigFile, `verify_policy = "warn"
+resolve_ssh_hosts = false
+
+[[profiles]]
+  profile_name = "team"
+  email = "me@team.com"
+`)
+
+	store, err := internal.NewLayeredStore(systemPath, userPath, repoPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := store.GetProfileByName("work").Email; got != "user@work.com" {
+		t.Errorf("expected the user layer to override work, got %s", got)
+	}
+	if store.LayerOf("corp") != internal.LayerSystem || store.LayerOf("work") != internal.LayerUser {
+		t.Errorf("expected corp from system and work from user, got %s and %s", store.LayerOf("corp"), store.LayerOf("work"))
+	}
+	if store.LayerOf("team") != internal.LayerRepo {
+		t.Errorf("expected team from repo, got %s", store.LayerOf("team"))
+	}
+	if len(store.GetAllProfiles()) != 3 {
+		t.Errorf("expected 3 merged profiles, got %v", store.GetAllProfiles())
+	}
+
+	conf := store.Config()
+	if conf.VerifyPolicy != "refuse" || !conf.ResolveSSHHosts || strings.Join(conf.RemoteOrder, ",") != "upstream" {
+		t.Errorf("expected each setting from the highest layer setting it and none from the repo layer, got %+v", conf)
+	}
+
+	if entries, _ := os.ReadDir(cacheDir); len(entries) != 0 {
+		t.Errorf("expected reading the layers to take no lock outside the user layer, found %v", entries)
+	}
+
+	if err := store.EditProfile("corp", models.ProfileConfig{ProfileName: "corp", Email: "new@corp.com"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if content, _ := os.ReadFile(systemPath); !strings.Contains(string(content), "new@corp.com") {
+		t.Errorf("expected corp to be changed in the system layer, got %s", content)
+	}
+
+	if err := store.AddProfileTo(internal.LayerRepo, models.ProfileConfig{ProfileName: "shared"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if store.LayerOf("shared") != internal.LayerRepo {
+		t.Errorf("expected shared in the repo layer, got %s", store.LayerOf("shared"))
+	}
+	entries, err := os.ReadDir(repoDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected no lock files or backups in the repository, found %v", entries)
+	}
+
+	if err := store.DeleteProfile("work"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if store.LayerOf("work") != internal.LayerSystem || store.GetProfileByName("work").Email != "system@work.com" {
+		t.Errorf("expected the system profile work to show again, got %+v", store.GetProfileByName("work"))
+	}
+}
+
+// TestLayeredStoreRepoLayer tests that a repository's config file can't shadow the profiles of the user.
+func TestLayeredStoreRepoLayer(t *testing.T) {
+	tempDir := t.TempDir()
+	userPath := writeLayer(t, tempDir, "user.toml", `[[profiles]]
+  profile_name = "work"
+  name = "Work User"
+  email = "user@work.com"
+  origin = "github.com"
+`)
+	repoPath := writeLayer(t, tempDir, internal.RepoConfigFile, `[[profiles]]
+  profile_name = "work"
+  name = "Attacker"
+  email = "attacker@example.com"
+  origin = "github.com"
+  ssh_key = "/tmp/attacker"
+`)
+
+	store, err := internal.NewLayeredStore("", userPath, repoPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	profile := store.GetProfileByName("work")
+	if profile.Email != "user@work.com" || profile.SSHKey != "" || store.LayerOf("work") != internal.LayerUser {
+		t.Errorf("expected the user profile work to win over the repo layer, got %+v from %s", profile, store.LayerOf("work"))
+	}
+	if len(store.GetAllProfiles()) != 1 {
+		t.Errorf("expected the repo profile to be hidden, got %v", store.GetAllProfiles())
+	}
+
+	if err := store.AddProfileTo(internal.LayerRepo, models.ProfileConfig{ProfileName: "work"}); err == nil {
+		t.Error("expected an error adding a profile that exists in another layer")
+	}
+}
+
+// TestLayeredStoreForRepo tests that each repository only sees its own repo layer.
+func TestLayeredStoreForRepo(t *testing.T) {
+	tempDir := t.TempDir()
+	userPath := writeLayer(t, tempDir, "user.toml", `[[profiles]]
+  profile_name = "work"
+  email = "user@work.com"
+`)
+
+	current := filepath.Join(tempDir, "current")
+	other := filepath.Join(tempDir, "other")
+	for dir, name := range map[string]string{current: "team", other: "vendor"} {
+		if err := os.Mkdir(dir, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		writeLayer(t, dir, internal.RepoConfigFile, "[[profiles]]\n  profile_name = \""+name+"\"\n")
+	}
+
+	store, err := internal.NewLayeredStore("", userPath, filepath.Join(current, internal.RepoConfigFile))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	profileNames := func(workTree string) string {
+		profiles, err := internal.RepoProfiles(store)(workTree)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var names []string
+		for _, profile := range profiles {
+			names = append(names, profile.ProfileName)
+		}
+		return strings.Join(names, ",")
+	}
+
+	if got := profileNames(other); got != "vendor,work" {
+		t.Errorf("expected the profiles of the other repository and the user, got %s", got)
+	}
+	if got := profileNames(""); got != "work" {
+		t.Errorf("expected only the user profiles without a repository, got %s", got)
+	}
+	if got := profileNames(filepath.Join(tempDir, "missing")); got != "work" {
+		t.Errorf("expected only the user profiles for a repository without config, got %s", got)
+	}
+	if store.LayerOf("team") != internal.LayerRepo || store.LayerOf("vendor") != "" {
+		t.Errorf("expected the store itself to keep its repo layer, got %v", store.GetAllProfiles())
+	}
+}
+
+// TestLayeredStoreWithoutLayers tests that missing system and repo layers are left out and not created.
+func TestLayeredStoreWithoutLayers(t *testing.T) {
+	tempDir := t.TempDir()
+	systemPath := filepath.Join(tempDir, "system", "config.toml")
+
+	store, err := internal.NewLayeredStore(systemPath, filepath.Join(tempDir, "user", "config.toml"), "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := os.Stat(systemPath); !os.IsNotExist(err) {
+		t.Errorf("expected the system layer not to be created, got %v", err)
+	}
+	layers := store.Layers()
+	if len(layers) != 2 || layers[0].Exists || !layers[1].Exists {
+		t.Errorf("expected a missing system layer and the user layer, got %+v", layers)
+	}
+	if err := store.AddProfileTo(internal.LayerRepo, models.ProfileConfig{ProfileName: "shared"}); err == nil {
+		t.Error("expected adding to the repo layer outside a repository to fail")
+	}
+}
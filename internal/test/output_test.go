@@ -0,0 +1,93 @@
+// Package test
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/Shieldine/git-profile/cmd"
+	"github.com/Shieldine/git-profile/models"
+)
+
+// TestWriteResult tests that results are rendered in each output format.
+func TestWriteResult(t *testing.T) {
+	result := cmd.ProfilesResult{Profiles: []cmd.ListedProfile{
+		{ProfileConfig: models.ProfileConfig{ProfileName: "work", Name: "Work", Email: "work@acme.com", Origin: "github.com"}, Layer: "system"},
+	}}
+
+	var output bytes.Buffer
+	if err := cmd.WriteResult(&output, cmd.OutputJSON, result); err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded map[string][]map[string]any
+	if err := json.Unmarshal(output.Bytes(), &decoded); err != nil {
+		t.Fatalf("expected valid JSON, got %s: %v", output.String(), err)
+	}
+	if decoded["profiles"][0]["profile_name"] != "work" || decoded["profiles"][0]["layer"] != "system" {
+		t.Errorf("expected profile_name work in layer system, got %v", decoded)
+	}
+	if _, ok := decoded["profiles"][0]["signing_key"]; ok {
+		t.Error("expected unset signing key to be omitted")
+	}
+
+	output.Reset()
+	if err := cmd.WriteResult(&output, cmd.OutputYAML, result); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output.String(), "profile_name: work") || !strings.Contains(output.String(), "layer: system") {
+		t.Errorf("expected YAML with profile_name, got %s", output.String())
+	}
+
+	output.Reset()
+	if err := cmd.WriteResult(&output, cmd.OutputTable, result); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(output.String(), "Profile work:\n") || !strings.Contains(output.String(), "  Layer: system\n") {
+		t.Errorf("expected table output, got %s", output.String())
+	}
+}
+
+// TestWriteErrorResult tests that errors carry their exit code in machine-readable output.
+func TestWriteErrorResult(t *testing.T) {
+	var output bytes.Buffer
+	err := cmd.WriteResult(&output, cmd.OutputJSON, cmd.ErrorResult{Error: "not a git repository", ExitCode: cmd.ExitNotRepo})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded cmd.ErrorResult
+	if err := json.Unmarshal(output.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.ExitCode != 3 || decoded.Error != "not a git repository" {
+		t.Errorf("unexpected error result %+v", decoded)
+	}
+}
+
+// TestValidateOutputFormat tests the accepted values of --output.
+func TestValidateOutputFormat(t *testing.T) {
+	for _, format := range []string{"table", "json", "yaml"} {
+		if err := cmd.ValidateOutputFormat(format); err != nil {
+			t.Errorf("expected %s to be valid: %v", format, err)
+		}
+	}
+	if err := cmd.ValidateOutputFormat("xml"); err == nil {
+		t.Error("expected xml to be invalid")
+	}
+}
@@ -0,0 +1,170 @@
+// Package test
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Shieldine/git-profile/internal"
+	"github.com/Shieldine/git-profile/models"
+)
+
+// initRepo creates a repository at path with an optional origin remote.
+func initRepo(t *testing.T, path string, remoteURL string) {
+	t.Helper()
+
+	if output, err := exec.Command("git", "init", "-q", path).CombinedOutput(); err != nil {
+		t.Fatalf("failed to init %s: %v: %s", path, err, output)
+	}
+	if remoteURL == "" {
+		return
+	}
+	if output, err := exec.Command("git", "-C", path, "remote", "add", "origin", remoteURL).CombinedOutput(); err != nil {
+		t.Fatalf("failed to add remote to %s: %v: %s", path, err, output)
+	}
+}
+
+// TestFindWorkTrees tests that nested repositories and .git files are found.
+func TestFindWorkTrees(t *testing.T) {
+	root := t.TempDir()
+
+	initRepo(t, filepath.Join(root, "app"), "")
+	initRepo(t, filepath.Join(root, "app", "vendor", "lib"), "")
+	initRepo(t, filepath.Join(root, "group", "service"), "")
+
+	worktree := filepath.Join(root, "worktree")
+	if err := os.MkdirAll(worktree, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(worktree, ".git"), []byte("gitdir: /elsewhere\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(root, "plain", "dir"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := internal.FindWorkTrees(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		filepath.Join(root, "app"),
+		filepath.Join(root, "app", "vendor", "lib"),
+		filepath.Join(root, "group", "service"),
+		worktree,
+	}
+	if len(found) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, found)
+	}
+	for i := range expected {
+		if found[i] != expected[i] {
+			t.Errorf("expected %s at position %d, got %s", expected[i], i, found[i])
+		}
+	}
+}
+
+// TestPlanRepos tests that each repository gets the right action.
+func TestPlanRepos(t *testing.T) {
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "gitconfig"))
+
+	profiles := []models.ProfileConfig{
+		{ProfileName: "work", Name: "Work", Email: "work@acme.com", Origin: "github.com/acme/*"},
+		{ProfileName: "lab1", Name: "Lab", Email: "lab@lab.org", Origin: "gitlab.com"},
+		{ProfileName: "lab2", Name: "Lab", Email: "lab2@lab.org", Origin: "gitlab.com"},
+	}
+
+	root := t.TempDir()
+	apply := filepath.Join(root, "apply")
+	unchanged := filepath.Join(root, "unchanged")
+	ambiguous := filepath.Join(root, "ambiguous")
+	none := filepath.Join(root, "none")
+
+	initRepo(t, apply, "git@github.com:acme/app.git")
+	initRepo(t, unchanged, "https://github.com/acme/lib")
+	initRepo(t, ambiguous, "git@gitlab.com:group/repo.git")
+	initRepo(t, none, "")
+
+	for key, value := range map[string]string{"user.name": "Work", "user.email": "work@acme.com"} {
+		if output, err := exec.Command("git", "-C", unchanged, "config", key, value).CombinedOutput(); err != nil {
+			t.Fatalf("failed to configure %s: %v: %s", key, err, output)
+		}
+	}
+
+	plans := internal.PlanRepos(internal.GitSettings{}, func(string) ([]models.ProfileConfig, error) { return profiles, nil }, []string{apply, unchanged, ambiguous, none})
+
+	expected := []internal.PlanAction{internal.PlanApply, internal.PlanUnchanged, internal.PlanAmbiguous, internal.PlanNoMatch}
+	for i, plan := range plans {
+		if plan.Err != nil {
+			t.Errorf("unexpected error for %s: %v", plan.Path, plan.Err)
+		}
+		if plan.Action != expected[i] {
+			t.Errorf("expected action %d for %s, got %d", expected[i], plan.Path, plan.Action)
+		}
+	}
+
+	if plans[0].Origin() != "github.com/acme/app" {
+		t.Errorf("expected origin github.com/acme/app, got %s", plans[0].Origin())
+	}
+	if plans[1].Current.Email != "work@acme.com" {
+		t.Errorf("expected current email work@acme.com, got %s", plans[1].Current.Email)
+	}
+	if plans[3].Origin() != "none" {
+		t.Errorf("expected no origin, got %s", plans[3].Origin())
+	}
+}
+
+// TestInvalidRemote tests that a remote that can't be parsed is skipped instead of failing the repository.
+func TestInvalidRemote(t *testing.T) {
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "gitconfig"))
+
+	path := filepath.Join(t.TempDir(), "app")
+	initRepo(t, path, "git@github.com:acme/app.git")
+	if output, err := exec.Command("git", "-C", path, "remote", "add", "weird", "https:///nohost").CombinedOutput(); err != nil {
+		t.Fatalf("failed to add remote: %v: %s", err, output)
+	}
+
+	repo, err := internal.OpenRepo(path, internal.GitSettings{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	remotes, err := repo.GetRepoRemotes()
+	if !internal.IsInvalidRemotes(err) || !strings.Contains(err.Error(), "weird") {
+		t.Errorf("expected the invalid remote to be reported, got %v", err)
+	}
+	if len(remotes) != 1 || remotes[0].Name != "origin" {
+		t.Errorf("expected origin to be kept, got %+v", remotes)
+	}
+
+	profiles := []models.ProfileConfig{{ProfileName: "work", Name: "Work", Email: "work@acme.com", Origin: "github.com/acme/*"}}
+	if plan := internal.PlanRepo(internal.GitSettings{}, profiles, path); plan.Err != nil || plan.Action != internal.PlanApply {
+		t.Errorf("expected work to be applied despite the invalid remote, got %v: %v", plan.Action, plan.Err)
+	}
+	if report := internal.AuditRepo(internal.GitSettings{}, profiles, path, "", true); report.Error != "" {
+		t.Errorf("expected the audit to ignore the invalid remote, got %s", report.Error)
+	}
+
+	if output, err := exec.Command("git", "-C", path, "remote", "remove", "origin").CombinedOutput(); err != nil {
+		t.Fatalf("failed to remove remote: %v: %s", err, output)
+	}
+	if _, err := repo.GetRepoRemotes(); err == nil || internal.IsInvalidRemotes(err) {
+		t.Errorf("expected an error when no remote can be used, got %v", err)
+	}
+}
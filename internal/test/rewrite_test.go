@@ -0,0 +1,141 @@
+// Package test
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/Shieldine/git-profile/internal"
+)
+
+// TestRewriteURL tests insteadOf and pushInsteadOf rewriting.
+func TestRewriteURL(t *testing.T) {
+	rewrites := internal.ParseURLRewrites(
+		"url.git@github.com:.insteadof\ngithub-work:\x00" +
+			"url.git@github.com:acme/.insteadof\ngithub-work:acme/\x00" +
+			"url.ssh://git@example.com/.pushinsteadof\nhttps://example.com/\x00")
+
+	if len(rewrites) != 3 {
+		t.Fatalf("expected 3 rewrite rules, got %d", len(rewrites))
+	}
+
+	tests := []struct {
+		rawURL string
+		push   bool
+		want   string
+	}{
+		{"github-work:team/repo.git", true, "git@github.com:team/repo.git"},
+		{"github-work:acme/repo.git", true, "git@github.com:acme/repo.git"},
+		{"https://example.com/team/repo.git", true, "ssh://git@example.com/team/repo.git"},
+		{"https://example.com/team/repo.git", false, "https://example.com/team/repo.git"},
+		{"https://gitlab.com/team/repo.git", true, "https://gitlab.com/team/repo.git"},
+	}
+
+	for _, test := range tests {
+		if got := internal.RewriteURL(test.rawURL, rewrites, test.push); got != test.want {
+			t.Errorf("RewriteURL(%q, push=%v) = %q, want %q", test.rawURL, test.push, got, test.want)
+		}
+	}
+}
+
+// TestParseSSHHostName tests extracting the hostname from ssh -G output.
+func TestParseSSHHostName(t *testing.T) {
+	output := "user git\nhostname github.com\nport 22\n"
+
+	if got := internal.ParseSSHHostName(output); got != "github.com" {
+		t.Errorf("expected hostname github.com, got %q", got)
+	}
+	if got := internal.ParseSSHHostName("user git\n"); got != "" {
+		t.Errorf("expected no hostname, got %q", got)
+	}
+}
+
+// TestResolveSSHHostOption tests that hosts looking like ssh options are never passed to ssh.
+func TestResolveSSHHostOption(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ssh is a shell script")
+	}
+
+	binDir := t.TempDir()
+	marker := filepath.Join(binDir, "called")
+	script := "#!/bin/sh\ntouch " + marker + "\necho hostname resolved.example.com\n"
+	if err := os.WriteFile(filepath.Join(binDir, "ssh"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	host := "-oProxyCommand=touch pwned"
+	if got := internal.ResolveSSHHost(host); got != host {
+		t.Errorf("expected host %q to stay unresolved, got %q", host, got)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Error("expected ssh not to run for a host starting with a dash")
+	}
+
+	if got := internal.ResolveSSHHost("github-work"); got != "resolved.example.com" {
+		t.Errorf("expected alias to resolve through ssh, got %q", got)
+	}
+}
+
+// TestGetRepoRemoteWithInsteadOf tests that the repository origin is resolved through insteadOf rules.
+func TestGetRepoRemoteWithInsteadOf(t *testing.T) {
+	tempDir, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	originalDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(dir string) {
+		err := os.Chdir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}(originalDir)
+
+	err = os.Chdir(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{
+		{"remote", "add", "origin", "github-work:acme/app.git"},
+		{"config", "url.git@github.com:.insteadOf", "github-work:"},
+	} {
+		if err := exec.Command("git", args...).Run(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	remote, err := currentRepo.GetRepoRemote()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if remote.Host != "github.com" || remote.Owner != "acme" || remote.Repo != "app" {
+		t.Errorf("expected github.com/acme/app, got %+v", remote)
+	}
+
+	origin, err := currentRepo.GetRepoOrigin()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if origin != "github.com" {
+		t.Errorf("expected origin github.com, got %s", origin)
+	}
+}
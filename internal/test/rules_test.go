@@ -0,0 +1,99 @@
+// Package test
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Shieldine/git-profile/internal"
+	"github.com/Shieldine/git-profile/models"
+)
+
+// TestMatchPathGlob tests path glob matching including ** and trailing slashes.
+func TestMatchPathGlob(t *testing.T) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"/work/clients/**", "/work/clients/acme/repo", true},
+		{"/work/clients/**", "/work/clients", true},
+		{"/work/clients/", "/work/clients/acme", true},
+		{"/work/clients/*", "/work/clients/acme", true},
+		{"/work/clients/*", "/work/clients/acme/repo", false},
+		{"/work/**/repo", "/work/clients/acme/repo", true},
+		{"/work/**/repo", "/work/repo", true},
+		{"/work/clients/acme", "/work/clients/acme", true},
+		{"/work/clients/acme", "/work/clients/acme-corp", false},
+		{"/work/clients/acme*", "/work/clients/acme-corp", true},
+		{"~/src/**", filepath.Join(homeDir, "src", "tool"), true},
+		{"/work/**", "/personal/repo", false},
+	}
+
+	for _, test := range tests {
+		if got := internal.MatchPathGlob(test.pattern, test.path); got != test.want {
+			t.Errorf("MatchPathGlob(%q, %q) = %v, want %v", test.pattern, test.path, got, test.want)
+		}
+	}
+}
+
+// TestResolveProfiles tests that path rules and priorities resolve profiles sharing an origin.
+func TestResolveProfiles(t *testing.T) {
+	profiles := []models.ProfileConfig{
+		{ProfileName: "personal", Origin: "github.com"},
+		{ProfileName: "acme", Origin: "github.com", Rules: []models.Rule{{Path: "/work/clients/acme/**"}}},
+		{ProfileName: "globex", Origin: "github.com", Rules: []models.Rule{{Path: "/work/clients/globex/**"}}},
+		{ProfileName: "override", Rules: []models.Rule{{Path: "/work/clients/acme/legacy", Priority: 10}}},
+	}
+	tests := []struct {
+		origin   string
+		repoPath string
+		want     []string
+	}{
+		{"github.com", "/work/clients/acme/app", []string{"acme"}},
+		{"github.com", "/work/clients/globex/app", []string{"globex"}},
+		{"github.com", "/work/clients/acme/legacy", []string{"override"}},
+		{"github.com", "/home/user/project", []string{"personal", "acme", "globex"}},
+		{"gitlab.com", "/work/clients/globex/app", []string{"globex"}},
+		{"gitlab.com", "/home/user/project", nil},
+	}
+
+	for _, test := range tests {
+		resolved := internal.ResolveProfiles(profiles, internal.RemoteURL{Host: test.origin}, test.repoPath)
+
+		var names []string
+		for _, profile := range resolved {
+			names = append(names, profile.ProfileName)
+		}
+
+		if len(names) != len(test.want) {
+			t.Errorf("ResolveProfiles(%q, %q) = %v, want %v", test.origin, test.repoPath, names, test.want)
+			continue
+		}
+		for i := range names {
+			if names[i] != test.want[i] {
+				t.Errorf("ResolveProfiles(%q, %q) = %v, want %v", test.origin, test.repoPath, names, test.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Package internal
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package internal
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Shieldine/git-profile/models"
+)
+
+// Verify policies decide what happens when the identity doesn't match the expected profile.
+const (
+	VerifyPolicyRefuse = "refuse"
+	VerifyPolicyFix    = "fix"
+	VerifyPolicyWarn   = "warn"
+)
+
+// GetVerifyPolicy returns the configured verify policy, refuse if none is set.
+func (c Config) GetVerifyPolicy() (string, error) {
+	if c.VerifyPolicy == "" {
+		return VerifyPolicyRefuse, nil
+	}
+	return ParseVerifyPolicy(c.VerifyPolicy)
+}
+
+// ParseVerifyPolicy validates a verify policy name.
+func ParseVerifyPolicy(policy string) (string, error) {
+	switch strings.ToLower(policy) {
+	case VerifyPolicyRefuse:
+		return VerifyPolicyRefuse, nil
+	case VerifyPolicyFix:
+		return VerifyPolicyFix, nil
+	case VerifyPolicyWarn:
+		return VerifyPolicyWarn, nil
+	}
+	return "", fmt.Errorf("unknown verify policy %q, expected %s, %s or %s",
+		policy, VerifyPolicyRefuse, VerifyPolicyFix, VerifyPolicyWarn)
+}
+
+// Identity is the name and email git records on commits.
+type Identity struct {
+	Name  string `json:"name" yaml:"name"`
+	Email string `json:"email" yaml:"email"`
+}
+
+// Equal reports whether two identities are the same. Emails are compared case-insensitively.
+func (i Identity) Equal(other Identity) bool {
+	return i.Name == other.Name && strings.EqualFold(i.Email, other.Email)
+}
+
+// normalized returns the identity with a lowercased email, for use as map key.
+func (i Identity) normalized() Identity {
+	return Identity{Name: i.Name, Email: strings.ToLower(i.Email)}
+}
+
+// IdentityMatchesProfile reports whether an identity carries the name and email of a profile.
+// Emails are compared case-insensitively.
+func IdentityMatchesProfile(identity Identity, profile models.ProfileConfig) bool {
+	return identity.Equal(Identity{Name: profile.Name, Email: profile.Email})
+}
+
+// VerifyResult is the outcome of comparing the effective identity with the expected profiles.
+type VerifyResult struct {
+	Identity Identity
+	Remote   RepoRemote
+	Expected []models.ProfileConfig
+	Matched  models.ProfileConfig
+}
+
+// HasExpectation reports whether any profile is expected for the repository.
+func (r VerifyResult) HasExpectation() bool {
+	return len(r.Expected) > 0
+}
+
+// OK reports whether the identity is acceptable: either nothing is expected,
+// or the identity belongs to one of the expected profiles.
+func (r VerifyResult) OK() bool {
+	return !r.HasExpectation() || r.Matched.ProfileName != ""
+}
+
+// VerifyIdentity compares the effective author identity with those of the given profiles
+// that are expected for the repository at repoPath.
+// If several profiles tie, the identity of any of them is accepted.
+func (r Repo) VerifyIdentity(profiles []models.ProfileConfig, remotes []RepoRemote, repoPath string) (VerifyResult, error) {
+	effective, err := r.GetEffectiveIdentity()
+	if err != nil {
+		return VerifyResult{}, err
+	}
+	identity := effective.Author()
+
+	expected, remote := ResolveRepoProfiles(profiles, remotes, repoPath)
+	result := VerifyResult{Identity: identity, Remote: remote, Expected: expected}
+
+	for _, profile := range expected {
+		if IdentityMatchesProfile(identity, profile) {
+			result.Matched = profile
+			break
+		}
+	}
+
+	return result, nil
+}
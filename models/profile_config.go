@@ -15,8 +15,14 @@
 package models
 
 type ProfileConfig struct {
-	ProfileName string `toml:"profile_name"`
-	Name        string `toml:"name"`
-	Email       string `toml:"email"`
-	Origin      string `toml:"origin"`
+	ProfileName   string `toml:"profile_name" json:"profile_name" yaml:"profile_name"`
+	Name          string `toml:"name" json:"name" yaml:"name"`
+	Email         string `toml:"email" json:"email" yaml:"email"`
+	Origin        string `toml:"origin" json:"origin" yaml:"origin"`
+	SigningKey    string `toml:"signing_key,omitempty" json:"signing_key,omitempty" yaml:"signing_key,omitempty"`
+	SigningFormat string `toml:"signing_format,omitempty" json:"signing_format,omitempty" yaml:"signing_format,omitempty"`
+	SignCommits   bool   `toml:"sign_commits,omitempty" json:"sign_commits,omitempty" yaml:"sign_commits,omitempty"`
+	SignTags      bool   `toml:"sign_tags,omitempty" json:"sign_tags,omitempty" yaml:"sign_tags,omitempty"`
+	SSHKey        string `toml:"ssh_key,omitempty" json:"ssh_key,omitempty" yaml:"ssh_key,omitempty"`
+	Rules         []Rule `toml:"rules,omitempty" json:"rules,omitempty" yaml:"rules,omitempty"`
 }
@@ -0,0 +1,23 @@
+// Package models
+// Copyright © 2024 Shieldine
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// /*
+package models
+
+// Rule decides when a profile applies to a repository.
+// All conditions that are set have to match. Rules with a higher priority win over lower ones.
+type Rule struct {
+	Path     string `toml:"path,omitempty" json:"path,omitempty" yaml:"path,omitempty"`
+	Origin   string `toml:"origin,omitempty" json:"origin,omitempty" yaml:"origin,omitempty"`
+	Priority int    `toml:"priority,omitempty" json:"priority,omitempty" yaml:"priority,omitempty"`
+}